@@ -0,0 +1,97 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type mediaRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+// parseAccept splits an Accept header into its media ranges.
+func parseAccept(header string) []mediaRange {
+	parts := strings.Split(header, ",")
+	result := make([]mediaRange, 0, len(parts))
+	for _, part := range parts {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		if mt == "" {
+			continue
+		}
+		mr := mediaRange{q: 1.0}
+		if slash := strings.IndexByte(mt, '/'); slash >= 0 {
+			mr.typ, mr.subtype = mt[:slash], mt[slash+1:]
+		} else {
+			mr.typ, mr.subtype = mt, "*"
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					mr.q = q
+				}
+			}
+		}
+		result = append(result, mr)
+	}
+	return result
+}
+
+// specificity returns -1 if the media range does not match the offer,
+// otherwise 0 for */*, 1 for type/* and 2 for type/subtype.
+func (mr *mediaRange) specificity(offer string) int {
+	offer = strings.ToLower(offer)
+	slash := strings.IndexByte(offer, '/')
+	if slash < 0 {
+		return -1
+	}
+	if mr.typ == "*" {
+		return 0
+	}
+	if mr.typ != offer[:slash] {
+		return -1
+	}
+	if mr.subtype == "*" {
+		return 1
+	}
+	if mr.subtype != offer[slash+1:] {
+		return -1
+	}
+	return 2
+}
+
+// negotiateType returns the offer that fits best to the Accept header of the
+// request. The first offer is returned if the header is missing, an empty
+// string if no offer is acceptable. On equal quality the earlier offer wins.
+func negotiateType(r *http.Request, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return offers[0]
+	}
+	ranges := parseAccept(header)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// the most specific matching range defines the quality
+		q, specific := 0.0, -1
+		for i := range ranges {
+			if s := ranges[i].specificity(offer); s > specific {
+				q, specific = ranges[i].q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
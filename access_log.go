@@ -0,0 +1,253 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	mr "math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/wrapper"
+	"github.com/gorilla/mux"
+)
+
+// Access log formats supported by AccessLogWrapper.
+const (
+	CommonLogFormat   = "common"
+	CombinedLogFormat = "combined"
+	JSONLogFormat     = "json"
+)
+
+// AccessLogWrapper writes one line per request to Out, which defaults to
+// os.Stderr. Sample is the fraction of requests logged, from 0 (none) to 1
+// (all, the default if not set), Routes overrides it per route name.
+// Requests with a path starting with one of the Skip prefixes are never
+// logged. X-Forwarded-For is only honoured for requests coming from one of
+// the TrustedProxies, given as IP addresses or CIDR ranges. The request id
+// is the one assigned by a RequestIDWrapper, in front of or behind the
+// access log.
+type AccessLogWrapper struct {
+	Out            io.Writer          `brot:"out"`
+	Format         string             `brot:"format"`
+	Skip           []string           `brot:"skip"`
+	Sample         *float64           `brot:"sample"`
+	Routes         map[string]float64 `brot:"routes"`
+	TrustedProxies []string           `brot:"trustedProxies"`
+	proxies        []*net.IPNet
+	mu             sync.Mutex
+}
+
+type accessLogEntry struct {
+	Time      string  `json:"time"`
+	Remote    string  `json:"remote"`
+	User      string  `json:"user,omitempty"`
+	Method    string  `json:"method"`
+	URI       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Size      int64   `json:"size"`
+	Duration  float64 `json:"duration_ms"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+	Route     string  `json:"route,omitempty"`
+}
+
+func (aw *AccessLogWrapper) InitFunc() (err error) {
+	if aw.Out == nil {
+		aw.Out = os.Stderr
+	}
+	switch aw.Format {
+	case "":
+		aw.Format = CommonLogFormat
+	case CommonLogFormat, CombinedLogFormat, JSONLogFormat:
+	default:
+		return fmt.Errorf("unknown access log format %s", aw.Format)
+	}
+	if aw.Sample != nil && (*aw.Sample < 0 || *aw.Sample > 1) {
+		return fmt.Errorf("access log sample %g is not between 0 and 1", *aw.Sample)
+	}
+	aw.proxies = make([]*net.IPNet, 0, len(aw.TrustedProxies))
+	for _, proxy := range aw.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		var network *net.IPNet
+		if _, network, err = net.ParseCIDR(proxy); err != nil {
+			return
+		}
+		aw.proxies = append(aw.proxies, network)
+	}
+	return
+}
+
+func (aw *AccessLogWrapper) Retry() bool {
+	return false
+}
+
+func (aw *AccessLogWrapper) ServeChain(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if !aw.sampled(r) {
+		next(rw, r)
+		return
+	}
+	w := wrapper.NewResponseWriter(rw)
+	r = r.WithContext(reserveRequestID(r.Context()))
+	defer aw.write(w, r, time.Now())
+	next(w, r)
+}
+
+func (aw *AccessLogWrapper) WrapperFunc() func(http.Handler) http.Handler {
+	return wrapper.Middleware(aw)
+}
+
+// sampled decides whether the request is logged.
+func (aw *AccessLogWrapper) sampled(r *http.Request) bool {
+	for _, prefix := range aw.Skip {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return false
+		}
+	}
+	rate := 1.0
+	if aw.Sample != nil {
+		rate = *aw.Sample
+	}
+	if route := mux.CurrentRoute(r); route != nil && aw.Routes != nil {
+		if specific, ok := aw.Routes[route.GetName()]; ok {
+			rate = specific
+		}
+	}
+	return rate >= 1 || (rate > 0 && mr.Float64() < rate)
+}
+
+func (aw *AccessLogWrapper) trusted(ip net.IP) bool {
+	for _, network := range aw.proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is evaluated
+// from right to left as long as the hops are trusted proxies.
+func (aw *AccessLogWrapper) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !aw.trusted(ip) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		hopIP := net.ParseIP(hop)
+		if hopIP == nil {
+			break
+		}
+		host = hop
+		if !aw.trusted(hopIP) {
+			break
+		}
+	}
+	return host
+}
+
+func (aw *AccessLogWrapper) write(w wrapper.ResponseWriter, r *http.Request, start time.Time) {
+	entry := accessLogEntry{
+		Time:      start.Format(time.RFC3339),
+		Remote:    aw.clientIP(r),
+		Method:    r.Method,
+		URI:       r.RequestURI,
+		Proto:     r.Proto,
+		Status:    w.Status(),
+		Size:      w.Size(),
+		Duration:  float64(time.Since(start).Microseconds()) / 1000,
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+		RequestID: RequestID(r.Context()),
+	}
+	if entry.URI == "" {
+		entry.URI = r.URL.RequestURI()
+	}
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		entry.User = user
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		entry.Route = route.GetName()
+	}
+
+	var buf bytes.Buffer
+	switch aw.Format {
+	case JSONLogFormat:
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(&entry)
+	default:
+		buf.WriteString(entry.Remote)
+		buf.WriteString(" - ")
+		buf.WriteString(clfValue(entry.User))
+		buf.WriteString(" [")
+		buf.WriteString(start.Format("02/Jan/2006:15:04:05 -0700"))
+		buf.WriteString(`] "`)
+		buf.WriteString(entry.Method)
+		buf.WriteByte(' ')
+		buf.WriteString(clfEscape(entry.URI))
+		buf.WriteByte(' ')
+		buf.WriteString(entry.Proto)
+		buf.WriteString(`" `)
+		buf.WriteString(strconv.Itoa(entry.Status))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(entry.Size, 10))
+		if aw.Format == CombinedLogFormat {
+			buf.WriteString(` "`)
+			buf.WriteString(clfEscape(clfValue(entry.Referer)))
+			buf.WriteString(`" "`)
+			buf.WriteString(clfEscape(clfValue(entry.UserAgent)))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('\n')
+	}
+
+	aw.mu.Lock()
+	aw.Out.Write(buf.Bytes())
+	aw.mu.Unlock()
+}
+
+func clfValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// clfEscape escapes quotes and control characters of quoted fields.
+func clfEscape(value string) string {
+	quoted := strconv.Quote(value)
+	return quoted[1 : len(quoted)-1]
+}
+
+var _ di.ProvidesInit = (*AccessLogWrapper)(nil)
+var _ wrapper.Handler = (*AccessLogWrapper)(nil)
+var _ ProvidesWrapper = (*AccessLogWrapper)(nil)
+var _ = di.GlobalScope.Declare((*AccessLogWrapper)(nil))
@@ -0,0 +1,213 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAccessLogClientIP(t *testing.T) {
+	aw := &AccessLogWrapper{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "fd00::1"}}
+	if err := aw.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	tables := []struct {
+		remote    string
+		forwarded []string
+		expected  string
+	}{
+		{"203.0.113.7:1234", nil, "203.0.113.7"},
+		// untrusted peers cannot forge the client
+		{"203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"10.1.2.3:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"10.1.2.3:1234", []string{"198.51.100.1, 192.168.1.1"}, "198.51.100.1"},
+		{"10.1.2.3:1234", []string{"198.51.100.1", "192.168.1.1"}, "198.51.100.1"},
+		// the first untrusted hop from the right is the client
+		{"10.1.2.3:1234", []string{"6.6.6.6, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"10.1.2.3:1234", []string{"garbage, 198.51.100.1"}, "198.51.100.1"},
+		{"10.1.2.3:1234", []string{"198.51.100.1, garbage"}, "10.1.2.3"},
+		{"192.168.1.2:1234", []string{"198.51.100.1"}, "192.168.1.2"},
+		{"[fd00::1]:1234", []string{"2001:db8::5"}, "2001:db8::5"},
+		{"pipe", []string{"198.51.100.1"}, "pipe"},
+	}
+	for _, table := range tables {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = table.remote
+		for _, value := range table.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if found := aw.clientIP(r); found != table.expected {
+			t.Errorf("%s %v: expected %s, found %s", table.remote, table.forwarded, table.expected, found)
+		}
+	}
+
+	invalid := &AccessLogWrapper{TrustedProxies: []string{"10.0.0.0/33"}}
+	if err := invalid.InitFunc(); err == nil {
+		t.Error("expected invalid proxy error")
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	tables := []struct {
+		format   string
+		expected *regexp.Regexp
+	}{
+		{"", regexp.MustCompile(`^203\.0\.113\.7 - ann \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /a\?b=\\"c\\" HTTP/1\.1" 201 5\n$`)},
+		{CombinedLogFormat, regexp.MustCompile(`^203\.0\.113\.7 - ann \[.+\] "GET /a\?b=\\"c\\" HTTP/1\.1" 201 5 "-" "agent \\"x\\""\n$`)},
+	}
+	// the request id is assigned behind the access log with a custom header
+	ids := &RequestIDWrapper{Header: "X-Trace-ID"}
+	if err := ids.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ids.ServeChain(w, r, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("hello"))
+		})
+	}
+	request := func() *http.Request {
+		r := httptest.NewRequest("GET", `/a?b="c"`, nil)
+		r.Header.Set("X-Trace-ID", "req-1")
+		r.RemoteAddr = "203.0.113.7:1234"
+		r.SetBasicAuth("ann", "secret")
+		r.Header.Set("User-Agent", `agent "x"`)
+		return r
+	}
+	for _, table := range tables {
+		var buf bytes.Buffer
+		aw := &AccessLogWrapper{Out: &buf, Format: table.format}
+		if err := aw.InitFunc(); err != nil {
+			t.Fatal(err)
+		}
+		aw.ServeChain(httptest.NewRecorder(), request(), handler)
+		if !table.expected.MatchString(buf.String()) {
+			t.Errorf("%s: unexpected line %q", table.format, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	aw := &AccessLogWrapper{Out: &buf, Format: JSONLogFormat}
+	if err := aw.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	aw.ServeChain(httptest.NewRecorder(), request(), handler)
+	var entry accessLogEntry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Remote != "203.0.113.7" || entry.User != "ann" || entry.URI != `/a?b="c"` || entry.Status != 201 ||
+		entry.Size != 5 || entry.RequestID != "req-1" || entry.UserAgent != `agent "x"` {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	if err := (&AccessLogWrapper{Format: "apache"}).InitFunc(); err == nil {
+		t.Error("expected unknown format error")
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	none, all := 0.0, 1.0
+	tables := []struct {
+		sample   *float64
+		routes   map[string]float64
+		path     string
+		expected int
+	}{
+		{nil, nil, "/posts", 10},
+		{&all, nil, "/posts", 10},
+		{&none, nil, "/posts", 0},
+		{&none, map[string]float64{"posts": 1}, "/posts", 10},
+		{nil, map[string]float64{"posts": 0}, "/posts", 0},
+		{nil, nil, "/health", 0},
+	}
+	for i, table := range tables {
+		var buf bytes.Buffer
+		aw := &AccessLogWrapper{Out: &buf, Sample: table.sample, Routes: table.routes, Skip: []string{"/health"}}
+		if err := aw.InitFunc(); err != nil {
+			t.Fatal(err)
+		}
+		router := mux.NewRouter()
+		router.Use(aw.WrapperFunc())
+		ok := func(w http.ResponseWriter, r *http.Request) {}
+		router.HandleFunc("/posts", ok).Name("posts")
+		router.HandleFunc("/health", ok)
+		for n := 0; n < 10; n++ {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", table.path, nil))
+		}
+		if found := strings.Count(buf.String(), "\n"); found != table.expected {
+			t.Errorf("%d: expected %d lines, found %d", i, table.expected, found)
+		}
+	}
+
+	invalid := 1.5
+	if err := (&AccessLogWrapper{Sample: &invalid}).InitFunc(); err == nil {
+		t.Error("expected invalid sample error")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotating")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	rf := &RotatingFile{Path: path, MaxSize: 1, MaxBackups: 2}
+	if err = rf.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	chunk := make([]byte, 600<<10)
+	for i, c := range "abcd" {
+		for j := range chunk {
+			chunk[j] = byte(c)
+		}
+		if _, err = rf.Write(chunk); err != nil {
+			t.Fatalf("write %d: %s", i, err)
+		}
+	}
+	// each chunk exceeds the remaining space of the file before it
+	expected := map[string]byte{"access.log": 'd', "access.log.1": 'c', "access.log.2": 'b'}
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != len(expected) {
+		t.Errorf("expected %d files, found %v", len(expected), files)
+	}
+	for name, c := range expected {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || len(content) != len(chunk) || content[0] != c {
+			t.Errorf("%s: expected %d bytes of %c, found %d (%v)", name, len(chunk), c, len(content), err)
+		}
+	}
+
+	// an existing file counts towards the size
+	if err = rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rf = &RotatingFile{Path: path, MaxSize: 1}
+	if err = rf.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rf.Write(chunk); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(path); len(content) != len(chunk) {
+		t.Errorf("expected rotated file, found %d bytes", len(content))
+	}
+	if content, _ := ioutil.ReadFile(path + ".1"); content[0] != 'c' {
+		t.Error("expected backups to be kept without MaxBackups")
+	}
+}
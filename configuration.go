@@ -6,8 +6,8 @@ package brot
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
 
 	"github.com/fuxsig/brot/di"
 )
@@ -68,23 +68,29 @@ func map2array(m *map[string]string) (result []string) {
 }
 
 func (c Configuration) Process() {
+	l := logger("configuration")
 	// initialize all handler objects
 	for _, handler := range c.Handlers {
 		if handler.StructName != "" && handler.FuncName != "" {
-			log.Panicf("Invalid configuration, please use either struct or func. Current values are struct '%s' and func '%s'", handler.StructName, handler.FuncName)
+			msg := fmt.Sprintf("Invalid configuration, please use either struct or func. Current values are struct '%s' and func '%s'", handler.StructName, handler.FuncName)
+			l.Error(msg)
+			panic(msg)
 		}
 		if handler.StructName == "" && handler.FuncName == "" {
-			log.Panic("Invalid configuration, please set at least struct or func.")
+			msg := "Invalid configuration, please set at least struct or func."
+			l.Error(msg)
+			panic(msg)
 		}
 		if handler.StructName != "" {
 			if _, err := di.GlobalScope.New(handler.Name, handler.StructName, handler.Args); err == nil {
-				log.Printf("Created successfully struct %s of type %s", handler.Name, handler.StructName)
+				l.Info("created object", "name", handler.Name, "struct", handler.StructName)
 			} else {
-				log.Printf("Could not create object %s. Reason: %s", handler.Name, err.Error())
+				l.Error("could not create object", "name", handler.Name, "struct", handler.StructName, "error", err)
 			}
 
 		} else {
-			if _, err := di.GlobalScope.Call(handler.Name, handler.FuncName, handler.Args); err == nil {
+			if _, err := di.GlobalScope.Call(handler.Name, handler.FuncName, handler.Args); err != nil {
+				l.Error("could not call constructor", "name", handler.Name, "func", handler.FuncName, "error", err)
 			}
 		}
 	}
@@ -0,0 +1,16 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+// contextKey is the type of all values brot stores in a request context.
+type contextKey int
+
+const (
+	errorRendererKey contextKey = iota
+	logAttrsKey
+	requestIDKey
+	localeKey
+	identityKey
+)
@@ -6,8 +6,10 @@ package brot
 
 import (
 	"net/http"
+	"sort"
 
 	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/model"
 	"github.com/gorilla/mux"
 )
 
@@ -26,9 +28,19 @@ type ValueProvider interface {
 	CapacityReco() int
 }
 
+// DataLayer collects the input values of a handler from its providers.
+// Values supplied by several providers are appended in provider order,
+// Merge changes this default to first or last and Precedence overrides it
+// by name. Transforms lists the transforms applied to a value after
+// merging, e.g. "tags": ["split", "trim", "lowercase"]. Rules constrain
+// the values by name, see Parse. A nil DataLayer provides no values.
 type DataLayer struct {
-	Providers []ValueProvider `brot:"providers"`
-	capacity  int
+	Providers  []ValueProvider       `brot:"providers"`
+	Merge      string                `brot:"merge"`
+	Precedence map[string]string     `brot:"precedence"`
+	Transforms map[string][]string   `brot:"transforms"`
+	Rules      map[string]*ValueRule `brot:"rules"`
+	capacity   int
 }
 
 func (dl *DataLayer) InitFunc() (err error) {
@@ -36,6 +48,24 @@ func (dl *DataLayer) InitFunc() (err error) {
 	for _, p := range dl.Providers {
 		dl.capacity += p.CapacityReco()
 	}
+	if err = checkPrecedence("merge", dl.Merge); err != nil {
+		return
+	}
+	for name, precedence := range dl.Precedence {
+		if err = checkPrecedence(name, precedence); err != nil {
+			return
+		}
+	}
+	for name, transforms := range dl.Transforms {
+		if err = checkTransforms(name, transforms); err != nil {
+			return
+		}
+	}
+	for name, rule := range dl.Rules {
+		if err = rule.initialize(name); err != nil {
+			return
+		}
+	}
 	return
 }
 
@@ -43,14 +73,103 @@ func (dl *DataLayer) Retry() bool {
 	return false
 }
 
+// extend returns a copy of dl to which a handler adds the rules of the
+// values it reads, unless they are configured, and providers, which come
+// after the configured ones. The copy must be initialized.
+func (dl *DataLayer) extend(rules map[string]*ValueRule, providers ...ValueProvider) *DataLayer {
+	result := &DataLayer{}
+	if dl != nil {
+		*result = *dl
+	}
+	result.Providers = append(append([]ValueProvider(nil), result.Providers...), providers...)
+	precedence := make(map[string]string, len(result.Precedence))
+	for name, value := range result.Precedence {
+		precedence[name] = value
+	}
+	result.Precedence = precedence
+	configured := result.Rules
+	result.Rules = make(map[string]*ValueRule, len(configured)+len(rules))
+	for name, rule := range rules {
+		result.Rules[name] = rule
+	}
+	for name, rule := range configured {
+		result.Rules[name] = rule
+	}
+	return result
+}
+
+// Values returns the merged and transformed values of the request. The
+// slices of the result are owned by the caller.
 func (dl *DataLayer) Values(request *http.Request) (result MultiValueMap) {
+	if dl == nil {
+		return MultiValueMap{}
+	}
 	result = make(MultiValueMap, dl.capacity)
 	for _, p := range dl.Providers {
-		p.Initialize(request, result)
+		// a fresh map per provider, so that precedence can be applied
+		provided := make(map[string][]string, p.CapacityReco())
+		p.Initialize(request, provided)
+		for name, values := range provided {
+			if len(values) == 0 {
+				continue
+			}
+			precedence, ok := dl.Precedence[name]
+			if !ok {
+				precedence = dl.Merge
+			}
+			switch precedence {
+			case FirstValues:
+				if _, ok = result[name]; !ok {
+					result[name] = append([]string(nil), values...)
+				}
+			case LastValues:
+				result[name] = append([]string(nil), values...)
+			default:
+				result[name] = append(result[name], values...)
+			}
+		}
+	}
+	for name, transforms := range dl.Transforms {
+		if values, ok := result[name]; ok {
+			if values = transform(values, transforms); len(values) > 0 {
+				result[name] = values
+			} else {
+				delete(result, name)
+			}
+		}
 	}
 	return
 }
 
+// Parse returns the values of the request like Values, checked against the
+// rules. Missing values are set to their default, the others are converted
+// to their canonical form, e.g. 007 to 7 for an int. All violations are
+// returned in one *model.ValidationError.
+func (dl *DataLayer) Parse(request *http.Request) (MultiValueMap, error) {
+	result := dl.Values(request)
+	if dl == nil {
+		return result, nil
+	}
+	var ve model.ValidationError
+	for name, rule := range dl.Rules {
+		values := result[name]
+		if len(values) == 0 {
+			if rule.Default != "" {
+				result[name] = []string{rule.Default}
+			} else if rule.Required {
+				ve.Add(name, model.RequiredViolation, "%s is required", name)
+			}
+			continue
+		}
+		rule.check(name, values, &ve)
+	}
+	if ve.Fields != nil {
+		sort.SliceStable(ve.Fields, func(i, j int) bool { return ve.Fields[i].Field < ve.Fields[j].Field })
+		return result, &ve
+	}
+	return result, nil
+}
+
 var _ di.ProvidesInit = (*DataLayer)(nil)
 var _ = di.GlobalScope.Declare((*DataLayer)(nil))
 
@@ -106,6 +225,54 @@ func (ue *URLParameterProvider) CapacityReco() int {
 var _ ValueProvider = (*URLParameterProvider)(nil)
 var _ = di.GlobalScope.Declare((*URLParameterProvider)(nil))
 
+// ContextProvider provides values stored in the request context. Source
+// keys are requestId, user and groups, the latter two of the identity of
+// the request, see IdentityWrapper.
+type ContextProvider struct {
+	Mapping map[string]string `brot:"mapping"`
+}
+
+func (cp *ContextProvider) Initialize(request *http.Request, m map[string][]string) {
+	for key, value := range cp.Mapping {
+		var parameters []string
+		switch key {
+		case "requestId":
+			parameters = []string{RequestID(request.Context())}
+		case "user":
+			parameters = []string{Identity(request.Context()).User}
+		case "groups":
+			parameters = Identity(request.Context()).Groups()
+		}
+		for _, parameter := range parameters {
+			if parameter != "" {
+				m[value] = append(m[value], parameter)
+			}
+		}
+	}
+}
+
+func (cp *ContextProvider) CapacityReco() int {
+	return len(cp.Mapping)
+}
+
+var _ ValueProvider = (*ContextProvider)(nil)
+var _ = di.GlobalScope.Declare((*ContextProvider)(nil))
+
+// ConstValuesProvider provides constant values. Unlike the other providers
+// Mapping maps the name of the DataLayer value to its constant.
 type ConstValuesProvider struct {
 	Mapping map[string]string `brot:"mapping"`
 }
+
+func (cp *ConstValuesProvider) Initialize(request *http.Request, m map[string][]string) {
+	for key, value := range cp.Mapping {
+		m[key] = append(m[key], value)
+	}
+}
+
+func (cp *ConstValuesProvider) CapacityReco() int {
+	return len(cp.Mapping)
+}
+
+var _ ValueProvider = (*ConstValuesProvider)(nil)
+var _ = di.GlobalScope.Declare((*ConstValuesProvider)(nil))
@@ -0,0 +1,119 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/fuxsig/brot/model"
+)
+
+func TestDataLayerParse(t *testing.T) {
+	one, ten := 1.0, 10.0
+	dl := &DataLayer{
+		Providers: []ValueProvider{&URLParameterProvider{Mapping: map[string]string{
+			"limit": "limit", "order": "order", "flag": "flag", "name": "name"}}},
+		Rules: map[string]*ValueRule{
+			"limit": {Type: IntValue, Min: &one, Max: &ten},
+			"order": {Type: EnumValue, Values: []string{"asc", "desc"}, Default: "asc"},
+			"flag":  {Type: BoolValue},
+			"id":    {Required: true},
+			"name":  {Type: RegexValue, Pattern: "^[a-z]+$", MaxCount: 1},
+		},
+	}
+	if err := dl.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := dl.Parse(httptest.NewRequest("GET", "/?limit=007&flag=1&name=abc", nil))
+	ve, ok := err.(*model.ValidationError)
+	if !ok || len(ve.Fields) != 1 || ve.Fields[0].Field != "id" || ve.Fields[0].Code != model.RequiredViolation {
+		t.Fatalf("expected missing id, found %v", err)
+	}
+	if v, _ := values.Int("limit"); v != 7 {
+		t.Errorf("expected limit 7, found %v", values["limit"])
+	}
+	if v, _ := values.Bool("flag"); !v || values["flag"][0] != "true" {
+		t.Errorf("expected canonical flag true, found %v", values["flag"])
+	}
+	if v, _ := values.Get("order"); v != "asc" {
+		t.Errorf("expected default order asc, found %s", v)
+	}
+
+	_, err = dl.Parse(httptest.NewRequest("GET", "/?limit=11&order=up&flag=x&name=a&name=B", nil))
+	ve, ok = err.(*model.ValidationError)
+	if !ok {
+		t.Fatalf("expected model.ValidationError, found %v", err)
+	}
+	codes := make([]string, len(ve.Fields))
+	for i, field := range ve.Fields {
+		codes[i] = field.Field + ":" + field.Code
+	}
+	expected := []string{"flag:type", "id:required", "limit:max", "name:count", "name:pattern", "order:enum"}
+	if !reflect.DeepEqual(codes, expected) {
+		t.Errorf("expected %v, found %v", expected, codes)
+	}
+}
+
+func TestValueRuleInitialize(t *testing.T) {
+	tables := []struct {
+		rule ValueRule
+		ok   bool
+	}{
+		{ValueRule{}, true},
+		{ValueRule{Type: "date"}, false},
+		{ValueRule{Type: EnumValue}, false},
+		{ValueRule{Type: RegexValue, Pattern: "("}, false},
+		{ValueRule{Type: IntValue, Default: "ten"}, false},
+		{ValueRule{Type: IntValue, Default: "10"}, true},
+	}
+	for _, table := range tables {
+		if err := table.rule.initialize("test"); (err == nil) != table.ok {
+			t.Errorf("rule %+v: expected ok %v, found %v", table.rule, table.ok, err)
+		}
+	}
+}
+
+func TestDataLayerMerge(t *testing.T) {
+	path := &PathRegexpProvider{Regexp: `^(?P<id>[0-9]+)-(?P<slug>[a-z-]+)\.html$`, Base: true,
+		Mapping: map[string]string{"slug": "name"}}
+	dl := &DataLayer{
+		Providers: []ValueProvider{
+			path,
+			&URLParameterProvider{Mapping: map[string]string{"id": "id", "tags": "tags", "name": "name"}},
+			&ConstValuesProvider{Mapping: map[string]string{"tags": "News"}},
+		},
+		Precedence: map[string]string{"id": FirstValues, "name": LastValues},
+		Transforms: map[string][]string{"tags": {SplitTransform, TrimTransform, LowercaseTransform}},
+	}
+	if err := path.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dl.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	values := dl.Values(httptest.NewRequest("GET", "/pages/42-hello.html?id=7&name=query&tags=Go,+Web,,", nil))
+	expected := MultiValueMap{
+		"id":   {"42"},
+		"name": {"query"},
+		"tags": {"go", "web", "news"},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, found %v", expected, values)
+	}
+
+	if err := (&DataLayer{Transforms: map[string][]string{"x": {"reverse"}}}).InitFunc(); err == nil {
+		t.Error("expected error for unknown transform")
+	}
+	if err := (&DataLayer{Merge: "random"}).InitFunc(); err == nil {
+		t.Error("expected error for unknown precedence")
+	}
+	var nilLayer *DataLayer
+	if values, err := nilLayer.Parse(httptest.NewRequest("GET", "/", nil)); err != nil || len(values) != 0 {
+		t.Errorf("expected no values, found %v %v", values, err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Transforms applicable to DataLayer values.
+const (
+	LowercaseTransform = "lowercase"
+	UppercaseTransform = "uppercase"
+	TrimTransform      = "trim"
+	SplitTransform     = "split"
+)
+
+// Precedences deciding how values supplied by several providers are merged.
+const (
+	// AppendValues keeps the values of all providers in provider order.
+	AppendValues = "append"
+	// FirstValues keeps the values of the first provider supplying the key.
+	FirstValues = "first"
+	// LastValues keeps the values of the last provider supplying the key.
+	LastValues = "last"
+)
+
+func checkTransforms(name string, transforms []string) error {
+	for _, transform := range transforms {
+		switch transform {
+		case LowercaseTransform, UppercaseTransform, TrimTransform, SplitTransform:
+		default:
+			return fmt.Errorf("transform %s: unknown transform %s", name, transform)
+		}
+	}
+	return nil
+}
+
+func checkPrecedence(name, precedence string) error {
+	switch precedence {
+	case "", AppendValues, FirstValues, LastValues:
+		return nil
+	}
+	return fmt.Errorf("precedence %s: unknown precedence %s", name, precedence)
+}
+
+// transform applies the transforms in order. Split separates comma
+// separated lists into single values. Values ending up empty are dropped.
+func transform(values []string, transforms []string) []string {
+	for _, t := range transforms {
+		result := values[:0:0]
+		for _, value := range values {
+			switch t {
+			case LowercaseTransform:
+				result = append(result, strings.ToLower(value))
+			case UppercaseTransform:
+				result = append(result, strings.ToUpper(value))
+			case TrimTransform:
+				result = append(result, strings.TrimSpace(value))
+			case SplitTransform:
+				result = append(result, strings.Split(value, ",")...)
+			}
+		}
+		values = result
+	}
+	result := values[:0]
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/fuxsig/brot/model"
+)
+
+// Value types supported by ValueRule.
+const (
+	StringValue = "string"
+	IntValue    = "int"
+	BoolValue   = "bool"
+	FloatValue  = "float"
+	EnumValue   = "enum"
+	RegexValue  = "regex"
+)
+
+// ValueRule constrains a DataLayer value. Type is one of string (default),
+// int, bool, float, enum or regex. Min and Max bound numbers by value and
+// all other types by length. Values lists the choices of an enum, Pattern
+// the expression of a regex. Default is used if no provider supplies the
+// value, MaxCount limits the number of values.
+type ValueRule struct {
+	Required bool     `brot:"required"`
+	Type     string   `brot:"type"`
+	Min      *float64 `brot:"min"`
+	Max      *float64 `brot:"max"`
+	Default  string   `brot:"default"`
+	MaxCount int      `brot:"maxCount"`
+	Values   []string `brot:"values"`
+	Pattern  string   `brot:"pattern"`
+	re       *regexp.Regexp
+	enum     map[string]bool
+}
+
+// fieldErrors returns the violations of a model.ValidationError wrapped by
+// err.
+func fieldErrors(err error) []model.FieldError {
+	var ve *model.ValidationError
+	if errors.As(err, &ve) {
+		return ve.Fields
+	}
+	return nil
+}
+
+func (vr *ValueRule) initialize(name string) (err error) {
+	switch vr.Type {
+	case "":
+		vr.Type = StringValue
+	case StringValue, IntValue, BoolValue, FloatValue:
+	case EnumValue:
+		if len(vr.Values) == 0 {
+			return fmt.Errorf("rule %s: enum without values", name)
+		}
+		vr.enum = make(map[string]bool, len(vr.Values))
+		for _, value := range vr.Values {
+			vr.enum[value] = true
+		}
+	case RegexValue:
+		if vr.re, err = regexp.Compile(vr.Pattern); err != nil {
+			return fmt.Errorf("rule %s: %s", name, err.Error())
+		}
+	default:
+		return fmt.Errorf("rule %s: unknown type %s", name, vr.Type)
+	}
+	if vr.Default != "" {
+		var check model.ValidationError
+		vr.check(name, []string{vr.Default}, &check)
+		if check.Fields != nil {
+			return fmt.Errorf("rule %s: invalid default: %s", name, check.Fields[0].Message)
+		}
+	}
+	return
+}
+
+// check validates values and replaces them by their canonical form.
+func (vr *ValueRule) check(name string, values []string, ve *model.ValidationError) {
+	if vr.MaxCount > 0 && len(values) > vr.MaxCount {
+		ve.Add(name, model.CountViolation, "%s accepts at most %d values", name, vr.MaxCount)
+	}
+	for i, value := range values {
+		var (
+			size float64
+			ok   = true
+		)
+		switch vr.Type {
+		case IntValue:
+			var v int64
+			if v, ok = parseInt(value); ok {
+				values[i] = strconv.FormatInt(v, 10)
+				size = float64(v)
+			}
+		case FloatValue:
+			var err error
+			if size, err = strconv.ParseFloat(value, 64); err == nil {
+				values[i] = strconv.FormatFloat(size, 'f', -1, 64)
+			} else {
+				ok = false
+			}
+		case BoolValue:
+			var v bool
+			var err error
+			if v, err = strconv.ParseBool(value); err == nil {
+				values[i] = strconv.FormatBool(v)
+			} else {
+				ve.Add(name, model.TypeViolation, "%s must be of type %s", name, vr.Type)
+			}
+			// bounds make no sense for booleans
+			continue
+		case EnumValue:
+			if !vr.enum[value] {
+				ve.Add(name, model.EnumViolation, "%s must be one of %v", name, vr.Values)
+				continue
+			}
+			size = float64(utf8.RuneCountInString(value))
+		case RegexValue:
+			if !vr.re.MatchString(value) {
+				ve.Add(name, model.PatternViolation, "%s does not match %s", name, vr.Pattern)
+				continue
+			}
+			size = float64(utf8.RuneCountInString(value))
+		default:
+			size = float64(utf8.RuneCountInString(value))
+		}
+		if !ok {
+			ve.Add(name, model.TypeViolation, "%s must be of type %s", name, vr.Type)
+			continue
+		}
+		if vr.Min != nil && size < *vr.Min {
+			ve.Add(name, model.MinViolation, "%s is below the minimum %v", name, *vr.Min)
+		}
+		if vr.Max != nil && size > *vr.Max {
+			ve.Add(name, model.MaxViolation, "%s is above the maximum %v", name, *vr.Max)
+		}
+	}
+}
+
+func parseInt(value string) (int64, bool) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	return v, err == nil
+}
+
+// Int returns the first value as int.
+func (de MultiValueMap) Int(name string) (int, bool) {
+	if str, ok := de.Get(name); ok {
+		if v, err := strconv.Atoi(str); err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// Float returns the first value as float64.
+func (de MultiValueMap) Float(name string) (float64, bool) {
+	if str, ok := de.Get(name); ok {
+		if v, err := strconv.ParseFloat(str, 64); err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// Bool returns the first value as bool.
+func (de MultiValueMap) Bool(name string) (bool, bool) {
+	if str, ok := de.Get(name); ok {
+		if v, err := strconv.ParseBool(str); err == nil {
+			return v, true
+		}
+	}
+	return false, false
+}
@@ -5,7 +5,7 @@
 package di
 
 import (
-	"log"
+	"fmt"
 	"reflect"
 )
 
@@ -22,7 +22,9 @@ func NewDynamicFunc(f interface{}, argNames []string) (result *DynamicFunc) {
 	t := v.Type()
 	num := t.NumIn()
 	if num != len(argNames) {
-		log.Panicf("Number of func arguments and passed names does not match. Expected %d, received %d", num, len(argNames))
+		msg := fmt.Sprintf("Number of func arguments and passed names does not match. Expected %d, received %d", num, len(argNames))
+		logger().Error(msg)
+		panic(msg)
 	}
 	result = &DynamicFunc{}
 	result.index = 0
@@ -83,7 +85,9 @@ func (df *DynamicFunc) Call(args map[string]interface{}) (result interface{}) {
 	in := make([]reflect.Value, len(df.fills))
 	for i, f := range df.fills {
 		if in[i], err = f(args); err != nil {
-			log.Panicf("Could not set value: %s", err.Error())
+			msg := fmt.Sprintf("Could not set value: %s", err.Error())
+			logger().Error(msg)
+			panic(msg)
 		}
 	}
 	out := df.funk.Call(in)
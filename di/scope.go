@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"reflect"
 	"regexp"
@@ -34,6 +35,12 @@ type Scope struct {
 
 var GlobalScope = NewScope()
 
+// logger returns the logger of the di package. It is looked up on every call,
+// because the default logger is usually replaced during configuration.
+func logger() *slog.Logger {
+	return slog.Default().With("component", "di")
+}
+
 func NewScope() (result *Scope) {
 	result = new(Scope)
 	result.types = make(map[string]reflect.Type, 0)
@@ -184,7 +191,7 @@ func (scope *Scope) assignValue(dest reflect.Value, src interface{}) error {
 					me.Merge(scope.assignValue(sf, val))
 				} else {
 					if mandatory {
-						log.Printf("Mandatory value for %s not defined in configuration", name)
+						logger().Warn("mandatory value not defined in configuration", "field", name, "type", t.String())
 					}
 				}
 			}
@@ -197,10 +204,10 @@ func (scope *Scope) assignValue(dest reflect.Value, src interface{}) error {
 				r := true
 				for r {
 					if err := aux.InitFunc(); err != nil {
-						log.Printf("Error in init: %s\n", err.Error())
+						logger().Error("error in init", "type", t.String(), "error", err)
 						r = aux.Retry()
 						if r {
-							log.Printf("Retrying init\n")
+							logger().Info("retrying init", "type", t.String())
 						}
 					} else {
 						break
@@ -209,7 +216,7 @@ func (scope *Scope) assignValue(dest reflect.Value, src interface{}) error {
 			}
 		} else {
 
-			log.Printf("Expected a source value of type map[string]interface{}, found %s", reflect.TypeOf(src).String())
+			logger().Warn("expected a source value of type map[string]interface{}", "type", t.String(), "found", fmt.Sprintf("%T", src))
 
 		}
 	case reflect.Ptr:
@@ -236,11 +243,11 @@ func (scope *Scope) assignValue(dest reflect.Value, src interface{}) error {
 						if elem.Type() == dest.Type() {
 							pptr.Set(elem)
 						} else {
-							log.Printf("Cannot assign value. Expecting type %s, %s is %s", dest.Type().Name(), str, elem.Type().Name())
+							logger().Warn("cannot assign value", "expected", dest.Type().String(), "object", str, "found", elem.Type().String())
 						}
 
 					} else {
-						log.Printf("Cannot find object %s", str)
+						logger().Warn("cannot find object", "object", str)
 					}
 				} else {
 					me.Append(err)
@@ -275,7 +282,7 @@ func (scope *Scope) assignValue(dest reflect.Value, src interface{}) error {
 									dest.Set(elem)
 								} else {
 
-									log.Printf("Object does not implement interface %s", dest.Type().Name())
+									logger().Warn("object does not implement interface", "struct", sn, "interface", dest.Type().String())
 
 								}
 							} else {
@@ -302,11 +309,11 @@ func (scope *Scope) assignValue(dest reflect.Value, src interface{}) error {
 						dest.Set(elem)
 					} else {
 
-						log.Printf("Object does not implement interface %s", dest.Type().Name())
+						logger().Warn("object does not implement interface", "object", str, "interface", dest.Type().String())
 
 					}
 				} else {
-					log.Printf("Cannot find object %s", str)
+					logger().Warn("cannot find object", "object", str)
 				}
 			} else {
 				me.Append(err)
@@ -314,7 +321,7 @@ func (scope *Scope) assignValue(dest reflect.Value, src interface{}) error {
 		}
 	default:
 		sft := dest.Type()
-		log.Printf("Unsupported type for field %s", sft.String())
+		logger().Warn("unsupported type for field", "type", sft.String())
 	}
 	return me.ErrorOrNil()
 }
@@ -351,7 +358,9 @@ func (scope *Scope) Declare(object interface{}) interface{} {
 			t = t.Elem()
 		}
 		if t.Kind() != reflect.Struct {
-			log.Panicf("Expected a struct but received %s", t.Name())
+			msg := fmt.Sprintf("Expected a struct but received %s", t.Name())
+			logger().Error(msg)
+			panic(msg)
 		}
 		scope.types[t.String()] = t
 	} else if t.Kind() == reflect.Func {
@@ -413,6 +422,7 @@ func (scope *Scope) Call(objName string, funcName string, args map[string]interf
 
 func (scope *Scope) RegisterDefaults() *Scope {
 	scope.objects["os.Stdout"] = os.Stdout
+	scope.objects["os.Stderr"] = os.Stderr
 	scope.funcs["log.New"] = NewDynamicFunc(log.New, []string{"out", "prefix", "flag"})
 	return scope
 }
@@ -5,20 +5,44 @@
 package brot
 
 import (
-	"crypto/sha256"
-	"fmt"
+	"bytes"
 	"html/template"
-	"io"
-	"log"
 	"net/http"
+	"os"
 	"path"
 	"path/filepath"
 
 	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/model"
 )
 
+// DynamicFileHandler executes the file at the request path below Dir as
+// template with the email and given name of the session. Templates are
+// cached by Cache and parsed with Funcs, which default to the funcs of
+// TemplateFuncs with Model.
 type DynamicFileHandler struct {
-	Dir string `brot:"dir"`
+	Dir   string         `brot:"dir"`
+	Model *model.Butter  `brot:"model"`
+	Cache *TemplateCache `brot:"cache"`
+	Funcs *TemplateFuncs `brot:"funcs"`
+}
+
+func (dh *DynamicFileHandler) InitFunc() error {
+	if dh.Funcs == nil {
+		dh.Funcs = &TemplateFuncs{Model: dh.Model}
+	}
+	return nil
+}
+
+func (dh *DynamicFileHandler) Retry() bool {
+	return false
+}
+
+func (dh *DynamicFileHandler) cache() *TemplateCache {
+	if dh.Cache == nil {
+		return defaultTemplateCache
+	}
+	return dh.Cache
 }
 
 func (dh *DynamicFileHandler) HandlerFunc() http.Handler {
@@ -30,37 +54,40 @@ func (dh *DynamicFileHandler) HandlerFunc() http.Handler {
 
 		session, err := sessionStore.Get(r, "brot-store")
 		if err != nil {
-			log.Printf("DynamicFileHandler: Session error: %s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+			logger("dynamic").ErrorContext(r.Context(), "session error", "error", err)
+			Error(w, r, http.StatusInternalServerError, nil)
 			return
 		}
 
-		p := filepath.Join(dh.Dir, r.URL.Path)
-		base := path.Base(p)
-
-		t := template.New(base).Funcs(template.FuncMap{
-			"sha256": func(value string) string {
-				h := sha256.New()
-				h.Write([]byte(value))
-				return fmt.Sprintf("%x", h.Sum(nil))
-			}})
-		if t, err = t.ParseFiles(p); err != nil {
-			io.WriteString(w, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+		p := filepath.Join(dh.Dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		value, err := dh.cache().load("dynamic:"+p, func() (interface{}, []templateFile, error) {
+			base := filepath.Base(p)
+			t, err := template.New(base).Funcs(dh.Funcs.FuncMap()).ParseFiles(p)
+			return t, []templateFile{{base, p}}, err
+		})
+		if err != nil {
+			if os.IsNotExist(err) {
+				Error(w, r, http.StatusNotFound, nil)
+			} else {
+				templateFailed(w, r, err)
+			}
 			return
 		}
+		t := value.(*template.Template)
 
 		values := make(map[string]string)
 		values["email"], _ = session.Values["email"].(string)
 		values["given_name"], _ = session.Values["given_name"].(string)
-		if err = t.Execute(w, values); err != nil {
-			io.WriteString(w, err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+		var buf bytes.Buffer
+		if err = dh.Funcs.executeCached("dynamic:"+p, t, &buf, r, values); err != nil {
+			templateFailed(w, r, dh.cache().executionError("dynamic:"+p, err))
 			return
 		}
+		buf.WriteTo(w)
 
 	})
 }
 
+var _ di.ProvidesInit = (*DynamicFileHandler)(nil)
 var _ ProvidesHandler = (*DynamicFileHandler)(nil)
 var _ = di.GlobalScope.Declare((*DynamicFileHandler)(nil))
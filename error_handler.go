@@ -0,0 +1,137 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/model"
+)
+
+// ErrorRenderer writes the response for a failed request.
+type ErrorRenderer interface {
+	RenderError(w http.ResponseWriter, r *http.Request, status int, err error)
+}
+
+// ErrorPage is the data passed to the error template and the body of JSON
+// error responses.
+type ErrorPage struct {
+	Status  int                `json:"status"`
+	Title   string             `json:"error"`
+	Message string             `json:"message,omitempty"`
+	Path    string             `json:"path"`
+	Fields  []model.FieldError `json:"fields,omitempty"`
+}
+
+// ErrorHandler renders errors as JSON or, if the client prefers HTML, with
+// the template named by Template. The message of err is only shown for client
+// errors and validation errors, other errors are logged instead. Used as a
+// handler it answers every request with Status, which makes it suitable as
+// notFound or methodNotAllowed handler of a router.
+type ErrorHandler struct {
+	Templates ProvidesTemplates `brot:"templates"`
+	Template  string            `brot:"template"`
+	Status    int               `brot:"status"`
+}
+
+// DefaultErrorRenderer is used if no renderer is attached to the request.
+var DefaultErrorRenderer ErrorRenderer = &ErrorHandler{Template: "error@html"}
+
+func (eh *ErrorHandler) InitFunc() (err error) {
+	if eh.Template == "" {
+		eh.Template = "error@html"
+	}
+	if eh.Status == 0 {
+		eh.Status = http.StatusNotFound
+	}
+	return
+}
+
+func (eh *ErrorHandler) Retry() bool {
+	return false
+}
+
+func (eh *ErrorHandler) RenderError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	page := &ErrorPage{Status: status, Title: http.StatusText(status), Path: r.URL.Path}
+	if err != nil {
+		page.Fields = fieldErrors(err)
+		// messages of server errors may reveal internals and are only logged
+		if status < http.StatusInternalServerError || page.Fields != nil {
+			page.Message = err.Error()
+		} else {
+			logger("errors").ErrorContext(r.Context(), "request failed", "status", status, "path", r.URL.Path, "error", err)
+		}
+	}
+	if eh.Templates != nil && negotiateType(r, "application/json", "text/html") == "text/html" {
+		var buf bytes.Buffer
+		if terr := eh.Templates.ExecuteTemplate(&buf, r, eh.Template, page); terr == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(status)
+			buf.WriteTo(w)
+			return
+		} else if !errors.Is(terr, ErrTemplateNotFound) {
+			logger("errors").WarnContext(r.Context(), "could not execute error template", "template", eh.Template, "error", terr)
+		}
+	}
+	// marshalling the error page cannot fail
+	body, _ := json.Marshal(page)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (eh *ErrorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	eh.RenderError(w, r, eh.Status, nil)
+}
+
+func (eh *ErrorHandler) HandlerFunc() http.Handler {
+	return eh
+}
+
+// statusHandler answers every request with the given status using renderer.
+func statusHandler(renderer ErrorRenderer, status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renderer.RenderError(w, r, status, nil)
+	})
+}
+
+// WithErrorRenderer returns a shallow copy of r which renders errors with
+// renderer.
+func WithErrorRenderer(r *http.Request, renderer ErrorRenderer) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), errorRendererKey, renderer))
+}
+
+// withErrorRenderer attaches renderer to every request passed to h.
+func withErrorRenderer(h http.Handler, renderer ErrorRenderer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, WithErrorRenderer(r, renderer))
+	})
+}
+
+// Error writes an error response with the renderer attached to the request,
+// or with DefaultErrorRenderer if there is none. Nothing is written if the
+// client cancelled the request.
+func Error(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		logger("errors").DebugContext(r.Context(), "request cancelled", "status", status, "error", err)
+		return
+	}
+	if renderer, ok := r.Context().Value(errorRendererKey).(ErrorRenderer); ok {
+		renderer.RenderError(w, r, status, err)
+		return
+	}
+	DefaultErrorRenderer.RenderError(w, r, status, err)
+}
+
+var _ di.ProvidesInit = (*ErrorHandler)(nil)
+var _ ErrorRenderer = (*ErrorHandler)(nil)
+var _ ProvidesHandler = (*ErrorHandler)(nil)
+var _ = di.GlobalScope.Declare((*ErrorHandler)(nil))
@@ -0,0 +1,102 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fuxsig/brot/model"
+)
+
+// errorTemplates renders error pages as "status message" or fails with err.
+type errorTemplates struct {
+	err error
+}
+
+func (et *errorTemplates) ExecuteTemplate(w io.Writer, r *http.Request, name string, data interface{}) error {
+	if et.err != nil {
+		return et.err
+	}
+	page := data.(*ErrorPage)
+	_, err := fmt.Fprintf(w, "%s %d %s", name, page.Status, page.Message)
+	return err
+}
+
+func TestErrorHandlerRenderError(t *testing.T) {
+	invalid := &model.ValidationError{}
+	invalid.Add("title", model.RequiredViolation, "title is required")
+	tables := []struct {
+		templates   ProvidesTemplates
+		accept      string
+		status      int
+		err         error
+		contentType string
+		body        string
+	}{
+		{nil, "text/html", http.StatusNotFound, nil, "application/json", `{"status":404,"error":"Not Found","path":"/a"}`},
+		{&errorTemplates{}, "text/html", http.StatusNotFound, nil, "text/html; charset=utf-8", "error@html 404 "},
+		{&errorTemplates{}, "application/json", http.StatusNotFound, nil, "application/json", `"status":404`},
+		{&errorTemplates{}, "", http.StatusNotFound, nil, "application/json", `"status":404`},
+		{&errorTemplates{}, "application/json;q=0.5, text/html", http.StatusForbidden, errors.New("no access"), "text/html; charset=utf-8", "error@html 403 no access"},
+		{&errorTemplates{err: ErrTemplateNotFound}, "text/html", http.StatusNotFound, nil, "application/json", `"status":404`},
+		{&errorTemplates{err: errors.New("broken")}, "text/html", http.StatusNotFound, nil, "application/json", `"status":404`},
+		{nil, "", http.StatusBadRequest, errors.New("bad value"), "application/json", `"message":"bad value"`},
+		{nil, "", http.StatusInternalServerError, errors.New("dial tcp 10.0.0.1"), "application/json", `{"status":500,"error":"Internal Server Error","path":"/a"}`},
+		{nil, "", http.StatusInternalServerError, invalid, "application/json", `"fields":[{"field":"title"`},
+	}
+	for i, table := range tables {
+		eh := &ErrorHandler{Templates: table.templates}
+		if err := eh.InitFunc(); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/a", nil)
+		if table.accept != "" {
+			r.Header.Set("Accept", table.accept)
+		}
+		w := httptest.NewRecorder()
+		eh.RenderError(w, r, table.status, table.err)
+		if w.Code != table.status || w.Header().Get("Content-Type") != table.contentType ||
+			!strings.Contains(w.Body.String(), table.body) {
+			t.Errorf("%d: expected %d %s %s, found %d %s %s", i, table.status, table.contentType, table.body,
+				w.Code, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+}
+
+func TestErrorRendererAttachment(t *testing.T) {
+	eh := &ErrorHandler{Templates: &errorTemplates{}, Template: "custom", Status: http.StatusMethodNotAllowed}
+	if err := eh.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	h := withErrorRenderer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, http.StatusConflict, errors.New("exists"))
+	}), eh)
+	r := httptest.NewRequest("GET", "/a", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusConflict || w.Body.String() != "custom 409 exists" {
+		t.Errorf("expected attached renderer, found %d %s", w.Code, w.Body.String())
+	}
+
+	// without attached renderer DefaultErrorRenderer is used
+	w = httptest.NewRecorder()
+	Error(w, r, http.StatusConflict, errors.New("exists"))
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"message":"exists"`) {
+		t.Errorf("expected default renderer, found %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	eh.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Body.String() != "custom 405 " {
+		t.Errorf("expected handler status, found %d %s", w.Code, w.Body.String())
+	}
+}
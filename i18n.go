@@ -0,0 +1,621 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/wrapper"
+)
+
+// Locales resolves the locale of a request and translates messages. The
+// catalogs are stored in Paths, one file per locale named after it, e.g.
+// de.json, de-CH.po or fr.json. A JSON catalog maps keys to messages or to
+// plural forms, e.g. {"items": {"one": "%d item", "other": "%d items"}}.
+//
+// The locale is taken from the first path element (PathPrefix), the Cookie,
+// the Session value of SessionStore (default brot-store) and the
+// Accept-Language header in this order, the Default locale (default en) is
+// used otherwise. ServeChain strips a locale path element, so that /de/about
+// is served like /about. Messages missing in a
+// locale are looked up in its Fallbacks, its language and the Default
+// locale.
+type Locales struct {
+	Paths        []string          `brot:"paths"`
+	Default      string            `brot:"default"`
+	Fallbacks    map[string]string `brot:"fallbacks"`
+	Cookie       string            `brot:"cookie"`
+	Session      string            `brot:"session"`
+	SessionStore string            `brot:"sessionStore"`
+	PathPrefix   bool              `brot:"pathPrefix"`
+	catalogs     map[string]catalog
+	supported    []string
+}
+
+// catalog maps message keys to messages of a locale.
+type catalog map[string]*message
+
+// message has a single form, stored as other, or one form per plural
+// category.
+type message struct {
+	forms map[string]string
+}
+
+func canonicalLocale(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+// language returns the language of a locale, e.g. de for de-ch.
+func language(locale string) string {
+	return strings.SplitN(locale, "-", 2)[0]
+}
+
+func (l *Locales) InitFunc() (err error) {
+	if l.Default == "" {
+		l.Default = "en"
+	}
+	if l.SessionStore == "" {
+		l.SessionStore = "brot-store"
+	}
+	l.Default = canonicalLocale(l.Default)
+	fallbacks := make(map[string]string, len(l.Fallbacks))
+	for from, to := range l.Fallbacks {
+		fallbacks[canonicalLocale(from)] = canonicalLocale(to)
+	}
+	l.Fallbacks = fallbacks
+	l.catalogs = map[string]catalog{}
+	for _, dir := range l.Paths {
+		var files []os.FileInfo
+		if files, err = ioutil.ReadDir(dir); err != nil {
+			return
+		}
+		for _, file := range files {
+			ext := filepath.Ext(file.Name())
+			locale := canonicalLocale(strings.TrimSuffix(file.Name(), ext))
+			path := filepath.Join(dir, file.Name())
+			var c catalog
+			switch ext {
+			case ".json":
+				c, err = loadJSONCatalog(path)
+			case ".po":
+				c, err = loadPOCatalog(path, locale)
+			default:
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("catalog %s: %s", path, err.Error())
+			}
+			if l.catalogs[locale] == nil {
+				l.catalogs[locale] = catalog{}
+			}
+			for key, msg := range c {
+				l.catalogs[locale][key] = msg
+			}
+			logger("i18n").Info("loaded catalog", "path", path, "locale", locale, "messages", len(c))
+		}
+	}
+	supported := map[string]bool{l.Default: true}
+	for locale := range l.catalogs {
+		supported[locale] = true
+	}
+	for locale := range supported {
+		l.supported = append(l.supported, locale)
+	}
+	sort.Strings(l.supported)
+	return
+}
+
+func (l *Locales) Retry() bool {
+	return false
+}
+
+func loadJSONCatalog(path string) (catalog, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err = json.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+	result := make(catalog, len(raw))
+	for key, value := range raw {
+		switch value := value.(type) {
+		case string:
+			result[key] = &message{map[string]string{"other": value}}
+		case map[string]interface{}:
+			msg := &message{make(map[string]string, len(value))}
+			for category, form := range value {
+				str, ok := form.(string)
+				if !ok {
+					return nil, fmt.Errorf("plural form %s of %s is not a string", category, key)
+				}
+				msg.forms[category] = str
+			}
+			result[key] = msg
+		default:
+			return nil, fmt.Errorf("message %s is neither a string nor an object", key)
+		}
+	}
+	return result, nil
+}
+
+// loadPOCatalog reads a gettext PO file. The plural forms msgstr[n] are
+// mapped to the categories of the plural rule of locale.
+func loadPOCatalog(path, locale string) (catalog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	categories := pluralRuleOf(locale).categories
+	result := catalog{}
+	var (
+		id, plural string
+		forms      map[int]string
+		// appendTo receives the continuation lines of the last keyword
+		appendTo func(string)
+	)
+	flush := func() {
+		// the header has an empty msgid and is skipped
+		if id != "" {
+			msg := &message{make(map[string]string, len(forms))}
+			for i, form := range forms {
+				switch {
+				case form == "":
+				case plural == "":
+					msg.forms["other"] = form
+				case i < len(categories):
+					msg.forms[categories[i]] = form
+				}
+			}
+			if len(msg.forms) > 0 {
+				result[id] = msg
+			}
+		}
+		id, plural, forms, appendTo = "", "", map[int]string{}, nil
+	}
+	flush()
+	scanner := bufio.NewScanner(f)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, `"`) {
+			str, err := strconv.Unquote(line)
+			if err != nil || appendTo == nil {
+				return nil, fmt.Errorf("line %d: unexpected string", number)
+			}
+			appendTo(str)
+			continue
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing string", number)
+		}
+		keyword := line[:i]
+		str, err := strconv.Unquote(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", number, err.Error())
+		}
+		index := 0
+		switch {
+		case keyword == "msgctxt":
+			appendTo = func(string) {}
+		case keyword == "msgid":
+			flush()
+			id = str
+			appendTo = func(s string) { id += s }
+		case keyword == "msgid_plural":
+			plural = str
+			appendTo = func(s string) { plural += s }
+		case keyword == "msgstr" || strings.HasPrefix(keyword, "msgstr["):
+			if keyword != "msgstr" {
+				if index, err = strconv.Atoi(strings.TrimSuffix(keyword[len("msgstr["):], "]")); err != nil {
+					return nil, fmt.Errorf("line %d: invalid keyword %s", number, keyword)
+				}
+			}
+			forms[index] = str
+			appendTo = func(s string) { forms[index] += s }
+		default:
+			return nil, fmt.Errorf("line %d: unknown keyword %s", number, keyword)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return result, nil
+}
+
+// pluralRule selects the plural category of a count. Its categories are in
+// the order of the msgstr[n] forms of a PO file.
+type pluralRule struct {
+	categories []string
+	category   func(n int64) string
+}
+
+var (
+	oneOther = pluralRule{[]string{"one", "other"}, func(n int64) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}}
+	zeroOneOther = pluralRule{[]string{"one", "other"}, func(n int64) string {
+		if n == 0 || n == 1 {
+			return "one"
+		}
+		return "other"
+	}}
+	onlyOther = pluralRule{[]string{"other"}, func(n int64) string {
+		return "other"
+	}}
+	// slavic languages like ru and uk
+	oneFewMany = pluralRule{[]string{"one", "few", "many"}, func(n int64) string {
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		}
+		return "many"
+	}}
+	polish = pluralRule{[]string{"one", "few", "many"}, func(n int64) string {
+		switch {
+		case n == 1:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		}
+		return "many"
+	}}
+	czech = pluralRule{[]string{"one", "few", "other"}, func(n int64) string {
+		switch {
+		case n == 1:
+			return "one"
+		case n >= 2 && n <= 4:
+			return "few"
+		}
+		return "other"
+	}}
+)
+
+var pluralRules = map[string]pluralRule{
+	"fr": zeroOneOther, "pt": zeroOneOther,
+	"ja": onlyOther, "zh": onlyOther, "ko": onlyOther, "tr": onlyOther,
+	"ru": oneFewMany, "uk": oneFewMany, "pl": polish, "cs": czech, "sk": czech,
+}
+
+// pluralRuleOf returns the rule of the language of locale, one and other
+// for unknown languages.
+func pluralRuleOf(locale string) pluralRule {
+	if rule, ok := pluralRules[language(locale)]; ok {
+		return rule
+	}
+	return oneOther
+}
+
+// Supported returns the locales with catalogs and the default locale.
+func (l *Locales) Supported() []string {
+	return l.supported
+}
+
+// match returns the supported locale for locale, which matches exactly or
+// by language.
+func (l *Locales) match(locale string) (string, bool) {
+	locale = canonicalLocale(locale)
+	if locale == "" {
+		return "", false
+	}
+	for _, current := range l.supported {
+		if current == locale {
+			return current, true
+		}
+	}
+	if lang := language(locale); lang != locale {
+		for _, current := range l.supported {
+			if current == lang {
+				return current, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchAcceptLanguage returns the preferred supported locale of an
+// Accept-Language header, e.g. de-CH, de;q=0.9, en;q=0.5.
+func (l *Locales) matchAcceptLanguage(header string) (string, bool) {
+	type weighted struct {
+		locale string
+		q      float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		current := weighted{strings.TrimSpace(params[0]), 1}
+		for _, param := range params[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					current.q = q
+				}
+			}
+		}
+		if current.locale != "" && current.locale != "*" && current.q > 0 {
+			accepted = append(accepted, current)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	for _, current := range accepted {
+		if locale, ok := l.match(current.locale); ok {
+			return locale, true
+		}
+	}
+	return "", false
+}
+
+// Resolve returns the locale of r. A locale stored in the request context
+// by ServeChain takes precedence.
+func (l *Locales) Resolve(r *http.Request) string {
+	if locale := Locale(r.Context()); locale != "" {
+		return locale
+	}
+	if locale, _, ok := l.pathLocale(r.URL.Path); ok {
+		return locale
+	}
+	if l.Cookie != "" {
+		if cookie, err := r.Cookie(l.Cookie); err == nil {
+			if locale, ok := l.match(cookie.Value); ok {
+				return locale
+			}
+		}
+	}
+	if l.Session != "" {
+		if session, err := sessionStore.Get(r, l.SessionStore); err == nil {
+			if value, ok := session.Values[l.Session].(string); ok {
+				if locale, ok := l.match(value); ok {
+					return locale
+				}
+			}
+		}
+	}
+	if locale, ok := l.matchAcceptLanguage(r.Header.Get("Accept-Language")); ok {
+		return locale
+	}
+	return l.Default
+}
+
+// pathLocale returns the locale of the first element of urlPath and the
+// path without it, if PathPrefix is set.
+func (l *Locales) pathLocale(urlPath string) (locale, rest string, ok bool) {
+	if !l.PathPrefix {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(urlPath, "/"), "/", 2)
+	if locale, ok = l.match(parts[0]); !ok {
+		return
+	}
+	rest = "/"
+	if len(parts) > 1 {
+		rest += parts[1]
+	}
+	return
+}
+
+// ServeChain stores the locale in the request context and announces it in
+// the Content-Language header. The locale path element is removed from the
+// URL like http.StripPrefix does.
+func (l *Locales) ServeChain(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	locale := l.Resolve(r)
+	h := w.Header()
+	h.Set("Content-Language", locale)
+	h.Add("Vary", "Accept-Language")
+	r2 := r.WithContext(WithLocale(r.Context(), locale))
+	if _, rest, ok := l.pathLocale(r.URL.Path); ok {
+		u := *r.URL
+		u.Path = rest
+		u.RawPath = ""
+		r2.URL = &u
+	}
+	next(w, r2)
+}
+
+func (l *Locales) WrapperFunc() func(http.Handler) http.Handler {
+	return wrapper.Middleware(l)
+}
+
+// chain returns the locales searched for messages of locale.
+func (l *Locales) chain(locale string) []string {
+	result := make([]string, 0, 4)
+	seen := map[string]bool{}
+	add := func(locale string) {
+		if locale != "" && !seen[locale] {
+			seen[locale] = true
+			result = append(result, locale)
+		}
+	}
+	for current := locale; current != "" && !seen[current]; current = l.Fallbacks[current] {
+		add(current)
+	}
+	add(language(locale))
+	add(l.Default)
+	return result
+}
+
+// Translate returns the message key of locale formatted with args. If the
+// message has plural forms, the first argument is the count. Unknown keys
+// are returned unchanged.
+func (l *Locales) Translate(locale, key string, args ...interface{}) string {
+	var msg *message
+	for _, current := range l.chain(canonicalLocale(locale)) {
+		if msg = l.catalogs[current][key]; msg != nil {
+			locale = current
+			break
+		}
+	}
+	if msg == nil {
+		return key
+	}
+	form, ok := msg.forms["other"]
+	if len(args) > 0 && len(msg.forms) > 1 {
+		if count, err := di.GetInt64(args[0]); err == nil {
+			if str, found := msg.forms[pluralRuleOf(locale).category(count)]; found {
+				form, ok = str, true
+			}
+		}
+	}
+	if !ok {
+		// a message without other form, e.g. only one
+		for _, str := range msg.forms {
+			form = str
+			break
+		}
+	}
+	if len(args) > 0 && strings.Contains(form, "%") {
+		return fmt.Sprintf(form, args...)
+	}
+	return form
+}
+
+// WithLocale returns a copy of ctx carrying the locale.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey, locale)
+}
+
+// Locale returns the locale stored in ctx, or an empty string.
+func Locale(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey).(string)
+	return locale
+}
+
+// localeFormat contains the number and date conventions of a language.
+type localeFormat struct {
+	group, point      string
+	months, days      []string
+	shortMonths       []string
+	shortDays         []string
+	currencyAfter     bool
+	currencySeparator string
+}
+
+var englishFormat = localeFormat{
+	group: ",", point: ".",
+	months:      []string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+	days:        []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+	shortMonths: []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+	shortDays:   []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+}
+
+var localeFormats = map[string]localeFormat{
+	"en": englishFormat,
+	"de": {
+		group: ".", point: ",", currencyAfter: true, currencySeparator: " ",
+		months:      []string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		days:        []string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		shortMonths: []string{"Jan", "Feb", "Mär", "Apr", "Mai", "Jun", "Jul", "Aug", "Sep", "Okt", "Nov", "Dez"},
+		shortDays:   []string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+	},
+	"fr": {
+		group: "\u202f", point: ",", currencyAfter: true, currencySeparator: "\u00a0",
+		months:      []string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		days:        []string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		shortMonths: []string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		shortDays:   []string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+	"es": {
+		group: ".", point: ",", currencyAfter: true, currencySeparator: "\u00a0",
+		months:      []string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		days:        []string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		shortMonths: []string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		shortDays:   []string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+	},
+	"it": {
+		group: ".", point: ",", currencyAfter: true, currencySeparator: "\u00a0",
+		months:      []string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		days:        []string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		shortMonths: []string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+		shortDays:   []string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"},
+	},
+	"nl": {
+		group: ".", point: ",", currencySeparator: "\u00a0",
+		months:      []string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		days:        []string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		shortMonths: []string{"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+		shortDays:   []string{"zo", "ma", "di", "wo", "do", "vr", "za"},
+	},
+}
+
+// localeFormatOf returns the conventions of locale, e.g. de-ch uses the
+// conventions of de. Unknown languages use English conventions.
+func localeFormatOf(locale string) localeFormat {
+	if format, ok := localeFormats[language(canonicalLocale(locale))]; ok {
+		return format
+	}
+	return englishFormat
+}
+
+// formatDate formats t with a time.Format layout and translates the names
+// of months and days.
+func (lf localeFormat) formatDate(t time.Time, layout string) string {
+	var b strings.Builder
+	start := 0
+	for i := 0; i < len(layout); {
+		var name string
+		var size int
+		switch rest := layout[i:]; {
+		case strings.HasPrefix(rest, "January"):
+			name, size = lf.months[t.Month()-1], 7
+		case strings.HasPrefix(rest, "Jan"):
+			name, size = lf.shortMonths[t.Month()-1], 3
+		case strings.HasPrefix(rest, "Monday"):
+			name, size = lf.days[t.Weekday()], 6
+		case strings.HasPrefix(rest, "Mon"):
+			name, size = lf.shortDays[t.Weekday()], 3
+		default:
+			i++
+			continue
+		}
+		b.WriteString(t.Format(layout[start:i]))
+		b.WriteString(name)
+		i += size
+		start = i
+	}
+	b.WriteString(t.Format(layout[start:]))
+	return b.String()
+}
+
+func (lf localeFormat) formatCurrency(code string, value float64) string {
+	symbol, ok := currencySymbols[code]
+	if !ok {
+		symbol = code + " "
+	}
+	sign := ""
+	if value < 0 {
+		sign, value = "-", -value
+	}
+	number := formatNumber(value, 2, lf.group, lf.point)
+	if lf.currencyAfter {
+		return sign + number + lf.currencySeparator + strings.TrimSpace(symbol)
+	}
+	return sign + symbol + number
+}
+
+var _ di.ProvidesInit = (*Locales)(nil)
+var _ wrapper.Handler = (*Locales)(nil)
+var _ ProvidesWrapper = (*Locales)(nil)
+var _ = di.GlobalScope.Declare((*Locales)(nil))
@@ -0,0 +1,140 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocales(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i18n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"en.json": `{"hello": "Hello %s", "items": {"one": "%d item", "other": "%d items"}, "bye": "Bye"}`,
+		"de.json": `{"hello": "Hallo %s", "items": {"one": "%d Eintrag", "other": "%d Einträge"}}`,
+		"de-CH.po": `# Swiss German
+msgid ""
+msgstr "Content-Type: text/plain; charset=UTF-8\n"
+
+msgid "hello"
+msgstr "Grüezi "
+"%s"
+`,
+		"ru.po": `msgid "items"
+msgid_plural "items"
+msgstr[0] "%d файл"
+msgstr[1] "%d файла"
+msgstr[2] "%d файлов"
+`,
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := &Locales{Paths: []string{dir}, Cookie: "lang", PathPrefix: true, Fallbacks: map[string]string{"de-AT": "de"}}
+	if err = l.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+
+	translations := []struct {
+		locale, key string
+		args        []interface{}
+		result      string
+	}{
+		{"de-CH", "hello", []interface{}{"Anna"}, "Grüezi Anna"},
+		{"de-CH", "items", []interface{}{1}, "1 Eintrag"},
+		{"de-AT", "items", []interface{}{2}, "2 Einträge"},
+		{"de", "bye", nil, "Bye"},
+		{"ru", "items", []interface{}{21}, "21 файл"},
+		{"ru", "items", []interface{}{3}, "3 файла"},
+		{"ru", "items", []interface{}{11}, "11 файлов"},
+		{"fr", "items", []interface{}{0}, "0 items"},
+		{"en", "missing", nil, "missing"},
+	}
+	for _, table := range translations {
+		if result := l.Translate(table.locale, table.key, table.args...); result != table.result {
+			t.Errorf("%s %s: expected %q, found %q", table.locale, table.key, table.result, result)
+		}
+	}
+
+	resolutions := []struct {
+		path, cookie, accept, locale string
+	}{
+		{"/ru/page", "de", "de", "ru"},
+		{"/page", "de-ch", "ru", "de-ch"},
+		{"/page", "xx", "fr-FR, de-AT;q=0.8, ru;q=0.9", "ru"},
+		{"/page", "", "de-DE", "de"},
+		{"/page", "", "fr", "en"},
+	}
+	for _, table := range resolutions {
+		r := httptest.NewRequest("GET", table.path, nil)
+		if table.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "lang", Value: table.cookie})
+		}
+		r.Header.Set("Accept-Language", table.accept)
+		if locale := l.Resolve(r); locale != table.locale {
+			t.Errorf("%s %s %s: expected %s, found %s", table.path, table.cookie, table.accept, table.locale, locale)
+		}
+	}
+
+	chained := []struct {
+		path, served, locale string
+	}{
+		{"/de/about", "/about", "de"},
+		{"/RU", "/", "ru"},
+		{"/deutsch/about", "/deutsch/about", "en"},
+	}
+	for _, table := range chained {
+		var served, locale string
+		l.ServeChain(httptest.NewRecorder(), httptest.NewRequest("GET", table.path, nil), func(w http.ResponseWriter, r *http.Request) {
+			served, locale = r.URL.Path, Locale(r.Context())
+		})
+		if served != table.served || locale != table.locale {
+			t.Errorf("%s: expected %s %s, found %s %s", table.path, table.served, table.locale, served, locale)
+		}
+	}
+
+	// the session value is read from the configured store
+	stored := &Locales{Paths: []string{dir}, Session: "locale", SessionStore: "prefs"}
+	if err = stored.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	session, _ := sessionStore.New(httptest.NewRequest("GET", "/", nil), "prefs")
+	session.Values["locale"] = "ru"
+	session.Save(httptest.NewRequest("GET", "/", nil), w)
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	if locale := stored.Resolve(r); locale != "ru" {
+		t.Errorf("expected session locale ru, found %s", locale)
+	}
+
+	tf := &TemplateFuncs{Locales: l}
+	tmpl := template.Must(template.New("page").Funcs(tf.FuncMap()).Parse(
+		`{{locale}}|{{t "items" 3}}|{{number 2 1234.5}}|{{currency "EUR" 9.99}}|{{date "Monday, 2 January 2006" .}}`))
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Language", "de-DE")
+	var buf bytes.Buffer
+	if err = tf.execute(tmpl, &buf, r, time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "de|3 Einträge|1.234,50|9,99 €|Sonntag, 4 März 2018"; buf.String() != expected {
+		t.Errorf("expected %q, found %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/model"
+	"github.com/fuxsig/brot/wrapper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ProvidesIdentity authenticates the user of a request. Requests without
+// credentials of the authenticator return an empty user and no error,
+// invalid credentials return an error. Groups are the unmapped groups of
+// the user, e.g. the values of a token claim.
+type ProvidesIdentity interface {
+	Identify(r *http.Request) (user string, groups []string, err error)
+}
+
+// IdentityWrapper stores the model.Context of the authenticated user in
+// the request context, so that all data access of the request is done on
+// behalf of the user. The first authenticator returning a user wins.
+//
+// Groups maps the groups of the authenticators to model groups, a group
+// may map to several model groups. If Groups is empty the groups of the
+// authenticators are used as they are, otherwise unmapped groups are
+// dropped. Every user is member of the group all, which is the default read
+// group of new objects. Requests without user get model.Anonymous, or 401
+// if Required is set. Invalid credentials are always rejected with 401.
+type IdentityWrapper struct {
+	Authenticators []ProvidesIdentity  `brot:"authenticators,mandatory"`
+	Groups         map[string][]string `brot:"groups"`
+	Required       bool                `brot:"required"`
+	Realm          string              `brot:"realm"`
+}
+
+var errNotAuthenticated = errors.New("authentication required")
+
+func (iw *IdentityWrapper) InitFunc() (err error) {
+	if iw.Realm == "" {
+		iw.Realm = "brot"
+	}
+	return
+}
+
+func (iw *IdentityWrapper) Retry() bool {
+	return false
+}
+
+// identify returns the context of the user of r, nil for requests without
+// user.
+func (iw *IdentityWrapper) identify(r *http.Request) (*model.Context, error) {
+	for _, authenticator := range iw.Authenticators {
+		user, groups, err := authenticator.Identify(r)
+		if err != nil {
+			return nil, err
+		}
+		if user != "" {
+			return model.NewContext(user, iw.mapGroups(groups)...), nil
+		}
+	}
+	return nil, nil
+}
+
+func (iw *IdentityWrapper) mapGroups(groups []string) []string {
+	result := []string{"all"}
+	seen := map[string]bool{"all": true}
+	add := func(group string) {
+		if group != "" && !seen[group] {
+			seen[group] = true
+			result = append(result, group)
+		}
+	}
+	for _, group := range groups {
+		if len(iw.Groups) == 0 {
+			add(group)
+			continue
+		}
+		for _, mapped := range iw.Groups[group] {
+			add(mapped)
+		}
+	}
+	return result
+}
+
+func (iw *IdentityWrapper) ServeChain(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	identity, err := iw.identify(r)
+	if err != nil {
+		logger("identity").InfoContext(r.Context(), "authentication failed", "error", err)
+	}
+	if err != nil || identity == nil && iw.Required {
+		if err == nil {
+			err = errNotAuthenticated
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+iw.Realm+`"`)
+		w.Header().Add("WWW-Authenticate", `Basic realm="`+iw.Realm+`"`)
+		Error(w, r, http.StatusUnauthorized, err)
+		return
+	}
+	if identity != nil {
+		r = r.WithContext(WithIdentity(r.Context(), identity))
+	}
+	next(w, r)
+}
+
+func (iw *IdentityWrapper) WrapperFunc() func(http.Handler) http.Handler {
+	return wrapper.Middleware(iw)
+}
+
+// WithIdentity returns a copy of ctx carrying the model context of the
+// authenticated user.
+func WithIdentity(ctx context.Context, identity *model.Context) context.Context {
+	ctx = context.WithValue(ctx, identityKey, identity)
+	return WithLogAttrs(ctx, slog.String("user", identity.User))
+}
+
+// Identity returns the model context stored in ctx, model.Anonymous if
+// there is none.
+func Identity(ctx context.Context) *model.Context {
+	if identity, ok := ctx.Value(identityKey).(*model.Context); ok {
+		return identity
+	}
+	return model.Anonymous
+}
+
+// modelContext returns the model context used for data access of r.
+func modelContext(r *http.Request) *model.Context {
+	return Identity(r.Context()).WithContext(r.Context())
+}
+
+// SessionIdentity identifies the user logged in by OktaWrapper. The user
+// is the session value email, groups are the comma separated session value
+// groups. Session defaults to brot-store.
+type SessionIdentity struct {
+	Session string `brot:"session"`
+}
+
+func (si *SessionIdentity) Identify(r *http.Request) (user string, groups []string, err error) {
+	name := si.Session
+	if name == "" {
+		name = "brot-store"
+	}
+	// a broken session cookie is treated like no login
+	session, serr := sessionStore.Get(r, name)
+	if serr != nil {
+		return
+	}
+	if token, _ := session.Values["id_token"].(string); token == "" {
+		return
+	}
+	user, _ = session.Values["email"].(string)
+	if str, ok := session.Values["groups"].(string); ok && str != "" {
+		groups = strings.Split(str, ",")
+	}
+	return
+}
+
+// BearerIdentity identifies the user by the bearer token of the request.
+// User and Groups are claim paths like realm_access.roles, they default to
+// sub and groups.
+type BearerIdentity struct {
+	Verifier *JWTVerifier `brot:"verifier,mandatory"`
+	User     string       `brot:"user"`
+	Groups   string       `brot:"groups"`
+}
+
+func (bi *BearerIdentity) InitFunc() (err error) {
+	if bi.User == "" {
+		bi.User = "sub"
+	}
+	if bi.Groups == "" {
+		bi.Groups = "groups"
+	}
+	return
+}
+
+func (bi *BearerIdentity) Retry() bool {
+	return false
+}
+
+func (bi *BearerIdentity) Identify(r *http.Request) (user string, groups []string, err error) {
+	claims, err := bi.Verifier.VerifyRequest(r)
+	if err == errNoBearerToken {
+		return "", nil, nil
+	} else if err != nil {
+		return
+	}
+	if value, ok := lookupPath(claims, bi.User); ok {
+		if values := stringValues(value); len(values) == 1 {
+			user = values[0]
+		}
+	}
+	if user == "" {
+		return "", nil, errors.New("token without user claim " + bi.User)
+	}
+	if value, ok := lookupPath(claims, bi.Groups); ok {
+		groups = stringValues(value)
+	}
+	return
+}
+
+// BasicIdentity identifies the user by basic authentication. Users maps
+// user names to the bcrypt hash of their password in the modular crypt
+// format, e.g. $2a$10$ followed by salt and hash as created by htpasswd -B
+// or bcrypt.GenerateFromPassword. Groups maps user names to their groups.
+type BasicIdentity struct {
+	Users  map[string]string   `brot:"users,mandatory"`
+	Groups map[string][]string `brot:"groups"`
+}
+
+// unknownUserHash is compared with the passwords of unknown users, so that
+// they take as long to reject as wrong passwords.
+var unknownUserHash = []byte("$2a$10$v2C6hSqfsEV/OEN8jo5CgOar7Bh1xD4lFlnl8ypoSny.r7T4Ex0N.")
+
+func (bi *BasicIdentity) InitFunc() error {
+	for name, hash := range bi.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("user %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (bi *BasicIdentity) Retry() bool {
+	return false
+}
+
+func (bi *BasicIdentity) Identify(r *http.Request) (user string, groups []string, err error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return
+	}
+	hash, known := bi.Users[name]
+	if !known {
+		hash = string(unknownUserHash)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || !known {
+		return "", nil, errors.New("invalid user or password")
+	}
+	return name, bi.Groups[name], nil
+}
+
+var _ di.ProvidesInit = (*IdentityWrapper)(nil)
+var _ wrapper.Handler = (*IdentityWrapper)(nil)
+var _ ProvidesWrapper = (*IdentityWrapper)(nil)
+var _ = di.GlobalScope.Declare((*IdentityWrapper)(nil))
+
+var _ ProvidesIdentity = (*SessionIdentity)(nil)
+var _ = di.GlobalScope.Declare((*SessionIdentity)(nil))
+
+var _ di.ProvidesInit = (*BearerIdentity)(nil)
+var _ ProvidesIdentity = (*BearerIdentity)(nil)
+var _ = di.GlobalScope.Declare((*BearerIdentity)(nil))
+
+var _ di.ProvidesInit = (*BasicIdentity)(nil)
+var _ ProvidesIdentity = (*BasicIdentity)(nil)
+var _ = di.GlobalScope.Declare((*BasicIdentity)(nil))
@@ -0,0 +1,82 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestIdentityWrapper(t *testing.T) {
+	verifier := &JWTVerifier{Secret: "secret"}
+	if err := verifier.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	bearer := &BearerIdentity{Verifier: verifier, Groups: "realm_access.roles"}
+	bearer.InitFunc()
+	basic := &BasicIdentity{
+		// bcrypt hash of pass
+		Users:  map[string]string{"bob": "$2a$04$wA2zE.teuIpXBgnrtuwBtudxBuurooCNMp/zdbfLxtW/bC6vsx6K."},
+		Groups: map[string][]string{"bob": {"staff"}},
+	}
+	if err := basic.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&BasicIdentity{Users: map[string]string{"bob": "d74ff0ee8da3b9806b18c877dbf29bbde50b5bd8e4dad7a3a725000feb82e8f1"}}).InitFunc(); err == nil {
+		t.Error("expected error for a SHA-256 hash")
+	}
+	iw := &IdentityWrapper{
+		Authenticators: []ProvidesIdentity{bearer, basic},
+		Groups:         map[string][]string{"staff": {"editors"}, "admin": {"editors", "admins"}},
+	}
+	iw.InitFunc()
+
+	var found string
+	handler := iw.WrapperFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values := make(map[string][]string)
+		(&ContextProvider{Mapping: map[string]string{"user": "user", "groups": "groups"}}).Initialize(r, values)
+		found = strings.Join(values["user"], ",") + ":" + strings.Join(values["groups"], ",")
+	}))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":          "alice",
+		"realm_access": map[string]interface{}{"roles": []string{"admin", "other"}},
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		auth     func(r *http.Request)
+		required bool
+		status   int
+		expected string
+	}{
+		{func(r *http.Request) {}, false, http.StatusOK, ":all"},
+		{func(r *http.Request) {}, true, http.StatusUnauthorized, ""},
+		{func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, true, http.StatusOK, "alice:admins,all,editors"},
+		{func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token+"x") }, false, http.StatusUnauthorized, ""},
+		{func(r *http.Request) { r.SetBasicAuth("bob", "pass") }, true, http.StatusOK, "bob:all,editors"},
+		{func(r *http.Request) { r.SetBasicAuth("bob", "wrong") }, false, http.StatusUnauthorized, ""},
+		{func(r *http.Request) { r.SetBasicAuth("eve", "pass") }, false, http.StatusUnauthorized, ""},
+	}
+	for i, table := range tables {
+		found = ""
+		iw.Required = table.required
+		r := httptest.NewRequest("GET", "/", nil)
+		table.auth(r)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != table.status || found != table.expected {
+			t.Errorf("%d: expected %d %q, found %d %q", i, table.status, table.expected, w.Code, found)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%d: expected authenticate header", i)
+		}
+	}
+}
@@ -5,18 +5,18 @@
 package brot
 
 import (
+	"context"
 	"crypto/rsa"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/fuxsig/brot/di"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type JWT struct {
@@ -63,7 +63,7 @@ func (j *JWT) InitFunc() (err error) {
 
 	method := jwt.GetSigningMethod(j.Method)
 	if method == nil {
-		log.Fatalf("Signing method %s is unknown", j.Method)
+		return fmt.Errorf("signing method %s is unknown", j.Method)
 	}
 	j.sm = method
 	return
@@ -73,22 +73,27 @@ func (j *JWT) Retry() bool {
 	return false
 }
 
+// Token returns a valid access token, requesting a new one if necessary.
 func (j *JWT) Token() string {
+	return j.TokenContext(context.Background())
+}
+
+// TokenContext is like Token, a token request is bound to ctx.
+func (j *JWT) TokenContext(ctx context.Context) string {
 	if j.token != nil && j.token.Valid() {
 		return j.token.Token
 	}
 
+	l := logger("jwt")
 	t := &JWTToken{}
 	if err := j.Database.Get("JWTToken4AEC", t); err == nil {
 		if t.Valid() {
 			j.token = t
-			fmt.Println("Authorization: ", "<"+t.Token+">")
+			l.DebugContext(ctx, "loaded token from database", "expires", t.Expires)
 			return t.Token
 		}
 	} else {
-		if Warning {
-			Warning.Printf("JWT.Token: could not load token from database: %s", err.Error())
-		}
+		l.WarnContext(ctx, "could not load token from database", "error", err)
 	}
 
 	if token := jwt.New(j.sm); token != nil {
@@ -104,7 +109,8 @@ func (j *JWT) Token() string {
 
 		tokenString, err := token.SignedString(j.pk)
 		if err != nil {
-			log.Fatal(err.Error())
+			l.ErrorContext(ctx, "could not sign token", "error", err)
+			return ""
 		}
 
 		values := url.Values{
@@ -112,30 +118,38 @@ func (j *JWT) Token() string {
 			"client_secret": {j.Secret},
 			"jwt_token":     {tokenString}}
 		urlStr := j.URL
-		res, err := http.PostForm(urlStr, values)
+		req, err := newRequest(ctx, "POST", urlStr, strings.NewReader(values.Encode()))
 		if err != nil {
-			log.Fatal(err.Error())
+			l.ErrorContext(ctx, "token request failed", "url", urlStr, "error", err)
+			return ""
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			l.ErrorContext(ctx, "token request failed", "url", urlStr, "error", err)
+			return ""
 		}
 		defer res.Body.Close()
 
 		b, err := ioutil.ReadAll(res.Body)
-		switch {
-		case res.StatusCode < 200:
-		case res.StatusCode >= 300:
-			log.Fatalf("[%d %s]%s", res.StatusCode, res.Status, string(b))
-		}
 		if err != nil {
-			log.Fatal(err.Error())
+			l.ErrorContext(ctx, "could not read token response", "url", urlStr, "error", err)
+			return ""
+		}
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			l.ErrorContext(ctx, "token request rejected", "url", urlStr, "status", res.StatusCode)
+			return ""
 		}
 
 		at := AECAccessToken{}
 		err = json.Unmarshal(b, &at)
 		if err != nil {
-			log.Fatal(err.Error())
+			l.ErrorContext(ctx, "could not parse token response", "url", urlStr, "error", err)
+			return ""
 		}
 		t.Token = fmt.Sprint(strings.Title(at.TokenType), " ", at.Token)
 		t.Expires = time.Now().Add(time.Millisecond * time.Duration(at.Expires))
-		fmt.Println("Authorization: ", "<"+t.Token+">", t.Expires.String())
+		l.DebugContext(ctx, "retrieved new token", "expires", t.Expires)
 		j.token = t
 
 		j.Database.Set("JWTToken4AEC", t)
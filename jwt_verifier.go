@@ -0,0 +1,103 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/fuxsig/brot/di"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTVerifier verifies bearer tokens. Tokens are either signed with the
+// shared Secret (HS256, HS384, HS512) or with the private key belonging to
+// the PEM encoded RSA or ECDSA public key stored at PublicKey. Methods
+// restricts the accepted signing methods, Issuer and Audience are checked if
+// set.
+type JWTVerifier struct {
+	Secret    string   `brot:"secret"`
+	PublicKey string   `brot:"publicKey"`
+	Methods   []string `brot:"methods"`
+	Issuer    string   `brot:"issuer"`
+	Audience  string   `brot:"audience"`
+	key       interface{}
+	options   []jwt.ParserOption
+}
+
+var errNoBearerToken = errors.New("no bearer token")
+
+func (v *JWTVerifier) InitFunc() (err error) {
+	if (v.Secret == "") == (v.PublicKey == "") {
+		return errors.New("either secret or publicKey must be set")
+	}
+	var methods []string
+	if v.Secret != "" {
+		v.key = []byte(v.Secret)
+		methods = []string{"HS256", "HS384", "HS512"}
+	} else {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(v.PublicKey); err != nil {
+			return
+		}
+		if v.key, err = jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+			methods = []string{"RS256", "RS384", "RS512"}
+		} else if v.key, err = jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+			methods = []string{"ES256", "ES384", "ES512"}
+		} else {
+			return fmt.Errorf("public key %s is neither RSA nor ECDSA", v.PublicKey)
+		}
+	}
+	if len(v.Methods) > 0 {
+		methods = v.Methods
+	}
+	v.options = []jwt.ParserOption{jwt.WithValidMethods(methods)}
+	if v.Issuer != "" {
+		v.options = append(v.options, jwt.WithIssuer(v.Issuer))
+	}
+	if v.Audience != "" {
+		v.options = append(v.options, jwt.WithAudience(v.Audience))
+	}
+	return
+}
+
+func (v *JWTVerifier) Retry() bool {
+	return false
+}
+
+// Verify checks signature and claims of the token and returns its claims.
+func (v *JWTVerifier) Verify(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return v.key, nil
+	}, v.options...)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// VerifyRequest verifies the bearer token of the Authorization header.
+func (v *JWTVerifier) VerifyRequest(r *http.Request) (jwt.MapClaims, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errNoBearerToken
+	}
+	return v.Verify(token)
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+var _ di.ProvidesInit = (*JWTVerifier)(nil)
+var _ = di.GlobalScope.Declare((*JWTVerifier)(nil))
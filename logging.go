@@ -5,11 +5,158 @@
 package brot
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/fuxsig/brot/di"
 )
 
+// Logging configures the slog.Handler used by brot and installs it as
+// default logger. Level is the minimum level (debug, info, warn or error),
+// Components overrides it per component. Format is text or json. The values
+// of all attributes named in Redact, or in the built-in list of secret names,
+// are replaced before they are written.
+type Logging struct {
+	Level      string            `brot:"level"`
+	Format     string            `brot:"format"`
+	Out        io.Writer         `brot:"out"`
+	Components map[string]string `brot:"components"`
+	Redact     []string          `brot:"redact"`
+	handler    slog.Handler
+}
+
+// redacted lists the attribute names which are never written in clear text.
+var redacted = []string{"password", "secret", "client_secret", "token", "access_token",
+	"id_token", "jwt_token", "authorization", "cookie", "set-cookie"}
+
+const redactedValue = "[REDACTED]"
+
+func (l *Logging) InitFunc() (err error) {
+	if l.Out == nil {
+		l.Out = os.Stderr
+	}
+	var level slog.Level
+	if level, err = parseLevel(l.Level); err != nil {
+		return
+	}
+	levels := make(map[string]slog.Level, len(l.Components))
+	for component, value := range l.Components {
+		if levels[component], err = parseLevel(value); err != nil {
+			return
+		}
+	}
+
+	secrets := make(map[string]bool, len(redacted)+len(l.Redact))
+	for _, key := range append(redacted, l.Redact...) {
+		secrets[strings.ToLower(key)] = true
+	}
+	opts := &slog.HandlerOptions{
+		// the component handler decides about levels
+		Level: slog.Level(-8),
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if secrets[strings.ToLower(a.Key)] {
+				return slog.String(a.Key, redactedValue)
+			}
+			return a
+		},
+	}
+	var base slog.Handler
+	switch strings.ToLower(l.Format) {
+	case "", "text":
+		base = slog.NewTextHandler(l.Out, opts)
+	case "json":
+		base = slog.NewJSONHandler(l.Out, opts)
+	default:
+		return fmt.Errorf("unknown log format %s", l.Format)
+	}
+	l.handler = &componentHandler{Handler: base, levels: levels, level: level}
+	slog.SetDefault(slog.New(l.handler))
+	return
+}
+
+func (l *Logging) Retry() bool {
+	return false
+}
+
+// Handler returns the configured handler.
+func (l *Logging) Handler() slog.Handler {
+	return l.handler
+}
+
+func parseLevel(value string) (level slog.Level, err error) {
+	if value == "" {
+		return slog.LevelInfo, nil
+	}
+	err = level.UnmarshalText([]byte(value))
+	return
+}
+
+// componentHandler filters records by the level of the component attribute
+// and adds the request scoped attributes stored in the context.
+type componentHandler struct {
+	slog.Handler
+	levels map[string]slog.Level
+	level  slog.Level
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx != nil {
+		if attrs, ok := ctx.Value(logAttrsKey).([]slog.Attr); ok {
+			record.AddAttrs(attrs...)
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	level := h.level
+	for _, attr := range attrs {
+		if attr.Key == "component" {
+			if specific, ok := h.levels[attr.Value.String()]; ok {
+				level = specific
+			}
+		}
+	}
+	return &componentHandler{Handler: h.Handler.WithAttrs(attrs), levels: h.levels, level: level}
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return &componentHandler{Handler: h.Handler.WithGroup(name), levels: h.levels, level: h.level}
+}
+
+// logger returns the logger of a component.
+func logger(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
+
+// WithLogAttrs returns a copy of ctx whose log records carry attrs in
+// addition to the attributes already stored.
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	current, _ := ctx.Value(logAttrsKey).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(current)+len(attrs))
+	merged = append(merged, current...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, logAttrsKey, merged)
+}
+
+// Log writes messages with the standard log package if it is true.
+//
+// Deprecated: use log/slog. Once Logging is configured the output of the log
+// package is written by its handler at level info.
 type Log bool
 
+// Printf logs the message if l is true.
+//
+// Deprecated: use log/slog.
 func (l Log) Printf(format string, v ...interface{}) Log {
 	if l {
 		log.Printf(format, v...)
@@ -17,6 +164,17 @@ func (l Log) Printf(format string, v ...interface{}) Log {
 	return l
 }
 
+// Deprecated: Info, Debug and Warning are not used by brot anymore, use
+// log/slog.
 var Info Log = true
 var Debug Log = true
 var Warning Log = true
+
+// fatal logs the message as error and terminates the process.
+func fatal(l *slog.Logger, msg string, args ...interface{}) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
+
+var _ di.ProvidesInit = (*Logging)(nil)
+var _ = di.GlobalScope.Declare((*Logging)(nil))
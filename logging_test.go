@@ -0,0 +1,87 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggingRedact(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var buf bytes.Buffer
+	l := &Logging{Out: &buf, Format: "json", Redact: []string{"apiKey"}}
+	if err := l.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(l.Handler())
+	log.Info("login", "user", "ann", "Password", "p4ss", "access_token", "abc", "apikey", "k3y",
+		slog.Group("request", "authorization", "Bearer xyz", "path", "/a"))
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	request, _ := entry["request"].(map[string]interface{})
+	if entry["user"] != "ann" || entry["Password"] != redactedValue || entry["access_token"] != redactedValue ||
+		entry["apikey"] != redactedValue || request["authorization"] != redactedValue || request["path"] != "/a" {
+		t.Errorf("unexpected entry %s", buf.String())
+	}
+	for _, secret := range []string{"p4ss", "abc", "k3y", "xyz"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("%s written in clear text: %s", secret, buf.String())
+		}
+	}
+}
+
+func TestLoggingComponentLevels(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var buf bytes.Buffer
+	l := &Logging{Out: &buf, Level: "warn", Components: map[string]string{"templates": "debug", "rest": "error"}}
+	if err := l.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	// InitFunc installs the handler as default
+	if slog.Default().Handler() != l.Handler() {
+		t.Error("expected handler to be the default")
+	}
+	ctx := WithLogAttrs(WithLogAttrs(context.Background(), slog.String("request_id", "r1")), slog.String("user", "ann"))
+	tables := []struct {
+		component string
+		level     slog.Level
+		logged    bool
+	}{
+		{"server", slog.LevelInfo, false},
+		{"server", slog.LevelWarn, true},
+		{"templates", slog.LevelDebug, true},
+		{"rest", slog.LevelWarn, false},
+		{"rest", slog.LevelError, true},
+	}
+	for _, table := range tables {
+		buf.Reset()
+		logger(table.component).Log(ctx, table.level, "message")
+		if logged := buf.Len() > 0; logged != table.logged {
+			t.Errorf("%s %s: expected logged %v, found %q", table.component, table.level, table.logged, buf.String())
+		}
+		if table.logged && (!strings.Contains(buf.String(), "component="+table.component) ||
+			!strings.Contains(buf.String(), "request_id=r1 user=ann")) {
+			t.Errorf("%s %s: missing attributes in %q", table.component, table.level, buf.String())
+		}
+	}
+
+	invalid := []*Logging{
+		{Level: "loud"},
+		{Components: map[string]string{"rest": "quiet"}},
+		{Format: "xml"},
+	}
+	for _, l := range invalid {
+		if err := l.InitFunc(); err == nil {
+			t.Errorf("%+v: expected error", l)
+		}
+	}
+}
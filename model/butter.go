@@ -5,9 +5,10 @@
 package model
 
 import (
+	"errors"
 	"fmt"
-
-	"github.com/fuxsig/brot/aux"
+	"net/http"
+	"reflect"
 
 	"github.com/fuxsig/brot/di"
 )
@@ -17,6 +18,7 @@ type Butter struct {
 	ConfiguredSchemas []*Schema   `brot:"schemas"`
 	Generator         IDGenerator `brot:"generator"`
 	schemas           map[string]*Schema
+	types             map[reflect.Type]*Schema
 }
 
 func (b *Butter) InitFunc() error {
@@ -25,8 +27,12 @@ func (b *Butter) InitFunc() error {
 		b.schemas[schema.Name] = schema
 	}
 	for _, schema := range b.ConfiguredSchemas {
-		schema.initialize(b)
-		b.Conn.BuildIndex(schema)
+		if err := schema.initialize(b); err != nil {
+			return fmt.Errorf("schema %s: %s", schema.Name, err.Error())
+		}
+		if err := b.Conn.BuildIndex(schema); err != nil {
+			return fmt.Errorf("schema %s: %s", schema.Name, err.Error())
+		}
 	}
 	//b.conn = b.Db.Open()
 	return nil
@@ -42,46 +48,83 @@ func (b *Butter) Schema(name string) *Schema {
 }
 
 func (b *Butter) Save(ctx *Context, data map[string]string) (err error) {
+	if err = ctx.Context().Err(); err != nil {
+		return
+	}
 	schemaName := data["_schema"]
 	// get the schema
 	schema, ok := b.schemas[schemaName]
 	if !ok {
 		return fmt.Errorf("Schema %s is unknown", schemaName)
 	}
-	// check values
-	for key, value := range data {
-		if err = schema.CheckValue(key, value); err != nil {
-			return
-		}
+	// complete and check the values against the stored object
+	var current map[string]string
+	if current, err = schema.current(ctx, data["_id"]); err != nil {
+		return
+	}
+	if err = schema.complete(data, current); err != nil {
+		return
+	}
+	if err = schema.check(ctx, data, current); err != nil {
+		return
 	}
 	// save the payload
 	err = b.Conn.Save(ctx, data, schema)
 	return
 }
 
-func (b *Butter) LoadSlice(ctx *Context, ids ...string) ([]map[string]string, error) {
-	var (
-		me  aux.MultiError
-		err error
-	)
-	result := make([]map[string]string, 0, len(ids))
-	var current map[string]string
-	for _, id := range ids {
-		_, current, err = b.Load(ctx, id)
-		if err != nil {
-			me.Append(err)
-			continue
-		}
-		result = append(result, current)
+// LoadSlice loads the objects ids for the user of ctx. The objects, the
+// statuses and the errors of Load are returned in the order of ids, the
+// object of a failed load is nil.
+func (b *Butter) LoadSlice(ctx *Context, ids ...string) (objects []map[string]string, statuses []int, errs []error) {
+	objects = make([]map[string]string, len(ids))
+	statuses = make([]int, len(ids))
+	errs = make([]error, len(ids))
+	for i, id := range ids {
+		statuses[i], objects[i], errs[i] = b.Load(ctx, id)
 	}
-	return result, me.ErrorOrNil()
+	return
 }
 
 func (b *Butter) Load(ctx *Context, id string) (status int, result map[string]string, err error) {
+	if err = ctx.Context().Err(); err != nil {
+		return contextStatus(err), nil, err
+	}
 	return b.Conn.Load(ctx, id)
 }
 
+// Delete removes the object id. Errors are returned with the status of the
+// failed operation.
+func (b *Butter) Delete(ctx *Context, id string) (status int, err error) {
+	var obj map[string]string
+	if status, obj, err = b.Load(ctx, id); err != nil {
+		return
+	}
+	schema, ok := b.schemas[obj["_schema"]]
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("object %s not found", id)
+	}
+	var deleted bool
+	switch deleted, err = b.Conn.Delete(ctx, schema.Plural, id, true); {
+	case errors.Is(err, ErrAccessDenied):
+		return http.StatusForbidden, err
+	case err != nil:
+		return http.StatusInternalServerError, err
+	case !deleted:
+		return http.StatusNotFound, fmt.Errorf("object %s not found", id)
+	}
+	return http.StatusNoContent, nil
+}
+
+// Search returns the objects of schema matching query. Connections index
+// the objects of a schema by its plural, which a schema name is mapped to.
 func (b *Butter) Search(ctx *Context, schema, query, sort string, offset, num int) ([]map[string]string, int, error) {
+	if err := ctx.Context().Err(); err != nil {
+		return nil, 0, err
+	}
+	if s, ok := b.schemas[schema]; ok {
+		schema = s.Plural
+	}
 	return b.Conn.Search(ctx, schema, query, sort, offset, num)
 }
 
@@ -0,0 +1,61 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/fuxsig/brot/model"
+	"github.com/fuxsig/brot/model/modeltest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	modeltest.Run(t, func(t *testing.T) model.Connection {
+		return &model.Memory{}
+	})
+}
+
+// TestSQLConformance runs the suite against the database BROT_SQL_DSN of
+// the driver BROT_SQL_DRIVER, which must be linked into the test binary.
+// The tables of the suite are dropped before every test, so that each test
+// starts with an empty database.
+func TestSQLConformance(t *testing.T) {
+	driver, dsn := os.Getenv("BROT_SQL_DRIVER"), os.Getenv("BROT_SQL_DSN")
+	registered := false
+	for _, current := range sql.Drivers() {
+		registered = registered || current == driver
+	}
+	if !registered {
+		t.Skip("no SQL driver configured")
+	}
+	modeltest.Run(t, func(t *testing.T) model.Connection {
+		dropTables(t, driver, dsn)
+		conn := &model.SQL{Driver: driver, DSN: dsn}
+		if err := conn.InitFunc(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	})
+}
+
+// sqlTables are the tables of the suite named like SQL does, join tables
+// before the tables they reference.
+var sqlTables = []string{"posts_tags", "posts", "authors", "brot_objects", "brot_sequence", "brot_migrations"}
+
+func dropTables(t *testing.T, driver, dsn string) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, table := range sqlTables {
+		if _, err = db.Exec(`DROP TABLE IF EXISTS "` + table + `"`); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -5,24 +5,75 @@
 package model
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"sort"
 	"strings"
 )
 
+// Context identifies the user on whose behalf data is accessed. It is bound
+// to a context.Context, so that connections can stop backend work once the
+// request is cancelled. Connections grant privileged contexts access to
+// all objects, Butter uses them to check unique values.
 type Context struct {
 	User   string
 	groups []string
 	grpstr string
+	ctx    context.Context
+	// privileged contexts are members of all groups
+	privileged bool
 }
 
 var Anonymous = new(Context).SetGroups("all")
 
+// NewContext returns the context of user with the given groups.
+func NewContext(user string, groups ...string) *Context {
+	return (&Context{User: user}).SetGroups(groups...)
+}
+
 func (c *Context) SetGroups(groups ...string) *Context {
 	sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
 	c.groups = groups
 	return c
 }
 
+// WithContext returns a copy of c bound to ctx.
+func (c *Context) WithContext(ctx context.Context) *Context {
+	if c == nil {
+		c = Anonymous
+	}
+	result := *c
+	result.ctx = ctx
+	return &result
+}
+
+// Context returns the bound context.Context, context.Background if there is
+// none.
+func (c *Context) Context() context.Context {
+	if c == nil || c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Privileged reports whether c may access all objects.
+func (c *Context) Privileged() bool {
+	return c != nil && c.privileged
+}
+
+// asPrivileged returns a privileged copy of c.
+func (c *Context) asPrivileged() *Context {
+	result := c.WithContext(c.Context())
+	result.privileged = true
+	return result
+}
+
+// Groups returns the sorted groups of the user.
+func (c *Context) Groups() []string {
+	return c.groups
+}
+
 func (c *Context) GroupsString() string {
 	if c.grpstr == "" {
 		c.grpstr = strings.Join(c.groups, " | ")
@@ -31,15 +82,38 @@ func (c *Context) GroupsString() string {
 }
 
 func (c *Context) MemberOf(groups ...string) bool {
+	if c.privileged {
+		return true
+	}
 	l := len(c.groups)
 	for i := range groups {
-		if sort.SearchStrings(c.groups, groups[i]) < l {
+		if j := sort.SearchStrings(c.groups, groups[i]); j < l && c.groups[j] == groups[i] {
 			return true
 		}
 	}
 	return false
 }
 
+// StatusClientClosedRequest is the status of operations aborted because
+// the client cancelled the request.
+const StatusClientClosedRequest = 499
+
+// contextStatus returns the status of an operation aborted by the context
+// error err: 504 if the deadline passed, StatusClientClosedRequest otherwise.
+func contextStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return StatusClientClosedRequest
+}
+
+// ErrAccessDenied is returned by connections if the user of the context
+// lacks the groups required to change or delete an object.
+var ErrAccessDenied = errors.New("access denied")
+
+// Connection stores the objects of a Butter. Save creates an object if it
+// has no _id and updates only the given values otherwise. Delete reports
+// whether the object id of index existed.
 type Connection interface {
 	Load(ctx *Context, id string) (int, map[string]string, error)
 	Save(*Context, map[string]string, *Schema) error
@@ -47,7 +121,7 @@ type Connection interface {
 
 	Search(ctx *Context, schema, query, sort string, offset, num int) ([]map[string]string, int, error)
 
-	BuildIndex(schema *Schema)
+	BuildIndex(schema *Schema) error
 }
 
 type Database interface {
@@ -5,33 +5,116 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/mail"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// Element describes a field of a schema. Label and Help are shown in
+// generated forms, Label defaults to Name. Widget overrides the input
+// derived from Type, e.g. textarea, password, email or hidden.
+//
+// Type is one of string, text, int, float, bool, date (2006-01-02),
+// datetime (RFC 3339), enum, email, url or json, or *Name to reference an
+// object of the schema Name. A [] prefix declares a slice, whose values are
+// stored comma separated; text and json cannot be sliced.
+//
+// Required elements must not be empty. Default is set if a new object has
+// no value, immutable elements cannot be changed after creation. Min and
+// Max bound numbers, MinLength and MaxLength the characters of all other
+// values. Pattern is a regular expression values must match, Values lists
+// the choices of an enum. The value of a Unique element must not be used
+// by another object of the schema.
 type Element struct {
-	Name  string `brot:"name"`
-	Type  string `brot:"type"`
-	Index bool   `brot:"index"`
-	Sort  bool   `brot:"index"`
+	Name      string   `brot:"name"`
+	Type      string   `brot:"type"`
+	Index     bool     `brot:"index"`
+	Sort      bool     `brot:"index"`
+	Label     string   `brot:"label"`
+	Help      string   `brot:"help"`
+	Widget    string   `brot:"widget"`
+	Required  bool     `brot:"required"`
+	Default   string   `brot:"default"`
+	Immutable bool     `brot:"immutable"`
+	Unique    bool     `brot:"unique"`
+	Min       *float64 `brot:"min"`
+	Max       *float64 `brot:"max"`
+	MinLength int      `brot:"minLength"`
+	MaxLength int      `brot:"maxLength"`
+	Pattern   string   `brot:"pattern"`
+	Values    []string `brot:"values"`
 
 	slice   bool
 	pointer bool
+	target  string
+	base    string
 	kind    reflect.Kind
-	check   func(string) error
+	re      *regexp.Regexp
+	check   func(string) (code, message string)
 }
 
+// Slice reports whether the element holds a list of values.
+func (e *Element) Slice() bool {
+	return e.slice
+}
+
+// Pointer reports whether the element references objects of Target.
+func (e *Element) Pointer() bool {
+	return e.pointer
+}
+
+// Target returns the name of the referenced schema of a pointer element.
+func (e *Element) Target() string {
+	return e.target
+}
+
+// Kind returns the kind of a single value, reflect.Struct for pointers.
+func (e *Element) Kind() reflect.Kind {
+	return e.kind
+}
+
+// Base returns the type of a single value, e.g. email for []email and the
+// target for pointers.
+func (e *Element) Base() string {
+	return e.base
+}
+
+// dateTimeLayouts are accepted by datetime elements, the last two are sent
+// by datetime-local inputs.
+var dateTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04"}
+
 func (e *Element) initialize(schemas map[string]*Schema) error {
 	t := strings.TrimSpace(e.Type)
 	e.Type = t
+	if e.Label == "" {
+		e.Label = e.Name
+	}
 	// is it a slice?
 	s := strings.HasPrefix(t, "[]")
 	e.slice = s
 	if s {
 		t = t[2:]
 	}
+	e.base = t
+	if e.Pattern != "" {
+		var err error
+		if e.re, err = regexp.Compile(e.Pattern); err != nil {
+			return fmt.Errorf("Element %s has invalid pattern: %s", e.Name, err.Error())
+		}
+	}
+	if e.Unique && s {
+		return fmt.Errorf("Element %s of type %s cannot be unique", e.Name, e.Type)
+	}
+	if len(e.Values) > 0 && t != "enum" {
+		return fmt.Errorf("Element %s of type %s must not declare values", e.Name, e.Type)
+	}
 	// is it a pointer?
 	p := strings.HasPrefix(t, "*")
 	e.pointer = p
@@ -42,29 +125,119 @@ func (e *Element) initialize(schemas map[string]*Schema) error {
 		if !ok {
 			return fmt.Errorf("Element %s of type %s references unknown schems %s", e.Name, e.Type, t)
 		}
+		e.target = t
+		e.base = t
 		e.kind = reflect.Struct
-		e.check = func(value string) error {
-			if value == "" {
-				return nil
+		// the referenced object is looked up by Schema.Check
+		e.check = func(value string) (string, string) {
+			if len(value) > 256 {
+				return TypeViolation, fmt.Sprintf("%s must be an id of %s", e.Name, t)
 			}
-			return nil
+			return "", ""
 		}
 		return nil
-
 	}
 	// is it a predefined type?
+	e.kind = reflect.String
 	switch t {
-	case "string":
-		e.kind = reflect.String
-		e.check = func(string) error { return nil }
+	case "string", "email", "url", "date", "datetime":
+		e.check = e.checkString
+	case "text", "json":
+		if s {
+			return fmt.Errorf("Invalid declaration: %s cannot be sliced", e.Type)
+		}
+		e.check = e.checkString
+	case "enum":
+		if len(e.Values) == 0 {
+			return fmt.Errorf("Element %s of type enum must declare values", e.Name)
+		}
+		e.check = e.checkString
 	case "int":
 		e.kind = reflect.Int
-		e.check = func(value string) error {
-			_, err := strconv.ParseInt(value, 10, 64)
-			return err
+		e.check = func(value string) (string, string) {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return TypeViolation, fmt.Sprintf("%s must be of type int", e.Name)
+			}
+			return e.checkRange(float64(v))
+		}
+	case "float":
+		e.kind = reflect.Float64
+		e.check = func(value string) (string, string) {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return TypeViolation, fmt.Sprintf("%s must be of type float", e.Name)
+			}
+			return e.checkRange(v)
+		}
+	case "bool":
+		e.kind = reflect.Bool
+		e.check = func(value string) (string, string) {
+			if value != "true" && value != "false" {
+				return TypeViolation, fmt.Sprintf("%s must be true or false", e.Name)
+			}
+			return "", ""
 		}
 	default:
 		return fmt.Errorf("Invalid declaration: %s", e.Type)
 	}
 	return nil
 }
+
+func (e *Element) checkRange(v float64) (string, string) {
+	if e.Min != nil && v < *e.Min {
+		return MinViolation, fmt.Sprintf("%s is below the minimum %v", e.Name, *e.Min)
+	}
+	if e.Max != nil && v > *e.Max {
+		return MaxViolation, fmt.Sprintf("%s is above the maximum %v", e.Name, *e.Max)
+	}
+	return "", ""
+}
+
+// checkString checks a value of the types stored as text.
+func (e *Element) checkString(value string) (string, string) {
+	valid := true
+	switch e.base {
+	case "email":
+		addr, err := mail.ParseAddress(value)
+		valid = err == nil && addr.Address == value
+	case "url":
+		u, err := url.Parse(value)
+		valid = err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	case "json":
+		valid = json.Valid([]byte(value))
+	case "date":
+		_, err := time.Parse("2006-01-02", value)
+		valid = err == nil
+	case "datetime":
+		valid = false
+		for _, layout := range dateTimeLayouts {
+			if _, err := time.Parse(layout, value); err == nil {
+				valid = true
+				break
+			}
+		}
+	case "enum":
+		valid = false
+		for _, current := range e.Values {
+			valid = valid || current == value
+		}
+		if !valid {
+			return EnumViolation, fmt.Sprintf("%s must be one of %s", e.Name, strings.Join(e.Values, ", "))
+		}
+	}
+	if !valid {
+		return TypeViolation, fmt.Sprintf("%s must be of type %s", e.Name, e.base)
+	}
+	length := utf8.RuneCountInString(value)
+	if e.MinLength > 0 && length < e.MinLength {
+		return MinViolation, fmt.Sprintf("%s is shorter than %d characters", e.Name, e.MinLength)
+	}
+	if e.MaxLength > 0 && length > e.MaxLength {
+		return MaxViolation, fmt.Sprintf("%s is longer than %d characters", e.Name, e.MaxLength)
+	}
+	if e.re != nil && !e.re.MatchString(value) {
+		return PatternViolation, fmt.Sprintf("%s does not match %s", e.Name, e.Pattern)
+	}
+	return "", ""
+}
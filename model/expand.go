@@ -0,0 +1,152 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidExpansion is wrapped by the errors of Expand for paths which
+// are too deep or do not name pointers.
+var ErrInvalidExpansion = errors.New("invalid expansion")
+
+// expandNode is an object whose value is built by Expand.
+type expandNode struct {
+	data   map[string]string
+	value  map[string]interface{}
+	schema *Schema
+	// ancestors are the ids of the objects embedding the node
+	ancestors map[string]bool
+}
+
+// Expand returns the values of objects like Schema.MarshalObject with the
+// objects referenced by the pointer elements in paths embedded instead of
+// their links. A path like author.company expands the pointers of embedded
+// objects, paths must not be longer than depth. The objects of a level are
+// loaded together with LoadSlice for the user of ctx. Objects the user may
+// not read, missing objects and objects embedding themselves, directly or
+// through others, stay links, other errors of the connection are returned.
+func (b *Butter) Expand(ctx *Context, objects []map[string]string, paths []string, depth int) ([]map[string]interface{}, error) {
+	tree := make(expandTree)
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		names := strings.Split(path, ".")
+		if len(names) > depth {
+			return nil, fmt.Errorf("%w: %s is deeper than %d", ErrInvalidExpansion, path, depth)
+		}
+		tree.add(names)
+	}
+	nodes := make([]*expandNode, len(objects))
+	result := make([]map[string]interface{}, len(objects))
+	for i, data := range objects {
+		schema := b.schemas[data["_schema"]]
+		nodes[i] = &expandNode{data: data, value: schema.jsonObject(data, nil), schema: schema}
+		if id := data["_id"]; id != "" {
+			nodes[i].ancestors = map[string]bool{id: true}
+		}
+		result[i] = nodes[i].value
+	}
+	if err := b.expand(ctx, nodes, tree); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// expandTree holds the paths of an expansion by their first name.
+type expandTree map[string]expandTree
+
+func (t expandTree) add(names []string) {
+	child, ok := t[names[0]]
+	if !ok {
+		child = make(expandTree)
+		t[names[0]] = child
+	}
+	if len(names) > 1 {
+		child.add(names[1:])
+	}
+}
+
+// expand embeds the objects of the elements of tree into nodes.
+func (b *Butter) expand(ctx *Context, nodes []*expandNode, tree expandTree) error {
+	for name, subtree := range tree {
+		// collect the ids of all nodes to load them at once
+		var ids []string
+		seen := make(map[string]bool)
+		for _, node := range nodes {
+			if node.schema == nil || node.schema.Element(name) == nil || !node.schema.Element(name).pointer {
+				return fmt.Errorf("%w: %s is not a pointer of %s", ErrInvalidExpansion, name, node.data["_schema"])
+			}
+			for _, id := range node.ids(name) {
+				if !seen[id] && !node.ancestors[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		objects, statuses, errs := b.LoadSlice(ctx, ids...)
+		byID := make(map[string]map[string]string, len(ids))
+		for i, id := range ids {
+			switch {
+			case errs[i] == nil:
+				byID[id] = objects[i]
+			case statuses[i] != http.StatusForbidden && statuses[i] != http.StatusNotFound:
+				return errs[i]
+			}
+		}
+		var children []*expandNode
+		for _, node := range nodes {
+			e := node.schema.Element(name)
+			embed := func(id string) interface{} {
+				obj, ok := byID[id]
+				if !ok || node.ancestors[id] || obj["_schema"] != e.target {
+					return e.jsonValue(id)
+				}
+				child := &expandNode{data: obj, value: b.schemas[e.target].jsonObject(obj, nil), schema: b.schemas[e.target],
+					ancestors: make(map[string]bool, len(node.ancestors)+1)}
+				for ancestor := range node.ancestors {
+					child.ancestors[ancestor] = true
+				}
+				child.ancestors[id] = true
+				children = append(children, child)
+				return child.value
+			}
+			ids := node.ids(name)
+			if !e.slice {
+				if len(ids) > 0 {
+					node.value[name] = embed(ids[0])
+				}
+				continue
+			}
+			values := make([]interface{}, len(ids))
+			for i, id := range ids {
+				values[i] = embed(id)
+			}
+			node.value[name] = values
+		}
+		if len(subtree) > 0 && len(children) > 0 {
+			if err := b.expand(ctx, children, subtree); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ids returns the ids referenced by the element name.
+func (n *expandNode) ids(name string) []string {
+	value := n.data[name]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
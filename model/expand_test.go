@@ -0,0 +1,134 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// countingConn counts the loads of a connection, loads of broken fail.
+type countingConn struct {
+	*Memory
+	loads  int
+	broken string
+}
+
+func (c *countingConn) Load(ctx *Context, id string) (int, map[string]string, error) {
+	c.loads++
+	if id == c.broken {
+		return http.StatusInternalServerError, nil, errors.New("connection lost")
+	}
+	return c.Memory.Load(ctx, id)
+}
+
+func TestExpand(t *testing.T) {
+	conn := &countingConn{Memory: &Memory{}}
+	butter := &Butter{Conn: conn, ConfiguredSchemas: []*Schema{
+		{Name: "Company", Elements: []*Element{{Name: "name", Type: "string"}}},
+		{Name: "Person", Elements: []*Element{{Name: "name", Type: "string"}, {Name: "company", Type: "*Company"}, {Name: "friends", Type: "[]*Person"}}},
+	}}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	save := func(ctx *Context, data map[string]string) string {
+		if err := conn.Save(ctx, data, butter.Schema(data["_schema"])); err != nil {
+			t.Fatal(err)
+		}
+		return data["_id"]
+	}
+	acme := save(nil, map[string]string{"_schema": "Company", "name": "Acme"})
+	secret := save(nil, map[string]string{"_schema": "Company", "name": "Secret", "_read": "staff"})
+	ann := save(nil, map[string]string{"_schema": "Person", "name": "Ann", "company": acme})
+	bob := save(nil, map[string]string{"_schema": "Person", "name": "Bob", "company": secret, "friends": ann})
+	save(nil, map[string]string{"_schema": "Person", "_id": ann, "friends": bob + ",brot:missing"})
+
+	_, annData, _ := conn.Load(nil, ann)
+	_, bobData, _ := conn.Load(nil, bob)
+	conn.loads = 0
+	result, err := butter.Expand(nil, []map[string]string{annData, bobData}, []string{"company", "friends.company", "friends.friends"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(result)
+	expected := `[{"_id":"` + ann + `","company":{"_id":"` + acme + `","name":"Acme"},` +
+		`"friends":[{"_id":"` + bob + `","company":{"_id":"` + secret + `","_schema":"Company"},` +
+		`"friends":[{"_id":"` + ann + `","_schema":"Person"}],"name":"Bob"},{"_id":"brot:missing","_schema":"Person"}],"name":"Ann"},` +
+		`{"_id":"` + bob + `","company":{"_id":"` + secret + `","_schema":"Company"},` +
+		`"friends":[{"_id":"` + ann + `","company":{"_id":"` + acme + `","name":"Acme"},` +
+		`"friends":[{"_id":"` + bob + `","_schema":"Person"},{"_id":"brot:missing","_schema":"Person"}],"name":"Ann"}],"name":"Bob"}]`
+	if found := stripMeta(t, b); found != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, found)
+	}
+	// one load per id and level: two companies, three friends, the two
+	// companies of the friends and the missing friend of Ann
+	if conn.loads != 8 {
+		t.Errorf("expected 8 loads, found %d", conn.loads)
+	}
+
+	staff := NewContext("sam", "all", "staff")
+	if result, err = butter.Expand(staff, []map[string]string{bobData}, []string{"company"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if company, ok := result[0]["company"].(map[string]interface{}); !ok || company["name"] != "Secret" {
+		t.Errorf("expected readable company for staff, found %v", result[0]["company"])
+	}
+	for _, paths := range [][]string{{"friends.company"}, {"name"}, {"unknown"}} {
+		if _, err = butter.Expand(nil, []map[string]string{bobData}, paths, 1); !errors.Is(err, ErrInvalidExpansion) {
+			t.Errorf("%v: expected invalid expansion, found %v", paths, err)
+		}
+	}
+	// other errors than missing or unreadable objects are returned
+	conn.broken = acme
+	if _, err = butter.Expand(nil, []map[string]string{annData}, []string{"company"}, 1); err == nil || errors.Is(err, ErrInvalidExpansion) {
+		t.Errorf("expected the load error, found %v", err)
+	}
+	// LoadSlice reports every id
+	objects, statuses, errs := butter.LoadSlice(nil, ann, secret, "brot:missing", acme)
+	if objects[0]["name"] != "Ann" || statuses[0] != http.StatusOK || errs[0] != nil {
+		t.Errorf("expected Ann, found %v %d %v", objects[0], statuses[0], errs[0])
+	}
+	for i, status := range []int{http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError} {
+		if objects[i+1] != nil || statuses[i+1] != status || errs[i+1] == nil {
+			t.Errorf("expected %d, found %v %d %v", status, objects[i+1], statuses[i+1], errs[i+1])
+		}
+	}
+}
+
+// stripMeta returns the JSON b without the values managed by connections
+// except _id and the _schema of links.
+func stripMeta(t *testing.T, b []byte) string {
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		t.Fatal(err)
+	}
+	var strip func(v interface{})
+	strip = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, current := range v {
+				strip(current)
+			}
+		case map[string]interface{}:
+			for key, current := range v {
+				switch key {
+				case "_schema":
+					if len(v) > 2 {
+						delete(v, key)
+					}
+				case "_created", "_modified", "_read", "_write", "_delete":
+					delete(v, key)
+				default:
+					strip(current)
+				}
+			}
+		}
+	}
+	strip(value)
+	b, _ = json.Marshal(value)
+	return string(b)
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MarshalObject returns the JSON of the object data. Values of int and
+// float elements are numbers, bool values booleans and json values are
+// embedded. Slices are arrays, pointers are links like
+// {"_id": "brot:1", "_schema": "Author"}. _created and _modified are
+// numbers, all other values strings. Values which do not match their type
+// are kept as strings. If fields are given only these values are included.
+// A nil schema returns all values as strings.
+func (s *Schema) MarshalObject(data map[string]string, fields ...string) ([]byte, error) {
+	return json.Marshal(s.jsonObject(data, fields))
+}
+
+func (s *Schema) jsonObject(data map[string]string, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		result := make(map[string]interface{}, len(data))
+		for key, value := range data {
+			result[key] = s.jsonValue(key, value)
+		}
+		return result
+	}
+	result := make(map[string]interface{}, len(fields))
+	for _, key := range fields {
+		if value, ok := data[key]; ok {
+			result[key] = s.jsonValue(key, value)
+		}
+	}
+	return result
+}
+
+// jsonValue returns the value of the element name for json.Marshal.
+func (s *Schema) jsonValue(name, value string) interface{} {
+	switch name {
+	case "_created", "_modified":
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+		return value
+	}
+	var e *Element
+	if s != nil {
+		e = s.elements[name]
+	}
+	if e == nil {
+		return value
+	}
+	if !e.slice {
+		return e.jsonValue(value)
+	}
+	values := []interface{}{}
+	if value != "" {
+		for _, current := range strings.Split(value, ",") {
+			values = append(values, e.jsonValue(current))
+		}
+	}
+	return values
+}
+
+// jsonValue returns a single value of e for json.Marshal.
+func (e *Element) jsonValue(value string) interface{} {
+	if e.pointer {
+		if value == "" {
+			return nil
+		}
+		return map[string]string{"_id": value, "_schema": e.target}
+	}
+	if value == "" && e.base != "string" && e.base != "text" {
+		return nil
+	}
+	switch {
+	case e.kind == reflect.Int:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	case e.kind == reflect.Float64:
+		if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return f
+		}
+	case e.kind == reflect.Bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case e.base == "json":
+		if json.Valid([]byte(value)) {
+			return json.RawMessage(value)
+		}
+	}
+	return value
+}
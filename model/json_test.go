@@ -0,0 +1,61 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarshalObject(t *testing.T) {
+	butter := &Butter{Conn: &Memory{}, ConfiguredSchemas: []*Schema{
+		{Name: "Author", Elements: []*Element{{Name: "name", Type: "string"}}},
+		{Name: "Post", Elements: []*Element{
+			{Name: "title", Type: "string"},
+			{Name: "views", Type: "int"},
+			{Name: "rating", Type: "float"},
+			{Name: "public", Type: "bool"},
+			{Name: "scores", Type: "[]int"},
+			{Name: "meta", Type: "json"},
+			{Name: "author", Type: "*Author"},
+			{Name: "editors", Type: "[]*Author"},
+			{Name: "day", Type: "date"},
+		}},
+	}}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{
+		"_id": "brot:1", "_schema": "Post", "_created": "1520000000",
+		"title": "Say \"hi\"\n</script>", "views": "3", "rating": "4.5", "public": "true",
+		"scores": "1,2", "meta": `{"a":[1]}`, "author": "brot:2", "editors": "brot:2,brot:3",
+		"day": "2018-03-01", "legacy": "x",
+	}
+	v := &Vanilla{Schema: butter.Schema("Post"), Data: data}
+	expected := `{"_created":1520000000,"_id":"brot:1","_schema":"Post",` +
+		`"author":{"_id":"brot:2","_schema":"Author"},"day":"2018-03-01",` +
+		`"editors":[{"_id":"brot:2","_schema":"Author"},{"_id":"brot:3","_schema":"Author"}],` +
+		`"legacy":"x","meta":{"a":[1]},"public":true,"rating":4.5,"scores":[1,2],` +
+		`"title":"Say \"hi\"\n\u003c/script\u003e","views":3}`
+	if found := string(v.Json()); found != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, found)
+	}
+	if found := string(v.JsonPartial("title", "views", "missing")); found != `{"title":"Say \"hi\"\n\u003c/script\u003e","views":3}` {
+		t.Errorf("unexpected partial %s", found)
+	}
+	b, err := json.Marshal(v)
+	if err != nil || string(b) != expected {
+		t.Errorf("expected marshalled vanilla, found %s %v", b, err)
+	}
+
+	// invalid values are kept as strings, a missing schema returns strings
+	invalid := map[string]string{"views": "many", "public": "", "meta": "{", "scores": ""}
+	if b, _ = butter.Schema("Post").MarshalObject(invalid); string(b) != `{"meta":"{","public":null,"scores":[],"views":"many"}` {
+		t.Errorf("unexpected invalid object %s", b)
+	}
+	if b, _ = (&Vanilla{Data: map[string]string{"views": "3"}}).MarshalJSON(); string(b) != `{"views":"3"}` {
+		t.Errorf("unexpected object without schema %s", b)
+	}
+}
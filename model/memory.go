@@ -0,0 +1,299 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuxsig/brot/di"
+)
+
+// Memory is a Connection keeping all objects in memory. It behaves like
+// the RediSearch connection: ids are generated, _created and _modified are
+// stamped and the groups of _read, _write and _delete are checked. If Path
+// is set the objects are loaded from this JSON file at start and written to
+// it after every change.
+//
+// Search understands a subset of the RediSearch query syntax. Terms are
+// combined with AND, * or an empty query matches all objects:
+//
+//	word             a word of any element, word* matches a prefix
+//	"some words"     a phrase of any element
+//	@name:word       a word or phrase of the element name
+//	@name:{a|b}      one of the comma separated values of name is a or b
+//	@name:[1 (5]     the number name is in the range, ( excludes the bound
+//	-term            the term must not match
+type Memory struct {
+	Path    string `brot:"path"`
+	mutex   sync.RWMutex
+	next    int64
+	seq     int64
+	objects map[string]*memoryObject
+	schemas map[string]string
+}
+
+type memoryObject struct {
+	seq  int64
+	data map[string]string
+}
+
+// memorySnapshot is the content of the file at Path.
+type memorySnapshot struct {
+	Next    int64               `json:"next"`
+	Objects []map[string]string `json:"objects"`
+}
+
+func (m *Memory) InitFunc() (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.init()
+	if m.Path == "" {
+		return
+	}
+	var b []byte
+	if b, err = ioutil.ReadFile(m.Path); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+	var snapshot memorySnapshot
+	if err = json.Unmarshal(b, &snapshot); err != nil {
+		return fmt.Errorf("invalid snapshot %s: %s", m.Path, err.Error())
+	}
+	m.next = snapshot.Next
+	for _, data := range snapshot.Objects {
+		m.seq++
+		m.objects[data["_id"]] = &memoryObject{seq: m.seq, data: data}
+	}
+	return
+}
+
+func (m *Memory) Retry() bool {
+	return false
+}
+
+// init prepares the maps of a Memory used without InitFunc.
+func (m *Memory) init() {
+	if m.objects == nil {
+		m.objects = make(map[string]*memoryObject)
+		m.schemas = make(map[string]string)
+	}
+}
+
+// BuildIndex makes the objects of schema searchable by its name and
+// plural.
+func (m *Memory) BuildIndex(schema *Schema) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.init()
+	m.schemas[strings.ToLower(schema.Name)] = schema.Name
+	m.schemas[strings.ToLower(schema.Plural)] = schema.Name
+	return nil
+}
+
+func (m *Memory) Load(ctx *Context, id string) (int, map[string]string, error) {
+	if ctx == nil {
+		ctx = Anonymous
+	}
+	if err := ctx.Context().Err(); err != nil {
+		return contextStatus(err), nil, err
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	obj, ok := m.objects[id]
+	if !ok {
+		return http.StatusNotFound, nil, fmt.Errorf("object %s not found", id)
+	}
+	if !memberOf(ctx, obj.data["_read"]) {
+		return http.StatusForbidden, nil, fmt.Errorf("forbidden")
+	}
+	return http.StatusOK, copyObject(obj.data), nil
+}
+
+// Save creates the object or updates the given values, empty values are
+// removed.
+func (m *Memory) Save(ctx *Context, data map[string]string, schema *Schema) (err error) {
+	if ctx == nil {
+		ctx = Anonymous
+	}
+	if err = ctx.Context().Err(); err != nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.init()
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	id := data["_id"]
+	obj, ok := m.objects[id]
+	if ok {
+		if obj.data["_schema"] != data["_schema"] {
+			return fmt.Errorf("wrong schema %s, expected %s", data["_schema"], obj.data["_schema"])
+		}
+		if !memberOf(ctx, obj.data["_write"]) {
+			return ErrAccessDenied
+		}
+	} else {
+		for id == "" || m.objects[id] != nil {
+			m.next++
+			id = "brot:" + strconv.FormatInt(m.next, 36)
+		}
+		data["_id"] = id
+		for _, key := range []string{"_read", "_write", "_delete"} {
+			if data[key] == "" {
+				data[key] = "all"
+			}
+		}
+		data["_created"] = now
+		m.seq++
+		obj = &memoryObject{seq: m.seq, data: make(map[string]string, len(data))}
+		m.objects[id] = obj
+	}
+	data["_modified"] = now
+	for key, value := range data {
+		if value == "" {
+			delete(obj.data, key)
+		} else {
+			obj.data[key] = value
+		}
+	}
+	return m.snapshot()
+}
+
+func (m *Memory) Delete(ctx *Context, index, id string, document bool) (bool, error) {
+	if ctx == nil {
+		ctx = Anonymous
+	}
+	if err := ctx.Context().Err(); err != nil {
+		return false, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	obj, ok := m.objects[id]
+	if !ok {
+		return false, nil
+	}
+	if !memberOf(ctx, obj.data["_delete"]) {
+		return false, ErrAccessDenied
+	}
+	delete(m.objects, id)
+	return true, m.snapshot()
+}
+
+// Search returns the page of objects of the schema index matching query,
+// sorted by the element sort, which is prefixed by - for a descending
+// order. Without sort the objects are returned in the order of creation. A
+// negative offset is treated as 0.
+func (m *Memory) Search(ctx *Context, index, query, sort string, offset, num int) ([]map[string]string, int, error) {
+	if ctx == nil {
+		ctx = Anonymous
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if err := ctx.Context().Err(); err != nil {
+		return nil, 0, err
+	}
+	terms, err := parseQuery(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	schema, ok := m.schemas[strings.ToLower(index)]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown index %s", index)
+	}
+	var found []*memoryObject
+	for _, obj := range m.objects {
+		if obj.data["_schema"] == schema && memberOf(ctx, obj.data["_read"]) && matchesAll(terms, obj.data) {
+			found = append(found, obj)
+		}
+	}
+	sortObjects(found, sort)
+	total := len(found)
+	if offset > total {
+		offset = total
+	}
+	if num >= 0 && offset+num < total {
+		found = found[offset : offset+num]
+	} else {
+		found = found[offset:]
+	}
+	result := make([]map[string]string, len(found))
+	for i, obj := range found {
+		result[i] = copyObject(obj.data)
+	}
+	return result, total, nil
+}
+
+// snapshot writes all objects to Path, the caller holds the lock.
+func (m *Memory) snapshot() error {
+	if m.Path == "" {
+		return nil
+	}
+	objects := make([]*memoryObject, 0, len(m.objects))
+	for _, obj := range m.objects {
+		objects = append(objects, obj)
+	}
+	sortObjects(objects, "")
+	snapshot := memorySnapshot{Next: m.next, Objects: make([]map[string]string, len(objects))}
+	for i, obj := range objects {
+		snapshot.Objects[i] = obj.data
+	}
+	b, err := json.Marshal(&snapshot)
+	if err != nil {
+		return err
+	}
+	// replace the file atomically
+	tmp := m.Path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.Path)
+}
+
+func memberOf(ctx *Context, groups string) bool {
+	return ctx.MemberOf(strings.Split(groups, ",")...)
+}
+
+func copyObject(data map[string]string) map[string]string {
+	result := make(map[string]string, len(data))
+	for key, value := range data {
+		result[key] = value
+	}
+	return result
+}
+
+func sortObjects(objects []*memoryObject, by string) {
+	desc := strings.HasPrefix(by, "-")
+	by = strings.TrimLeft(by, "+-")
+	sort.SliceStable(objects, func(i, j int) bool {
+		if by != "" {
+			a, b := objects[i].data[by], objects[j].data[by]
+			if a != b {
+				fa, erra := strconv.ParseFloat(a, 64)
+				fb, errb := strconv.ParseFloat(b, 64)
+				if erra == nil && errb == nil {
+					return (fa < fb) != desc
+				}
+				return (a < b) != desc
+			}
+		}
+		return objects[i].seq < objects[j].seq
+	})
+}
+
+var _ Connection = (*Memory)(nil)
+var _ di.ProvidesInit = (*Memory)(nil)
+var _ = di.GlobalScope.Declare((*Memory)(nil))
@@ -0,0 +1,126 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemorySearch(t *testing.T) {
+	m := &Memory{}
+	schema := &Schema{Name: "Book", Plural: "books"}
+	if err := m.BuildIndex(schema); err != nil {
+		t.Fatal(err)
+	}
+	books := []map[string]string{
+		{"title": "The Go Programming Language", "year": "2015", "tags": "go,programming"},
+		{"title": "Programming Pearls", "year": "1986", "tags": "algorithms"},
+		{"title": "Go in Practice", "year": "2016", "tags": "go", "_read": "staff"},
+		{"title": "Structure and Interpretation", "year": "1985", "tags": "lisp,programming"},
+	}
+	for _, book := range books {
+		book["_schema"] = "Book"
+		if err := m.Save(nil, book, schema); err != nil {
+			t.Fatal(err)
+		}
+	}
+	staff := NewContext("bob", "all", "staff")
+	tables := []struct {
+		ctx      *Context
+		query    string
+		sort     string
+		offset   int
+		num      int
+		expected string
+		total    int
+	}{
+		{nil, "", "", 0, 10, "The Go Programming Language|Programming Pearls|Structure and Interpretation", 3},
+		{staff, "*", "-year", 0, 2, "Go in Practice|The Go Programming Language", 4},
+		{staff, "go", "", 0, 10, "The Go Programming Language|Go in Practice", 2},
+		{staff, "prog*", "year", 0, 10, "Structure and Interpretation|Programming Pearls|The Go Programming Language", 3},
+		{staff, `"in practice"`, "", 0, 10, "Go in Practice", 1},
+		{staff, "@tags:{lisp|algorithms}", "title", 0, 10, "Programming Pearls|Structure and Interpretation", 2},
+		{staff, "@year:[1986 (2016]", "", 0, 10, "The Go Programming Language|Programming Pearls", 2},
+		{staff, "@year:[-inf +inf] -@tags:{go}", "", 1, 10, "Structure and Interpretation", 2},
+		{staff, "@title:go -language", "", 0, 10, "Go in Practice", 1},
+	}
+	for _, table := range tables {
+		result, total, err := m.Search(table.ctx, "books", table.query, table.sort, table.offset, table.num)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := make([]string, len(result))
+		for i, obj := range result {
+			titles[i] = obj["title"]
+		}
+		if found := strings.Join(titles, "|"); found != table.expected || total != table.total {
+			t.Errorf("%s: expected %s (%d), found %s (%d)", table.query, table.expected, table.total, found, total)
+		}
+	}
+	for _, query := range []string{"@tags:{go", "@year:[1 2 3]", "@:x", "@year:[a 2]"} {
+		if _, _, err := m.Search(nil, "Book", query, "", 0, 10); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+	if _, _, err := m.Search(nil, "Movie", "", "", 0, 10); err == nil {
+		t.Error("expected error for unknown index")
+	}
+}
+
+func TestMemoryAccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "objects.json")
+	m := &Memory{Path: path}
+	if err := m.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	schema := &Schema{Name: "Note", Plural: "notes"}
+	if err := m.BuildIndex(schema); err != nil {
+		t.Fatal(err)
+	}
+	note := map[string]string{"_schema": "Note", "text": "secret", "_write": "owners", "_delete": "owners"}
+	if err := m.Save(nil, note, schema); err != nil {
+		t.Fatal(err)
+	}
+	id := note["_id"]
+	if note["_read"] != "all" || note["_created"] == "" || note["_modified"] == "" {
+		t.Errorf("expected defaults, found %v", note)
+	}
+	owner := NewContext("alice", "all", "owners")
+	if err := m.Save(nil, map[string]string{"_id": id, "_schema": "Note", "text": "changed"}, schema); err != ErrAccessDenied {
+		t.Errorf("expected access denied, found %v", err)
+	}
+	if err := m.Save(owner, map[string]string{"_id": id, "_schema": "Other"}, schema); err == nil {
+		t.Error("expected error for wrong schema")
+	}
+	if err := m.Save(owner, map[string]string{"_id": id, "_schema": "Note", "text": "", "title": "Hi"}, schema); err != nil {
+		t.Fatal(err)
+	}
+	if _, deleted := m.Delete(nil, "notes", id, true); deleted != ErrAccessDenied {
+		t.Errorf("expected access denied, found %v", deleted)
+	}
+
+	// a second memory reads the snapshot
+	restored := &Memory{Path: path}
+	if err := restored.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	status, obj, err := restored.Load(nil, id)
+	if err != nil || obj["title"] != "Hi" || obj["text"] != "" {
+		t.Fatalf("expected restored object, found %d %v %v", status, obj, err)
+	}
+	if deleted, err := restored.Delete(owner, "notes", id, true); !deleted || err != nil {
+		t.Errorf("expected deletion, found %v %v", deleted, err)
+	}
+	if status, _, _ = restored.Load(owner, id); status != http.StatusNotFound {
+		t.Errorf("expected 404, found %d", status)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected no temporary file, found %v", err)
+	}
+}
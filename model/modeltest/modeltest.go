@@ -0,0 +1,357 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package modeltest checks that implementations of model.Connection behave
+// like the connections of brot. A backend proves conformance with
+//
+//	func TestConformance(t *testing.T) {
+//		modeltest.Run(t, func(t *testing.T) model.Connection {
+//			return newEmptyConnection(t)
+//		})
+//	}
+package modeltest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fuxsig/brot/model"
+)
+
+// NewConnection returns a connection without objects. It is called once
+// per test, connections needing cleanup register it with t.Cleanup.
+type NewConnection func(t *testing.T) model.Connection
+
+// Schemas returns the schemas used by the suite.
+func Schemas() []*model.Schema {
+	return []*model.Schema{
+		{Name: "Author", Elements: []*model.Element{
+			{Name: "name", Type: "string", Index: true},
+		}},
+		{Name: "Post", Elements: []*model.Element{
+			{Name: "title", Type: "string", Index: true},
+			{Name: "views", Type: "int", Index: true, Sort: true},
+			{Name: "tags", Type: "[]string"},
+			{Name: "author", Type: "*Author"},
+			{Name: "slug", Type: "string", Unique: true},
+			{Name: "number", Type: "int", Unique: true},
+		}},
+	}
+}
+
+// Run runs the conformance suite against the connections of newConn.
+func Run(t *testing.T, newConn NewConnection) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s *suite)
+	}{
+		{"IDs", testIDs},
+		{"Stamps", testStamps},
+		{"PartialUpdate", testPartialUpdate},
+		{"SchemaMismatch", testSchemaMismatch},
+		{"Access", testAccess},
+		{"Delete", testDelete},
+		{"Search", testSearch},
+		{"Cancelled", testCancelled},
+		{"Validation", testValidation},
+		{"References", testReferences},
+		{"Unique", testUnique},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			conn := newConn(t)
+			butter := &model.Butter{Conn: conn, ConfiguredSchemas: Schemas()}
+			if err := butter.InitFunc(); err != nil {
+				t.Fatal(err)
+			}
+			test.test(t, &suite{conn: conn, butter: butter, post: butter.Schema("Post")})
+		})
+	}
+}
+
+type suite struct {
+	conn   model.Connection
+	butter *model.Butter
+	post   *model.Schema
+}
+
+// save saves a post with values given as name, value pairs and returns
+// its id.
+func (s *suite) save(t *testing.T, ctx *model.Context, values ...string) string {
+	t.Helper()
+	data := map[string]string{"_schema": "Post"}
+	for i := 0; i+1 < len(values); i += 2 {
+		data[values[i]] = values[i+1]
+	}
+	if err := s.conn.Save(ctx, data, s.post); err != nil {
+		t.Fatalf("could not save %v: %s", data, err)
+	}
+	return data["_id"]
+}
+
+func (s *suite) load(t *testing.T, ctx *model.Context, id string) map[string]string {
+	t.Helper()
+	status, obj, err := s.conn.Load(ctx, id)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("could not load %s: %d %v", id, status, err)
+	}
+	return obj
+}
+
+func testIDs(t *testing.T, s *suite) {
+	ids := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		id := s.save(t, nil, "title", "Post "+strconv.Itoa(i), "views", strconv.Itoa(i), "tags", "go,web")
+		if id == "" || ids[id] {
+			t.Fatalf("expected new id, found %q", id)
+		}
+		ids[id] = true
+		obj := s.load(t, nil, id)
+		expected := map[string]string{"_id": id, "_schema": "Post", "title": "Post " + strconv.Itoa(i), "views": strconv.Itoa(i), "tags": "go,web"}
+		for key, value := range expected {
+			if obj[key] != value {
+				t.Errorf("expected %s %q, found %q", key, value, obj[key])
+			}
+		}
+	}
+	if status, _, err := s.conn.Load(nil, "unknown"); err == nil || status != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown id, found %d %v", status, err)
+	}
+}
+
+func testStamps(t *testing.T, s *suite) {
+	id := s.save(t, nil, "title", "Stamped")
+	obj := s.load(t, nil, id)
+	created, err := strconv.ParseInt(obj["_created"], 10, 64)
+	if err != nil || created <= 0 {
+		t.Fatalf("expected _created, found %q", obj["_created"])
+	}
+	if obj["_modified"] != obj["_created"] {
+		t.Errorf("expected _modified %s, found %q", obj["_created"], obj["_modified"])
+	}
+	s.save(t, nil, "_id", id, "title", "Changed")
+	obj = s.load(t, nil, id)
+	if obj["_created"] != strconv.FormatInt(created, 10) {
+		t.Errorf("expected unchanged _created %d, found %q", created, obj["_created"])
+	}
+	if modified, err := strconv.ParseInt(obj["_modified"], 10, 64); err != nil || modified < created {
+		t.Errorf("expected _modified not before %d, found %q", created, obj["_modified"])
+	}
+	for _, key := range []string{"_read", "_write", "_delete"} {
+		if obj[key] != "all" {
+			t.Errorf("expected default %s all, found %q", key, obj[key])
+		}
+	}
+}
+
+func testPartialUpdate(t *testing.T, s *suite) {
+	id := s.save(t, nil, "title", "Original", "views", "7", "tags", "a,b")
+	s.save(t, nil, "_id", id, "title", "Updated", "tags", "")
+	obj := s.load(t, nil, id)
+	if obj["title"] != "Updated" || obj["views"] != "7" || obj["tags"] != "" {
+		t.Errorf("expected updated title, kept views and removed tags, found %v", obj)
+	}
+}
+
+func testSchemaMismatch(t *testing.T, s *suite) {
+	id := s.save(t, nil, "title", "Post")
+	err := s.conn.Save(nil, map[string]string{"_id": id, "_schema": "Author", "name": "Ada"}, s.butter.Schema("Author"))
+	if err == nil {
+		t.Error("expected error for a different schema")
+	}
+	if obj := s.load(t, nil, id); obj["_schema"] != "Post" || obj["name"] != "" {
+		t.Errorf("expected unchanged post, found %v", obj)
+	}
+}
+
+func testAccess(t *testing.T, s *suite) {
+	staff := model.NewContext("sam", "all", "staff")
+	editor := model.NewContext("eve", "all", "staff", "editors")
+	admin := model.NewContext("ada", "all", "admins")
+	id := s.save(t, editor, "title", "Internal", "_read", "staff", "_write", "editors", "_delete", "admins")
+
+	if status, _, err := s.conn.Load(nil, id); err == nil || status != http.StatusForbidden {
+		t.Errorf("expected 403 for anonymous, found %d %v", status, err)
+	}
+	s.load(t, staff, id)
+	if err := s.conn.Save(staff, map[string]string{"_id": id, "_schema": "Post", "title": "Changed"}, s.post); !errors.Is(err, model.ErrAccessDenied) {
+		t.Errorf("expected access denied for staff, found %v", err)
+	}
+	s.save(t, editor, "_id", id, "title", "Edited")
+	if obj := s.load(t, staff, id); obj["title"] != "Edited" {
+		t.Errorf("expected edited title, found %q", obj["title"])
+	}
+	if _, total, err := s.conn.Search(nil, s.post.Plural, "", "", 0, 10); err != nil || total != 0 {
+		t.Errorf("expected no readable posts for anonymous, found %d %v", total, err)
+	}
+	if _, total, err := s.conn.Search(staff, s.post.Plural, "", "", 0, 10); err != nil || total != 1 {
+		t.Errorf("expected one readable post for staff, found %d %v", total, err)
+	}
+	if _, err := s.conn.Delete(editor, s.post.Plural, id, true); !errors.Is(err, model.ErrAccessDenied) {
+		t.Errorf("expected access denied for editor, found %v", err)
+	}
+	if deleted, err := s.conn.Delete(admin, s.post.Plural, id, true); !deleted || err != nil {
+		t.Errorf("expected deletion by admin, found %v %v", deleted, err)
+	}
+}
+
+func testDelete(t *testing.T, s *suite) {
+	id := s.save(t, nil, "title", "Short lived", "tags", "x")
+	if deleted, err := s.conn.Delete(nil, s.post.Plural, id, true); !deleted || err != nil {
+		t.Fatalf("expected deletion, found %v %v", deleted, err)
+	}
+	if status, _, err := s.conn.Load(nil, id); err == nil || status != http.StatusNotFound {
+		t.Errorf("expected 404 after deletion, found %d %v", status, err)
+	}
+	if deleted, err := s.conn.Delete(nil, s.post.Plural, id, true); deleted || err != nil {
+		t.Errorf("expected nothing to delete, found %v %v", deleted, err)
+	}
+	if status, err := s.butter.Delete(nil, id); status != http.StatusNotFound || err == nil {
+		t.Errorf("expected 404 from Butter.Delete, found %d %v", status, err)
+	}
+}
+
+func testSearch(t *testing.T, s *suite) {
+	for _, views := range []string{"3", "1", "4", "2", "5"} {
+		s.save(t, nil, "title", "Post "+views, "views", views)
+	}
+	tables := []struct {
+		query, sort string
+		offset, num int
+		expected    string
+	}{
+		{"", "views", 0, 10, "1,2,3,4,5"},
+		{"", "+views", 1, 2, "2,3"},
+		{"", "-views", 0, 3, "5,4,3"},
+		{"", "-views", 5, 3, ""},
+		{"", "views", -1, 2, "1,2"},
+		{"@views:[2 4]", "views", 0, 10, "2,3,4"},
+		{"@views:[2 4]", "-views", 1, 1, "3"},
+		{"@views:[2 4]", "views", -2, 1, "2"},
+	}
+	for _, table := range tables {
+		result, total, err := s.conn.Search(nil, s.post.Plural, table.query, table.sort, table.offset, table.num)
+		if err != nil {
+			t.Errorf("%q sorted by %s: %s", table.query, table.sort, err)
+			continue
+		}
+		views := make([]string, len(result))
+		for i, obj := range result {
+			views[i] = obj["views"]
+		}
+		expectedTotal := 5
+		if table.query != "" {
+			expectedTotal = 3
+		}
+		if found := strings.Join(views, ","); found != table.expected || total != expectedTotal {
+			t.Errorf("%q sorted by %s from %d: expected %s of %d, found %s of %d",
+				table.query, table.sort, table.offset, table.expected, expectedTotal, found, total)
+		}
+	}
+	if result, _, err := s.butter.Search(nil, "Post", "", "-views", 0, 1); err != nil || len(result) != 1 || result[0]["views"] != "5" {
+		t.Errorf("expected Butter.Search by schema name, found %v %v", result, err)
+	}
+}
+
+func testCancelled(t *testing.T, s *suite) {
+	id := s.save(t, nil, "title", "Post")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelled := model.Anonymous.WithContext(ctx)
+	if _, _, err := s.butter.Load(cancelled, id); err == nil {
+		t.Error("expected error loading with cancelled context")
+	}
+	if err := s.butter.Save(cancelled, map[string]string{"_schema": "Post", "title": "New"}); err == nil {
+		t.Error("expected error saving with cancelled context")
+	}
+	if _, _, err := s.butter.Search(cancelled, "Post", "", "", 0, 10); err == nil {
+		t.Error("expected error searching with cancelled context")
+	}
+}
+
+func testValidation(t *testing.T, s *suite) {
+	tables := []struct {
+		data map[string]string
+		ok   bool
+	}{
+		{map[string]string{"_schema": "Post", "title": "Valid", "views": "3", "tags": "a,b"}, true},
+		{map[string]string{"_schema": "Post", "views": ""}, true},
+		{map[string]string{"_schema": "Post", "views": "three"}, false},
+		{map[string]string{"_schema": "Post", "unknown": "x"}, false},
+		{map[string]string{"_schema": "Movie", "title": "x"}, false},
+	}
+	for _, table := range tables {
+		if err := s.butter.Save(nil, table.data); (err == nil) != table.ok {
+			t.Errorf("%v: expected ok %v, found %v", table.data, table.ok, err)
+		}
+	}
+}
+
+func testReferences(t *testing.T, s *suite) {
+	author := map[string]string{"_schema": "Author", "name": "Ann"}
+	if err := s.butter.Save(nil, author); err != nil {
+		t.Fatal(err)
+	}
+	post := s.save(t, nil, "title", "Post")
+	tables := []struct {
+		author string
+		ok     bool
+	}{
+		{author["_id"], true},
+		{"brot:missing", false},
+		{post, false},
+	}
+	for _, table := range tables {
+		err := s.butter.Save(nil, map[string]string{"_schema": "Post", "author": table.author})
+		var ve *model.ValidationError
+		if table.ok && err != nil || !table.ok && (!errors.As(err, &ve) || ve.Fields[0].Code != model.ReferenceViolation) {
+			t.Errorf("author %s: expected ok %v, found %v", table.author, table.ok, err)
+		}
+	}
+}
+
+func testUnique(t *testing.T, s *suite) {
+	first := map[string]string{"_schema": "Post", "slug": "hello-world"}
+	if err := s.butter.Save(nil, first); err != nil {
+		t.Fatal(err)
+	}
+	err := s.butter.Save(nil, map[string]string{"_schema": "Post", "slug": "hello-world"})
+	var ve *model.ValidationError
+	if !errors.As(err, &ve) || ve.Fields[0].Code != model.UniqueViolation {
+		t.Errorf("expected unique violation, found %v", err)
+	}
+	// saving the object itself does not violate
+	if err = s.butter.Save(nil, map[string]string{"_schema": "Post", "_id": first["_id"], "slug": "hello-world"}); err != nil {
+		t.Error(err)
+	}
+	if err = s.butter.Save(nil, map[string]string{"_schema": "Post", "slug": "hello"}); err != nil {
+		t.Error(err)
+	}
+	// values of objects hidden from the user, with quotes or beyond the
+	// first hits of a search are taken as well
+	for i := 0; i < 120; i++ {
+		s.save(t, nil, "slug", "page-"+strconv.Itoa(i))
+	}
+	s.save(t, nil, "slug", "page")
+	s.save(t, nil, "slug", "secret", "_read", "staff")
+	s.save(t, nil, "slug", `say "hi\"`)
+	for _, slug := range []string{"page", "secret", `say "hi\"`} {
+		err = s.butter.Save(nil, map[string]string{"_schema": "Post", "slug": slug})
+		if !errors.As(err, &ve) || ve.Fields[0].Code != model.UniqueViolation {
+			t.Errorf("expected unique violation of %s, found %v", slug, err)
+		}
+	}
+	// numbers are searched by their range
+	s.save(t, nil, "number", "7")
+	s.save(t, nil, "number", "70")
+	err = s.butter.Save(nil, map[string]string{"_schema": "Post", "number": "7"})
+	if !errors.As(err, &ve) || ve.Fields[0].Code != model.UniqueViolation {
+		t.Errorf("expected unique violation of 7, found %v", err)
+	}
+}
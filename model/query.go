@@ -0,0 +1,168 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// queryTerm is a term of a query, see Memory for the syntax.
+type queryTerm struct {
+	field  string
+	negate bool
+	value  string
+	match  func(value string) bool
+}
+
+func matchesAll(terms []queryTerm, data map[string]string) bool {
+	for _, term := range terms {
+		matched := false
+		for key, value := range data {
+			if term.field == "" && strings.HasPrefix(key, "_") && key != "_label" && key != "_content" {
+				continue
+			}
+			if term.field != "" && !strings.EqualFold(term.field, key) {
+				continue
+			}
+			if term.match(value) {
+				matched = true
+				break
+			}
+		}
+		if matched == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// parseQuery splits query into terms.
+func parseQuery(query string) (terms []queryTerm, err error) {
+	query = strings.TrimSpace(query)
+	if query == "*" {
+		return nil, nil
+	}
+	for len(query) > 0 {
+		var term queryTerm
+		if query[0] == '-' {
+			term.negate = true
+			query = query[1:]
+		}
+		if strings.HasPrefix(query, "@") {
+			i := strings.IndexByte(query, ':')
+			if i < 2 {
+				return nil, fmt.Errorf("invalid field in query at %s", query)
+			}
+			term.field = query[1:i]
+			query = query[i+1:]
+		}
+		var value string
+		if value, query, err = nextQueryValue(query); err != nil {
+			return
+		}
+		term.value = value
+		if term.match, err = queryMatcher(value); err != nil {
+			return
+		}
+		terms = append(terms, term)
+		query = strings.TrimSpace(query)
+	}
+	return
+}
+
+// nextQueryValue returns the value at the start of query and the rest.
+func nextQueryValue(query string) (value, rest string, err error) {
+	end := map[byte]byte{'"': '"', '{': '}', '[': ']'}
+	if len(query) > 0 {
+		if closing, ok := end[query[0]]; ok {
+			i := strings.IndexByte(query[1:], closing)
+			if i < 0 {
+				return "", "", fmt.Errorf("missing %c in query", closing)
+			}
+			return query[:i+2], query[i+2:], nil
+		}
+	}
+	i := strings.IndexFunc(query, unicode.IsSpace)
+	if i < 0 {
+		i = len(query)
+	}
+	if i == 0 {
+		return "", "", fmt.Errorf("missing value in query")
+	}
+	return query[:i], query[i:], nil
+}
+
+// queryMatcher returns the func matching values against the query value.
+func queryMatcher(value string) (func(string) bool, error) {
+	switch value[0] {
+	case '"':
+		phrase := strings.ToLower(value[1 : len(value)-1])
+		return func(v string) bool { return strings.Contains(strings.ToLower(v), phrase) }, nil
+	case '{':
+		tags := make(map[string]bool)
+		for _, tag := range strings.Split(value[1:len(value)-1], "|") {
+			tags[strings.ToLower(strings.TrimSpace(tag))] = true
+		}
+		return func(v string) bool {
+			for _, current := range strings.Split(v, ",") {
+				if tags[strings.ToLower(strings.TrimSpace(current))] {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case '[':
+		bounds := strings.Fields(value[1 : len(value)-1])
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid range %s in query", value)
+		}
+		min, minExcl, err := parseBound(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		max, maxExcl, err := parseBound(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		return func(v string) bool {
+			f, err := strconv.ParseFloat(v, 64)
+			return err == nil && (f > min || !minExcl && f == min) && (f < max || !maxExcl && f == max)
+		}, nil
+	}
+	word := strings.ToLower(value)
+	prefix := strings.HasSuffix(word, "*")
+	word = strings.TrimSuffix(word, "*")
+	return func(v string) bool {
+		for _, current := range strings.FieldsFunc(strings.ToLower(v), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if current == word || prefix && strings.HasPrefix(current, word) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// parseBound parses a bound of a numeric range like 5, (5, -inf or +inf.
+func parseBound(bound string) (value float64, exclusive bool, err error) {
+	if exclusive = strings.HasPrefix(bound, "("); exclusive {
+		bound = bound[1:]
+	}
+	switch strings.ToLower(bound) {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+	if value, err = strconv.ParseFloat(bound, 64); err != nil {
+		err = fmt.Errorf("invalid bound %s in query", bound)
+	}
+	return
+}
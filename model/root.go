@@ -5,7 +5,7 @@
 package model
 
 import (
-	"bytes"
+	"encoding/json"
 	"strconv"
 )
 
@@ -31,42 +31,30 @@ func (v *Vanilla) String(name string) string {
 	return v.Data[name]
 }
 
+// Decode sets the struct target points to the values of the object, see
+// Schema.Decode.
+func (v *Vanilla) Decode(target interface{}) error {
+	return v.Schema.Decode(v.Data, target)
+}
+
+// Json returns the JSON of the object, see Schema.MarshalObject.
 func (v *Vanilla) Json() []byte {
-	var b bytes.Buffer
-	b.WriteString("{")
-	i := len(v.Data)
-	for key, value := range v.Data {
-		b.WriteString(`"`)
-		b.WriteString(key)
-		b.WriteString(`":"`)
-		b.WriteString(value)
-		i--
-		if i > 0 {
-			b.WriteString(`",`)
-		} else {
-			b.WriteString(`"`)
-		}
-	}
-	b.WriteString("}")
-	return b.Bytes()
+	// values of a map cannot fail to marshal
+	b, _ := v.Schema.MarshalObject(v.Data)
+	return b
 }
 
+// JsonPartial returns the JSON of the given fields of the object.
 func (v *Vanilla) JsonPartial(fields ...string) []byte {
-	var b bytes.Buffer
-	b.WriteString("{")
-	i := len(fields)
-	for _, field := range fields {
-		b.WriteString(`"`)
-		b.WriteString(field)
-		b.WriteString(`":"`)
-		b.WriteString(v.Data[field])
-		i--
-		if i > 0 {
-			b.WriteString(`",`)
-		} else {
-			b.WriteString(`"`)
-		}
+	if len(fields) == 0 {
+		return []byte("{}")
 	}
-	b.WriteString("}")
-	return b.Bytes()
+	b, _ := v.Schema.MarshalObject(v.Data, fields...)
+	return b
 }
+
+func (v *Vanilla) MarshalJSON() ([]byte, error) {
+	return v.Schema.MarshalObject(v.Data)
+}
+
+var _ json.Marshaler = (*Vanilla)(nil)
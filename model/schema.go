@@ -5,18 +5,30 @@
 package model
 
 import (
+	"bytes"
 	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
+	"text/template"
 )
 
-// Schema describes the structure of an object.
+// Schema describes the structure of an object. Computed maps _label or an
+// element to a text/template, which derives the value from the other
+// values of the object on every save, e.g. {{.first}} {{.last}}. Values are
+// computed in the order of the elements, _label last.
 type Schema struct {
-	Name     string     `brot:"name"`
-	Plural   string     `brot:"plural"`
-	Elements []*Element `brot:"elements"`
-	elements map[string]*Element
-	butter   *Butter
-	Indexed  []string
+	Name          string            `brot:"name"`
+	Plural        string            `brot:"plural"`
+	Elements      []*Element        `brot:"elements"`
+	Computed      map[string]string `brot:"computed"`
+	elements      map[string]*Element
+	computed      map[string]*template.Template
+	computedOrder []string
+	binding       *binding
+	butter        *Butter
+	Indexed       []string
 }
 
 func (s *Schema) initialize(butter *Butter) error {
@@ -37,6 +49,11 @@ func (s *Schema) initialize(butter *Butter) error {
 		if err != nil {
 			return err
 		}
+		if element.Default != "" {
+			if err = s.checkDefault(element); err != nil {
+				return err
+			}
+		}
 		s.elements[element.Name] = element
 		if element.Index {
 			indexed = append(indexed, element.Name)
@@ -44,37 +61,246 @@ func (s *Schema) initialize(butter *Butter) error {
 	}
 	s.Indexed = make([]string, len(indexed))
 	copy(s.Indexed, indexed)
+
+	// parse the templates of computed values
+	s.computed = make(map[string]*template.Template, len(s.Computed))
+	s.computedOrder = nil
+	for name, text := range s.Computed {
+		if name != "_label" && s.elements[name] == nil {
+			return fmt.Errorf("computed value %s is not an element", name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return fmt.Errorf("computed value %s: %s", name, err.Error())
+		}
+		s.computed[name] = tmpl
+	}
+	for _, element := range s.Elements {
+		if s.computed[element.Name] != nil {
+			s.computedOrder = append(s.computedOrder, element.Name)
+		}
+	}
+	if s.computed["_label"] != nil {
+		s.computedOrder = append(s.computedOrder, "_label")
+	}
 	return nil
 }
 
+// Element returns the element name or nil.
+func (s *Schema) Element(name string) *Element {
+	return s.elements[name]
+}
+
 // CheckValue accepts a name value pair and checks the validity of the value.
-func (s *Schema) CheckValue(name, value string) (err error) {
+// An empty value unsets the element and is only invalid for required
+// elements. All violations are returned as *ValidationError.
+func (s *Schema) CheckValue(name, value string) error {
+	var ve ValidationError
+	s.checkValue(&ve, name, value)
+	return ve.errorOrNil()
+}
+
+// checkDefault checks the default value of e.
+func (s *Schema) checkDefault(e *Element) error {
+	var ve ValidationError
+	s.elements[e.Name] = e
+	s.checkValue(&ve, e.Name, e.Default)
+	if len(ve.Fields) > 0 {
+		return fmt.Errorf("Element %s has invalid default: %s", e.Name, ve.Fields[0].Message)
+	}
+	return nil
+}
+
+func (s *Schema) checkValue(ve *ValidationError, name, value string) {
 	e := s.elements[name]
 	if e == nil {
 		switch name {
 		case "_schema":
 			if s.butter.schemas[value] == nil {
-				err = fmt.Errorf("schema value %s is not registered", value)
+				ve.Add(name, ReferenceViolation, "schema value %s is not registered", value)
 			}
 		case "_label", "_id":
 			if len(value) > 256 {
-				err = fmt.Errorf("%s has more than 256 bytes", name)
+				ve.Add(name, MaxViolation, "%s has more than 256 bytes", name)
 			}
+		case "_read", "_write", "_delete":
+			// comma separated groups
 		default:
-			err = fmt.Errorf("schema %s does not contain element with name %s", s.Name, name)
+			ve.Add(name, UnknownViolation, "schema %s does not contain element with name %s", s.Name, name)
 		}
 		return
 	}
+	if value == "" {
+		if e.Required {
+			ve.Add(name, RequiredViolation, "%s is required", name)
+		}
+		return
+	}
+	values := []string{value}
 	if e.slice {
-		var err error
-		values := strings.Split(value, ",")
-		for _, current := range values {
-			err = e.check(current)
-			if err != nil {
+		values = strings.Split(value, ",")
+	}
+	seen := make(map[string]bool)
+	for _, current := range values {
+		if code, message := e.check(current); code != "" && !seen[message] {
+			seen[message] = true
+			ve.Add(name, code, "%s", message)
+		}
+	}
+}
+
+// Check checks all values of the object data with CheckValue. New objects
+// must contain all required elements, immutable elements of stored objects
+// must not change. The objects referenced by pointers must exist and belong
+// to the target schema, the values of unique elements must not be used by
+// another object readable by ctx. Violations are returned as
+// *ValidationError, errors of the connection as they are.
+func (s *Schema) Check(ctx *Context, data map[string]string) error {
+	current, err := s.current(ctx, data["_id"])
+	if err != nil {
+		return err
+	}
+	return s.check(ctx, data, current)
+}
+
+// current returns the stored object id, nil for new objects.
+func (s *Schema) current(ctx *Context, id string) (map[string]string, error) {
+	if id == "" {
+		return nil, nil
+	}
+	switch status, obj, err := s.butter.Conn.Load(ctx, id); {
+	case status == http.StatusNotFound:
+		// the object is created with the given id
+		return nil, nil
+	case status == http.StatusForbidden:
+		return nil, ErrAccessDenied
+	case err != nil:
+		return nil, err
+	case obj["_schema"] != s.Name:
+		return nil, fmt.Errorf("wrong schema %s, expected %s", s.Name, obj["_schema"])
+	default:
+		return obj, nil
+	}
+}
+
+func (s *Schema) check(ctx *Context, data, current map[string]string) error {
+	var ve ValidationError
+	for key, value := range data {
+		s.checkValue(&ve, key, value)
+	}
+	for _, e := range s.Elements {
+		value, ok := data[e.Name]
+		switch {
+		case current == nil && !ok && e.Required:
+			ve.Add(e.Name, RequiredViolation, "%s is required", e.Name)
+		case current != nil && ok && e.Immutable && value != current[e.Name]:
+			ve.Add(e.Name, ImmutableViolation, "%s cannot be changed", e.Name)
+		}
+	}
+	invalid := make(map[string]bool, len(ve.Fields))
+	for _, field := range ve.Fields {
+		invalid[field.Field] = true
+	}
+	for _, e := range s.Elements {
+		value := data[e.Name]
+		if value == "" || invalid[e.Name] || current != nil && value == current[e.Name] {
+			continue
+		}
+		if e.pointer {
+			for _, id := range strings.Split(value, ",") {
+				if err := s.checkReference(ctx, &ve, e, id); err != nil {
+					return err
+				}
+			}
+		}
+		if e.Unique {
+			if err := s.checkUnique(ctx, &ve, e, data["_id"], value); err != nil {
 				return err
 			}
 		}
+	}
+	return ve.errorOrNil()
+}
+
+// complete sets the defaults of a new object and the computed values, which
+// are derived from the values of data over those of current.
+func (s *Schema) complete(data, current map[string]string) error {
+	if current == nil {
+		for _, e := range s.Elements {
+			if data[e.Name] == "" && e.Default != "" {
+				data[e.Name] = e.Default
+			}
+		}
+	}
+	if len(s.computed) == 0 {
 		return nil
 	}
-	return e.check(value)
+	merged := make(map[string]string, len(current)+len(data))
+	for key, value := range current {
+		merged[key] = value
+	}
+	for key, value := range data {
+		merged[key] = value
+	}
+	for _, name := range s.computedOrder {
+		var buf bytes.Buffer
+		if err := s.computed[name].Execute(&buf, merged); err != nil {
+			return fmt.Errorf("could not compute %s: %s", name, err.Error())
+		}
+		data[name] = strings.TrimSpace(buf.String())
+		merged[name] = data[name]
+	}
+	return nil
+}
+
+// checkReference adds a violation if the object id does not exist or is of
+// another schema than the target of e. Objects the user may not read exist.
+func (s *Schema) checkReference(ctx *Context, ve *ValidationError, e *Element, id string) error {
+	status, obj, err := s.butter.Conn.Load(ctx, id)
+	switch {
+	case status == http.StatusNotFound:
+		ve.Add(e.Name, ReferenceViolation, "%s references unknown object %s", e.Name, id)
+	case status == http.StatusForbidden:
+	case err != nil:
+		return err
+	case obj["_schema"] != e.target:
+		ve.Add(e.Name, ReferenceViolation, "%s must reference an object of %s", e.Name, e.target)
+	}
+	return nil
+}
+
+// checkUnique adds a violation if another object than id has value. All
+// objects are searched with a privileged context and the values are
+// compared exactly. The search is narrowed by a phrase of the longest part
+// of a string value without quotes and backslashes, or by the range of a
+// number.
+func (s *Schema) checkUnique(ctx *Context, ve *ValidationError, e *Element, id, value string) error {
+	phrase := ""
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == '"' || r == '\\' }) {
+		if len(part) > len(phrase) {
+			phrase = part
+		}
+	}
+	query := "*"
+	switch e.Kind() {
+	case reflect.String:
+		if strings.TrimSpace(phrase) != "" {
+			query = "@" + e.Name + `:"` + phrase + `"`
+		}
+	case reflect.Int, reflect.Float64:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			query = "@" + e.Name + ":[" + value + " " + value + "]"
+		}
+	}
+	objects, _, err := s.butter.Conn.Search(ctx.asPrivileged(), s.Plural, query, "", 0, -1)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if obj[e.Name] == value && obj["_id"] != id {
+			ve.Add(e.Name, UniqueViolation, "%s %s is already used", e.Name, value)
+			break
+		}
+	}
+	return nil
 }
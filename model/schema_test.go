@@ -0,0 +1,185 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSchemaCheckValue(t *testing.T) {
+	zero, ten := 0.0, 10.0
+	schema := &Schema{Name: "Event", Elements: []*Element{
+		{Name: "title", Type: "string", Required: true, MinLength: 2, MaxLength: 5},
+		{Name: "code", Type: "string", Pattern: "^[A-Z]+$"},
+		{Name: "price", Type: "float", Min: &zero, Max: &ten},
+		{Name: "seats", Type: "[]int", Min: &zero},
+		{Name: "public", Type: "bool"},
+		{Name: "day", Type: "date"},
+		{Name: "start", Type: "datetime"},
+		{Name: "state", Type: "enum", Values: []string{"draft", "final"}},
+		{Name: "contact", Type: "[]email"},
+		{Name: "site", Type: "url"},
+		{Name: "notes", Type: "text"},
+		{Name: "meta", Type: "json"},
+	}}
+	butter := &Butter{Conn: &Memory{}, ConfiguredSchemas: []*Schema{schema}}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	tables := []struct {
+		name, value string
+		expected    string
+	}{
+		{"title", "Hello", ""},
+		{"title", "", "required"},
+		{"title", "H", "min"},
+		{"title", "Hello World", "max"},
+		{"code", "ABC", ""},
+		{"code", "abc", "pattern"},
+		{"price", "9.5", ""},
+		{"price", "-1", "min"},
+		{"price", "10.5", "max"},
+		{"price", "cheap", "type"},
+		{"seats", "1,2", ""},
+		{"seats", "1,-2,x", "min,type"},
+		{"public", "true", ""},
+		{"public", "yes", "type"},
+		{"day", "2018-02-28", ""},
+		{"day", "2018-02-30", "type"},
+		{"start", "2018-02-28T10:00:00Z", ""},
+		{"start", "2018-02-28T10:00", ""},
+		{"start", "tomorrow", "type"},
+		{"state", "final", ""},
+		{"state", "gone", "enum"},
+		{"contact", "ann@example.com,bob@example.com", ""},
+		{"contact", "ann@example.com,Bob <bob@example.com>", "type"},
+		{"site", "https://example.com/a", ""},
+		{"site", "example.com", "type"},
+		{"notes", "a,b\nc", ""},
+		{"meta", `{"a": [1, 2]}`, ""},
+		{"meta", `{"a": }`, "type"},
+		{"unknown", "x", "unknown"},
+	}
+	for _, table := range tables {
+		var codes []string
+		err := schema.CheckValue(table.name, table.value)
+		var ve *ValidationError
+		if errors.As(err, &ve) {
+			for _, field := range ve.Fields {
+				codes = append(codes, field.Code)
+			}
+		} else if err != nil {
+			t.Errorf("%s %q: unexpected error %v", table.name, table.value, err)
+		}
+		if found := strings.Join(codes, ","); found != table.expected {
+			t.Errorf("%s %q: expected %q, found %q", table.name, table.value, table.expected, found)
+		}
+	}
+}
+
+func TestSchemaInvalidDeclaration(t *testing.T) {
+	tables := [][]*Element{
+		{{Name: "a", Type: "decimal"}},
+		{{Name: "a", Type: "enum"}},
+		{{Name: "a", Type: "string", Values: []string{"x"}}},
+		{{Name: "a", Type: "[]text"}},
+		{{Name: "a", Type: "[]string", Unique: true}},
+		{{Name: "a", Type: "string", Pattern: "("}},
+	}
+	for _, elements := range tables {
+		butter := &Butter{Conn: &Memory{}, ConfiguredSchemas: []*Schema{{Name: "Thing", Elements: elements}}}
+		if err := butter.InitFunc(); err == nil {
+			t.Errorf("%s %s: expected error", elements[0].Type, elements[0].Pattern)
+		}
+	}
+}
+
+func TestSchemaCheckRequired(t *testing.T) {
+	schema := &Schema{Name: "Author", Elements: []*Element{
+		{Name: "name", Type: "string", Required: true},
+		{Name: "email", Type: "email", Required: true},
+		{Name: "bio", Type: "text"},
+	}}
+	butter := &Butter{Conn: &Memory{}, ConfiguredSchemas: []*Schema{schema}}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{"_schema": "Author", "bio": "x"}
+	var ve *ValidationError
+	if err := butter.Save(nil, data); !errors.As(err, &ve) || len(ve.Fields) != 2 ||
+		ve.Fields[0].Field != "email" || ve.Fields[1].Field != "name" || ve.Fields[0].Code != RequiredViolation {
+		t.Fatalf("expected email and name required, found %v", err)
+	}
+	data = map[string]string{"_schema": "Author", "name": "Ann", "email": "ann@example.com"}
+	if err := butter.Save(nil, data); err != nil {
+		t.Fatal(err)
+	}
+	// updates may omit required elements but not unset them
+	if err := butter.Save(nil, map[string]string{"_schema": "Author", "_id": data["_id"], "bio": "y"}); err != nil {
+		t.Error(err)
+	}
+	if err := butter.Save(nil, map[string]string{"_schema": "Author", "_id": data["_id"], "name": ""}); !errors.As(err, &ve) {
+		t.Errorf("expected required violation, found %v", err)
+	}
+}
+
+func TestSchemaCompleteAndImmutable(t *testing.T) {
+	schema := &Schema{
+		Name: "Person",
+		Elements: []*Element{
+			{Name: "first", Type: "string"},
+			{Name: "last", Type: "string", Required: true},
+			{Name: "state", Type: "enum", Values: []string{"new", "active"}, Default: "new"},
+			{Name: "login", Type: "string", Immutable: true},
+			{Name: "initials", Type: "string"},
+		},
+		Computed: map[string]string{
+			"initials": `{{slice .first 0 1}}{{slice .last 0 1}}`,
+			"_label":   `{{.last}}, {{.first}} ({{.initials}})`,
+		},
+	}
+	conn := &Memory{}
+	butter := &Butter{Conn: conn, ConfiguredSchemas: []*Schema{schema}}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{"_schema": "Person", "first": "Ada", "last": "Lovelace", "login": "ada", "initials": "XX"}
+	if err := butter.Save(nil, data); err != nil {
+		t.Fatal(err)
+	}
+	id := data["_id"]
+	_, obj, _ := conn.Load(nil, id)
+	if obj["state"] != "new" || obj["initials"] != "AL" || obj["_label"] != "Lovelace, Ada (AL)" {
+		t.Errorf("expected default and computed values, found %v", obj)
+	}
+	// computed values are derived from the stored values on updates
+	if err := butter.Save(nil, map[string]string{"_schema": "Person", "_id": id, "first": "Augusta", "login": "ada"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, obj, _ = conn.Load(nil, id); obj["_label"] != "Lovelace, Augusta (AL)" || obj["state"] != "new" {
+		t.Errorf("expected recomputed label, found %v", obj)
+	}
+	var ve *ValidationError
+	for _, login := range []string{"lovelace", ""} {
+		err := butter.Save(nil, map[string]string{"_schema": "Person", "_id": id, "login": login})
+		if !errors.As(err, &ve) || ve.Fields[0].Code != ImmutableViolation {
+			t.Errorf("login %q: expected immutable violation, found %v", login, err)
+		}
+	}
+
+	invalid := []*Schema{
+		{Name: "A", Elements: []*Element{{Name: "n", Type: "int", Default: "many"}}},
+		{Name: "B", Computed: map[string]string{"missing": "x"}},
+		{Name: "C", Computed: map[string]string{"_label": "{{.x"}},
+	}
+	for _, schema := range invalid {
+		butter := &Butter{Conn: &Memory{}, ConfiguredSchemas: []*Schema{schema}}
+		if err := butter.InitFunc(); err == nil {
+			t.Errorf("%s: expected error", schema.Name)
+		}
+	}
+}
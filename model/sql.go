@@ -0,0 +1,838 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fuxsig/brot/di"
+)
+
+// SQL is a Connection storing objects in a relational database opened with
+// the database/sql driver Driver, which the application has to import.
+// Dialect is sqlite or postgres and derived from well known driver names.
+//
+// Every schema is stored in the table named by its plural with one column
+// per element. Slices are stored in join tables named plural_element,
+// pointers reference the _id of their target table. BuildIndex creates
+// missing tables, adds columns of new elements and indexes the elements
+// with Index or Sort set, unique elements get a unique index. Columns of
+// removed elements are kept. Executed migrations are recorded in
+// brot_migrations. Postgres requires the target of a pointer to be
+// declared before the schema referencing it, otherwise the reference is not
+// enforced.
+//
+// Search supports the query syntax of Memory. Tags, ranges, words and
+// phrases are translated to conditions of the query, the found objects are
+// checked again by the terms which cannot be translated exactly, e.g. words
+// and phrases, which are found with LIKE. Searches translated exactly are
+// paginated by the database.
+type SQL struct {
+	Driver  string `brot:"driver,mandatory"`
+	DSN     string `brot:"dsn,mandatory"`
+	Dialect string `brot:"dialect"`
+	db      *sql.DB
+	dialect *sqlDialect
+	schemas map[string]*Schema
+}
+
+// sqlDialect covers the differences of the supported databases.
+type sqlDialect struct {
+	intType     string
+	floatType   string
+	placeholder func(n int) string
+}
+
+var sqlDialects = map[string]*sqlDialect{
+	"sqlite": {
+		intType:     "INTEGER",
+		floatType:   "REAL",
+		placeholder: func(int) string { return "?" },
+	},
+	"postgres": {
+		intType:     "BIGINT",
+		floatType:   "DOUBLE PRECISION",
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+	},
+}
+
+var sqlDrivers = map[string]string{
+	"sqlite":   "sqlite",
+	"sqlite3":  "sqlite",
+	"postgres": "postgres",
+	"pgx":      "postgres",
+}
+
+// sqlMeta are the columns of all tables besides the elements.
+var sqlMeta = []string{"_label", "_content", "_owner", "_read", "_write", "_delete", "_created", "_modified"}
+
+func (s *SQL) InitFunc() (err error) {
+	if s.Dialect == "" {
+		s.Dialect = sqlDrivers[s.Driver]
+	}
+	var ok bool
+	if s.dialect, ok = sqlDialects[s.Dialect]; !ok {
+		return fmt.Errorf("unknown SQL dialect %q of driver %s", s.Dialect, s.Driver)
+	}
+	s.schemas = make(map[string]*Schema)
+	if s.db, err = sql.Open(s.Driver, s.DSN); err != nil {
+		return
+	}
+	for _, statement := range []string{
+		`CREATE TABLE IF NOT EXISTS "brot_objects" ("_id" TEXT PRIMARY KEY, "_schema" TEXT NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS "brot_sequence" ("next" ` + s.dialect.intType + ` NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS "brot_migrations" ("statement" TEXT NOT NULL, "applied" ` + s.dialect.intType + ` NOT NULL)`,
+	} {
+		if _, err = s.db.Exec(statement); err != nil {
+			return
+		}
+	}
+	var rows int
+	if err = s.db.QueryRow(`SELECT COUNT(*) FROM "brot_sequence"`).Scan(&rows); err == nil && rows == 0 {
+		_, err = s.db.Exec(`INSERT INTO "brot_sequence" ("next") VALUES (0)`)
+	}
+	return
+}
+
+func (s *SQL) Retry() bool {
+	return false
+}
+
+// bind replaces the ? of query by the placeholders of the dialect.
+func (s *SQL) bind(query string) string {
+	if s.dialect.placeholder(1) == "?" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(s.dialect.placeholder(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (s *SQL) table(schema *Schema) string {
+	return strings.ToLower(schema.Plural)
+}
+
+func (s *SQL) joinTable(schema *Schema, e *Element) string {
+	return s.table(schema) + "_" + strings.ToLower(e.Name)
+}
+
+// columnType returns the type of a single value of e.
+func (s *SQL) columnType(schema *Schema, e *Element) string {
+	switch {
+	case e.Pointer():
+		target := s.schemas[strings.ToLower(e.Target())]
+		if e.Target() == schema.Name {
+			target = schema
+		}
+		if target == nil {
+			return "TEXT"
+		}
+		onDelete := "SET NULL"
+		if e.Slice() {
+			onDelete = "CASCADE"
+		}
+		return "TEXT REFERENCES " + quote(s.table(target)) + ` ("_id") ON DELETE ` + onDelete
+	case e.Kind() == reflect.Int:
+		return s.dialect.intType
+	case e.Kind() == reflect.Float64:
+		return s.dialect.floatType
+	}
+	return "TEXT"
+}
+
+func (s *SQL) metaType(column string) string {
+	switch column {
+	case "_created", "_modified":
+		return s.dialect.intType
+	case "_read", "_write", "_delete":
+		return "TEXT NOT NULL DEFAULT 'all'"
+	}
+	return "TEXT"
+}
+
+// schemaStatements returns the statements creating or migrating the tables
+// of schema. Existing are the columns of its table, nil if the table does
+// not exist. Migrations are the statements changing the database.
+func (s *SQL) schemaStatements(schema *Schema, existing map[string]bool) (statements []string, migrations int) {
+	table := quote(s.table(schema))
+	if existing == nil {
+		columns := []string{`"_id" TEXT PRIMARY KEY`, `"_seq" ` + s.dialect.intType + ` NOT NULL`}
+		for _, column := range sqlMeta {
+			columns = append(columns, quote(column)+" "+s.metaType(column))
+		}
+		for _, e := range schema.Elements {
+			if !e.Slice() {
+				columns = append(columns, quote(e.Name)+" "+s.columnType(schema, e))
+			}
+		}
+		statements = append(statements, "CREATE TABLE "+table+" ("+strings.Join(columns, ", ")+")")
+	} else {
+		for _, column := range sqlMeta {
+			if !existing[column] {
+				statements = append(statements, "ALTER TABLE "+table+" ADD COLUMN "+quote(column)+" "+s.metaType(column))
+			}
+		}
+		for _, e := range schema.Elements {
+			if !e.Slice() && !existing[e.Name] {
+				statements = append(statements, "ALTER TABLE "+table+" ADD COLUMN "+quote(e.Name)+" "+s.columnType(schema, e))
+			}
+		}
+	}
+	migrations = len(statements)
+	for _, e := range schema.Elements {
+		if e.Slice() {
+			statements = append(statements, "CREATE TABLE IF NOT EXISTS "+quote(s.joinTable(schema, e))+
+				` ("_owner" TEXT NOT NULL REFERENCES `+table+` ("_id") ON DELETE CASCADE, "_position" `+
+				s.dialect.intType+` NOT NULL, "value" `+s.columnType(schema, e)+`, PRIMARY KEY ("_owner", "_position"))`)
+		}
+	}
+	for _, e := range schema.Elements {
+		if e.Unique && !e.Slice() {
+			name := s.table(schema) + "_" + strings.ToLower(e.Name)
+			statements = append(statements, "CREATE UNIQUE INDEX IF NOT EXISTS "+quote(name+"_unique")+" ON "+table+" ("+quote(e.Name)+")")
+		}
+		if !e.Index && !e.Sort {
+			continue
+		}
+		if e.Slice() {
+			name := s.joinTable(schema, e)
+			statements = append(statements, "CREATE INDEX IF NOT EXISTS "+quote(name+"_idx")+" ON "+quote(name)+` ("value")`)
+		} else {
+			name := s.table(schema) + "_" + strings.ToLower(e.Name)
+			statements = append(statements, "CREATE INDEX IF NOT EXISTS "+quote(name+"_idx")+" ON "+table+" ("+quote(e.Name)+")")
+		}
+	}
+	return
+}
+
+// columns returns the columns of table, nil if it does not exist.
+func (s *SQL) columns(table string) map[string]bool {
+	rows, err := s.db.Query("SELECT * FROM " + quote(table) + " WHERE 1 = 0")
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil
+	}
+	result := make(map[string]bool, len(names))
+	for _, name := range names {
+		result[name] = true
+	}
+	return result
+}
+
+// BuildIndex creates or migrates the tables of schema. Every migration is
+// executed in a transaction together with its record, so that a failed
+// migration is neither applied nor recorded. Errors are returned, e.g. of
+// a unique index of an element with duplicate values.
+func (s *SQL) BuildIndex(schema *Schema) error {
+	l := slog.Default().With("component", "sql")
+	statements, migrations := s.schemaStatements(schema, s.columns(s.table(schema)))
+	for i, statement := range statements {
+		if i >= migrations {
+			if _, err := s.db.Exec(statement); err != nil {
+				return fmt.Errorf("could not execute %s: %w", statement, err)
+			}
+			continue
+		}
+		if err := s.migrate(statement); err != nil {
+			return fmt.Errorf("could not migrate %s: %w", statement, err)
+		}
+		l.Info("migrated schema", "schema", schema.Name, "statement", statement)
+	}
+	s.schemas[strings.ToLower(schema.Name)] = schema
+	s.schemas[strings.ToLower(schema.Plural)] = schema
+	return nil
+}
+
+// migrate executes statement and records it in brot_migrations.
+func (s *SQL) migrate(statement string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(statement); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(s.bind(`INSERT INTO "brot_migrations" ("statement", "applied") VALUES (?, ?)`), statement, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// querier is implemented by sql.DB and sql.Tx.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// schemaOf returns the schema of the object id, nil if it does not exist.
+func (s *SQL) schemaOf(ctx context.Context, q querier, id string) (*Schema, error) {
+	var name string
+	err := q.QueryRowContext(ctx, s.bind(`SELECT "_schema" FROM "brot_objects" WHERE "_id" = ?`), id).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if schema, ok := s.schemas[strings.ToLower(name)]; ok {
+		return schema, nil
+	}
+	return nil, fmt.Errorf("schema %s of %s is not registered", name, id)
+}
+
+// scan reads the objects of rows including their slices.
+func (s *SQL) scan(ctx context.Context, q querier, schema *Schema, rows *sql.Rows) ([]map[string]string, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		obj := map[string]string{"_schema": schema.Name}
+		for i, column := range columns {
+			if values[i].Valid && values[i].String != "" && column != "_seq" {
+				obj[column] = values[i].String
+			}
+		}
+		result = append(result, obj)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err = s.loadSlices(ctx, q, schema, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// sqlBatch limits the ids of a query, databases limit the number of
+// parameters.
+const sqlBatch = 500
+
+// loadSlices loads the slices of objects with one query per join table and
+// batch of objects.
+func (s *SQL) loadSlices(ctx context.Context, q querier, schema *Schema, objects []map[string]string) error {
+	byID := make(map[string]map[string]string, len(objects))
+	for _, obj := range objects {
+		byID[obj["_id"]] = obj
+	}
+	for _, e := range schema.Elements {
+		if !e.Slice() {
+			continue
+		}
+		for start := 0; start < len(objects); start += sqlBatch {
+			end := start + sqlBatch
+			if end > len(objects) {
+				end = len(objects)
+			}
+			args := make([]interface{}, 0, end-start)
+			for _, obj := range objects[start:end] {
+				args = append(args, obj["_id"])
+			}
+			rows, err := q.QueryContext(ctx, s.bind(`SELECT "_owner", "value" FROM `+quote(s.joinTable(schema, e))+
+				` WHERE "_owner" IN (`+strings.Repeat(", ?", len(args))[2:]+`) ORDER BY "_owner", "_position"`), args...)
+			if err != nil {
+				return err
+			}
+			values := make(map[string][]string)
+			for rows.Next() {
+				var owner string
+				var value sql.NullString
+				if err = rows.Scan(&owner, &value); err != nil {
+					rows.Close()
+					return err
+				}
+				if value.Valid {
+					values[owner] = append(values[owner], value.String)
+				}
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return err
+			}
+			for owner, current := range values {
+				byID[owner][e.Name] = strings.Join(current, ",")
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SQL) Load(ctx *Context, id string) (int, map[string]string, error) {
+	if ctx == nil {
+		ctx = Anonymous
+	}
+	c := ctx.Context()
+	schema, err := s.schemaOf(c, s.db, id)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	if schema == nil {
+		return http.StatusNotFound, nil, fmt.Errorf("object %s not found", id)
+	}
+	rows, err := s.db.QueryContext(c, s.bind(`SELECT * FROM `+quote(s.table(schema))+` WHERE "_id" = ?`), id)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	objects, err := s.scan(c, s.db, schema, rows)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	if len(objects) == 0 {
+		return http.StatusNotFound, nil, fmt.Errorf("object %s not found", id)
+	}
+	if !memberOf(ctx, objects[0]["_read"]) {
+		return http.StatusForbidden, nil, fmt.Errorf("forbidden")
+	}
+	return http.StatusOK, objects[0], nil
+}
+
+// Save creates the object or updates the given values, empty values are
+// removed.
+func (s *SQL) Save(ctx *Context, data map[string]string, schema *Schema) (err error) {
+	if ctx == nil {
+		ctx = Anonymous
+	}
+	c := ctx.Context()
+	var tx *sql.Tx
+	if tx, err = s.db.BeginTx(c, nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	table := quote(s.table(schema))
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	id := data["_id"]
+	var current *Schema
+	if id != "" {
+		if current, err = s.schemaOf(c, tx, id); err != nil {
+			return
+		}
+	}
+	if current != nil {
+		if current.Name != data["_schema"] {
+			return fmt.Errorf("wrong schema %s, expected %s", data["_schema"], current.Name)
+		}
+		var write string
+		if err = tx.QueryRowContext(c, s.bind(`SELECT "_write" FROM `+table+` WHERE "_id" = ?`), id).Scan(&write); err != nil {
+			return
+		}
+		if !memberOf(ctx, write) {
+			return ErrAccessDenied
+		}
+	} else {
+		var seq int64
+		if _, err = tx.ExecContext(c, `UPDATE "brot_sequence" SET "next" = "next" + 1`); err != nil {
+			return
+		}
+		if err = tx.QueryRowContext(c, `SELECT "next" FROM "brot_sequence"`).Scan(&seq); err != nil {
+			return
+		}
+		if id == "" {
+			id = "brot:" + strconv.FormatInt(seq, 36)
+			data["_id"] = id
+		}
+		for _, key := range []string{"_read", "_write", "_delete"} {
+			if data[key] == "" {
+				data[key] = "all"
+			}
+		}
+		data["_created"] = now
+		if _, err = tx.ExecContext(c, s.bind(`INSERT INTO "brot_objects" ("_id", "_schema") VALUES (?, ?)`), id, schema.Name); err != nil {
+			return
+		}
+		if _, err = tx.ExecContext(c, s.bind(`INSERT INTO `+table+` ("_id", "_seq") VALUES (?, ?)`), id, seq); err != nil {
+			return
+		}
+	}
+	data["_modified"] = now
+
+	var (
+		assignments []string
+		args        []interface{}
+	)
+	for key, value := range data {
+		var e *Element
+		switch key {
+		case "_id", "_schema":
+			continue
+		case "_label", "_content", "_owner", "_read", "_write", "_delete", "_created", "_modified":
+		default:
+			if e = schema.Element(key); e == nil {
+				return fmt.Errorf("schema %s does not contain element with name %s", schema.Name, key)
+			}
+		}
+		if e != nil && e.Slice() {
+			if err = s.saveSlice(c, tx, schema, e, id, value); err != nil {
+				return
+			}
+			continue
+		}
+		assignments = append(assignments, quote(key)+" = ?")
+		if value == "" {
+			args = append(args, nil)
+		} else {
+			args = append(args, value)
+		}
+	}
+	args = append(args, id)
+	_, err = tx.ExecContext(c, s.bind(`UPDATE `+table+` SET `+strings.Join(assignments, ", ")+` WHERE "_id" = ?`), args...)
+	return
+}
+
+// saveSlice replaces the values of the slice e of the object id.
+func (s *SQL) saveSlice(ctx context.Context, tx *sql.Tx, schema *Schema, e *Element, id, value string) (err error) {
+	table := quote(s.joinTable(schema, e))
+	if _, err = tx.ExecContext(ctx, s.bind(`DELETE FROM `+table+` WHERE "_owner" = ?`), id); err != nil || value == "" {
+		return
+	}
+	for i, current := range strings.Split(value, ",") {
+		if _, err = tx.ExecContext(ctx, s.bind(`INSERT INTO `+table+` ("_owner", "_position", "value") VALUES (?, ?, ?)`), id, i, current); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *SQL) Delete(ctx *Context, index, id string, document bool) (deleted bool, err error) {
+	if ctx == nil {
+		ctx = Anonymous
+	}
+	c := ctx.Context()
+	var tx *sql.Tx
+	if tx, err = s.db.BeginTx(c, nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil || !deleted {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	var schema *Schema
+	if schema, err = s.schemaOf(c, tx, id); err != nil || schema == nil {
+		return
+	}
+	table := quote(s.table(schema))
+	var groups string
+	if err = tx.QueryRowContext(c, s.bind(`SELECT "_delete" FROM `+table+` WHERE "_id" = ?`), id).Scan(&groups); err != nil {
+		return
+	}
+	if !memberOf(ctx, groups) {
+		return false, ErrAccessDenied
+	}
+	for _, e := range schema.Elements {
+		if e.Slice() {
+			if _, err = tx.ExecContext(c, s.bind(`DELETE FROM `+quote(s.joinTable(schema, e))+` WHERE "_owner" = ?`), id); err != nil {
+				return
+			}
+		}
+	}
+	for _, statement := range []string{`DELETE FROM ` + table + ` WHERE "_id" = ?`, `DELETE FROM "brot_objects" WHERE "_id" = ?`} {
+		if _, err = tx.ExecContext(c, s.bind(statement), id); err != nil {
+			return
+		}
+	}
+	return true, nil
+}
+
+// readable returns the condition restricting a search to the objects
+// readable by ctx.
+func readable(ctx *Context) (string, []interface{}) {
+	if ctx.Privileged() {
+		return "1 = 1", nil
+	}
+	groups := ctx.Groups()
+	if len(groups) == 0 {
+		return "1 = 0", nil
+	}
+	conditions := make([]string, len(groups))
+	args := make([]interface{}, len(groups))
+	for i, group := range groups {
+		conditions[i] = `(',' || "_read" || ',') LIKE ? ESCAPE '\'`
+		args[i] = "%," + likeEscaper.Replace(group) + ",%"
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// sqlColumn is a column searched by a query term. The values of a slice
+// are searched in its join table.
+type sqlColumn struct {
+	name    string
+	element *Element
+}
+
+// searchColumns returns the columns searched by a term of field. Ok is
+// false if field is not a column.
+func (s *SQL) searchColumns(schema *Schema, field string) (columns []sqlColumn, ok bool) {
+	if field == "" {
+		for _, e := range schema.Elements {
+			columns = append(columns, sqlColumn{e.Name, e})
+		}
+		return append(columns, sqlColumn{"_label", nil}, sqlColumn{"_content", nil}), true
+	}
+	for _, e := range schema.Elements {
+		if strings.EqualFold(e.Name, field) {
+			return []sqlColumn{{e.Name, e}}, true
+		}
+	}
+	for _, column := range append([]string{"_id"}, sqlMeta...) {
+		if strings.EqualFold(column, field) {
+			return []sqlColumn{{column, nil}}, true
+		}
+	}
+	return nil, false
+}
+
+// numeric reports whether the values of the column are numbers.
+func (c sqlColumn) numeric() bool {
+	if c.element == nil {
+		return c.name == "_created" || c.name == "_modified"
+	}
+	return !c.element.Pointer() && (c.element.Kind() == reflect.Int || c.element.Kind() == reflect.Float64)
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// valueCondition returns the condition of the query value on the value x of
+// column. It returns an empty condition if the value cannot be translated.
+// An inexact condition is met by more values than the term.
+func valueCondition(column sqlColumn, x, value string) (cond string, args []interface{}, exact bool) {
+	isASCII := true
+	for i := 0; i < len(value); i++ {
+		isASCII = isASCII && value[i] < 0x80
+	}
+	float := column.numeric() && column.element != nil && column.element.Kind() == reflect.Float64
+	switch value[0] {
+	case '[':
+		if !column.numeric() {
+			return "", nil, false
+		}
+		bounds := strings.Fields(value[1 : len(value)-1])
+		var conditions []string
+		for i, bound := range bounds {
+			f, exclusive, _ := parseBound(bound)
+			if math.IsInf(f, 0) {
+				continue
+			}
+			operator := map[bool]string{true: " >", false: " <"}[i == 0]
+			if !exclusive {
+				operator += "="
+			}
+			conditions = append(conditions, x+operator+" ?")
+			args = append(args, f)
+		}
+		if len(conditions) == 0 {
+			return x + " IS NOT NULL", nil, true
+		}
+		return "(" + strings.Join(conditions, " AND ") + ")", args, true
+	case '{':
+		// a float is formatted differently by the database
+		if !isASCII || float {
+			return "", nil, false
+		}
+		var placeholders []string
+		for _, tag := range strings.Split(value[1:len(value)-1], "|") {
+			placeholders = append(placeholders, "?")
+			args = append(args, strings.ToLower(strings.TrimSpace(tag)))
+		}
+		cond = "LOWER(TRIM(CAST(" + x + " AS TEXT))) IN (" + strings.Join(placeholders, ", ") + ")"
+		if column.element != nil && column.element.Slice() || column.numeric() {
+			return "(" + x + " IS NOT NULL AND " + cond + ")", args, true
+		}
+		// a text may hold comma separated tags
+		return "(" + x + " IS NOT NULL AND (" + cond + " OR " + x + " LIKE '%,%'))", args, false
+	}
+	if !isASCII || float {
+		return "", nil, false
+	}
+	text := strings.ToLower(strings.TrimSuffix(strings.Trim(value, `"`), "*"))
+	cond = "(" + x + " IS NOT NULL AND LOWER(CAST(" + x + " AS TEXT)) LIKE ? ESCAPE '\\')"
+	return cond, []interface{}{"%" + likeEscaper.Replace(text) + "%"}, false
+}
+
+// termCondition returns the condition of term on the objects of table. It
+// returns an empty condition if the term cannot be translated.
+func (s *SQL) termCondition(schema *Schema, table string, term queryTerm) (cond string, args []interface{}, exact bool) {
+	if strings.EqualFold(term.field, "_schema") {
+		// every object of the table has the schema
+		if term.match(schema.Name) != term.negate {
+			return "1 = 1", nil, true
+		}
+		return "1 = 0", nil, true
+	}
+	columns, ok := s.searchColumns(schema, term.field)
+	if !ok {
+		if term.negate {
+			return "1 = 1", nil, true
+		}
+		return "1 = 0", nil, true
+	}
+	exact = true
+	var conditions []string
+	for _, column := range columns {
+		x := table + "." + quote(column.name)
+		if column.element != nil && column.element.Slice() {
+			x = `"j"."value"`
+		}
+		current, currentArgs, currentExact := valueCondition(column, x, term.value)
+		if current == "" {
+			return "", nil, false
+		}
+		if column.element != nil && column.element.Slice() {
+			current = `EXISTS (SELECT 1 FROM ` + quote(s.joinTable(schema, column.element)) + ` AS "j" WHERE "j"."_owner" = ` +
+				table + `."_id" AND ` + current + ")"
+		}
+		conditions = append(conditions, current)
+		args = append(args, currentArgs...)
+		exact = exact && currentExact
+	}
+	cond = "(" + strings.Join(conditions, " OR ") + ")"
+	if term.negate {
+		if !exact {
+			return "", nil, false
+		}
+		cond = "NOT " + cond
+	}
+	return cond, args, exact
+}
+
+// Search returns the page of objects of the schema index matching query,
+// see Memory. A negative offset is treated as 0.
+func (s *SQL) Search(ctx *Context, index, query, sort string, offset, num int) ([]map[string]string, int, error) {
+	if ctx == nil {
+		ctx = Anonymous
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	c := ctx.Context()
+	terms, err := parseQuery(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	schema, ok := s.schemas[strings.ToLower(index)]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown index %s", index)
+	}
+	order := `"_seq"`
+	if by := strings.TrimLeft(sort, "+-"); by != "" {
+		if e := schema.Element(by); (e == nil || e.Slice()) && by != "_id" && by != "_label" && by != "_created" && by != "_modified" {
+			return nil, 0, fmt.Errorf("cannot sort by %s", by)
+		}
+		direction := " ASC"
+		if strings.HasPrefix(sort, "-") {
+			direction = " DESC"
+		}
+		// empty values first in ascending order like Memory
+		order = "(" + quote(by) + " IS NULL)" + map[string]string{" ASC": " DESC", " DESC": " ASC"}[direction] +
+			", " + quote(by) + direction + ", " + order
+	}
+	table := quote(s.table(schema))
+	where, args := readable(ctx)
+	// the terms which are not translated exactly are checked after loading
+	var checked []queryTerm
+	for _, term := range terms {
+		cond, condArgs, exact := s.termCondition(schema, table, term)
+		if cond != "" {
+			where += " AND " + cond
+			args = append(args, condArgs...)
+		}
+		if !exact {
+			checked = append(checked, term)
+		}
+	}
+	if len(checked) == 0 && num >= 0 {
+		var total int
+		if err = s.db.QueryRowContext(c, s.bind(`SELECT COUNT(*) FROM `+table+` WHERE `+where), args...).Scan(&total); err != nil {
+			return nil, 0, err
+		}
+		rows, err := s.db.QueryContext(c, s.bind(`SELECT * FROM `+table+` WHERE `+where+` ORDER BY `+order+` LIMIT ? OFFSET ?`),
+			append(args, num, offset)...)
+		if err != nil {
+			return nil, 0, err
+		}
+		result, err := s.scan(c, s.db, schema, rows)
+		return result, total, err
+	}
+	rows, err := s.db.QueryContext(c, s.bind(`SELECT * FROM `+table+` WHERE `+where+` ORDER BY `+order), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	objects, err := s.scan(c, s.db, schema, rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	var found []map[string]string
+	for _, obj := range objects {
+		if matchesAll(checked, obj) {
+			found = append(found, obj)
+		}
+	}
+	total := len(found)
+	if offset > total {
+		offset = total
+	}
+	if num >= 0 && offset+num < total {
+		return found[offset : offset+num], total, nil
+	}
+	return found[offset:], total, nil
+}
+
+// Close closes the database.
+func (s *SQL) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+var _ Connection = (*SQL)(nil)
+var _ di.ProvidesInit = (*SQL)(nil)
+var _ = di.GlobalScope.Declare((*SQL)(nil))
@@ -0,0 +1,165 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build sqlite
+
+package model_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fuxsig/brot/model"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newSQLite returns a connection to a new sqlite database.
+func newSQLite(t *testing.T) *model.SQL {
+	conn := &model.SQL{Driver: "sqlite3", DSN: filepath.Join(t.TempDir(), "brot.db") + "?_sync=OFF"}
+	if err := conn.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newButter returns a model of the posts of conn.
+func newButter(t *testing.T, conn model.Connection) *model.Butter {
+	butter := &model.Butter{Conn: conn, ConfiguredSchemas: []*model.Schema{{Name: "Post", Elements: []*model.Element{
+		{Name: "title", Type: "string"},
+		{Name: "views", Type: "int"},
+		{Name: "rating", Type: "float"},
+		{Name: "tags", Type: "[]string"},
+		{Name: "label", Type: "string"},
+	}}}}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	return butter
+}
+
+func TestSQLite(t *testing.T) {
+	butter := newButter(t, newSQLite(t))
+	post := map[string]string{"_schema": "Post", "title": "Hello", "views": "3", "tags": "go,web"}
+	if err := butter.Save(nil, post); err != nil {
+		t.Fatal(err)
+	}
+	if _, obj, err := butter.Load(nil, post["_id"]); err != nil || obj["title"] != "Hello" || obj["views"] != "3" || obj["tags"] != "go,web" {
+		t.Errorf("unexpected object %v %v", obj, err)
+	}
+	if err := butter.Save(nil, map[string]string{"_id": post["_id"], "_schema": "Post", "tags": "lisp", "views": ""}); err != nil {
+		t.Fatal(err)
+	}
+	if _, obj, err := butter.Load(nil, post["_id"]); err != nil || obj["title"] != "Hello" || obj["views"] != "" || obj["tags"] != "lisp" {
+		t.Errorf("unexpected updated object %v %v", obj, err)
+	}
+	if result, total, err := butter.Search(nil, "Post", "@tags:{lisp}", "", 0, 10); err != nil || total != 1 || len(result) != 1 {
+		t.Errorf("expected the post, found %v %d %v", result, total, err)
+	}
+	if status, err := butter.Delete(nil, post["_id"]); err != nil || status != http.StatusNoContent {
+		t.Fatalf("expected deletion, found %d %v", status, err)
+	}
+	if status, _, _ := butter.Load(nil, post["_id"]); status != http.StatusNotFound {
+		t.Errorf("expected 404 after deletion, found %d", status)
+	}
+	if _, total, err := butter.Search(nil, "Post", "@tags:{lisp}", "", 0, 10); err != nil || total != 0 {
+		t.Errorf("expected no post after deletion, found %d %v", total, err)
+	}
+}
+
+// TestSQLiteSearch compares the results of SQL with those of Memory.
+func TestSQLiteSearch(t *testing.T) {
+	sqlite, memory := newButter(t, newSQLite(t)), newButter(t, &model.Memory{})
+	posts := []map[string]string{
+		{"title": "The Go Programming Language", "views": "15", "rating": "4.5", "tags": "go,programming"},
+		{"title": "Programming Pearls", "views": "86", "rating": "4", "tags": "algorithms"},
+		{"title": "Go in Practice", "views": "16", "tags": "Go", "label": "go, web"},
+		{"title": "Structure and Interpretation", "views": "85", "rating": "5", "tags": "lisp,programming", "_read": "staff"},
+		{"title": "Über Go", "views": "20", "tags": "go,de", "label": "100% go_lang"},
+	}
+	for _, butter := range []*model.Butter{sqlite, memory} {
+		for _, post := range posts {
+			obj := map[string]string{"_schema": "Post"}
+			for key, value := range post {
+				obj[key] = value
+			}
+			if err := butter.Save(nil, obj); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	staff := model.NewContext("bob", "all", "staff")
+	queries := []string{
+		"", "*", "go", "GO", "prog*", `"in practice"`, "über", "-go",
+		"@tags:{go}", "@tags:{lisp|algorithms}", "-@tags:{go}", "@label:{web}", "@label:{go}",
+		"@views:[16 86]", "@views:[(16 (86]", "@views:[-inf 20]", "-@views:[20 +inf]", "@rating:[4 4.5]",
+		"@title:go -language", "@title:{go}", "@unknown:go", "-@unknown:go", "@_schema:{post}", "-@_schema:{post}",
+		"@label:100%", "@label:go_*", "@_created:[0 +inf]",
+	}
+	for _, query := range queries {
+		for _, page := range [][2]int{{0, 10}, {1, 2}, {0, -1}} {
+			expected, expectedTotal, err := memory.Search(staff, "Post", query, "title", page[0], page[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			found, total, err := sqlite.Search(staff, "Post", query, "title", page[0], page[1])
+			if err != nil {
+				t.Errorf("%q: %s", query, err)
+				continue
+			}
+			if titles(found) != titles(expected) || total != expectedTotal {
+				t.Errorf("%q from %d: expected %s of %d, found %s of %d", query, page[0], titles(expected), expectedTotal, titles(found), total)
+			}
+		}
+	}
+}
+
+func titles(objects []map[string]string) string {
+	result := make([]string, len(objects))
+	for i, obj := range objects {
+		result[i] = obj["title"] + "(" + obj["tags"] + ")"
+	}
+	return strings.Join(result, "|")
+}
+
+// TestSQLiteSlices loads the slices of more objects than fit into a batch.
+func TestSQLiteSlices(t *testing.T) {
+	butter := newButter(t, newSQLite(t))
+	for i := 0; i < 600; i++ {
+		n := strconv.Itoa(i)
+		if err := butter.Save(nil, map[string]string{"_schema": "Post", "title": n, "tags": "t" + n + ",all"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, total, err := butter.Search(nil, "Post", "@tags:{all}", "", 0, -1)
+	if err != nil || total != 600 || len(result) != 600 {
+		t.Fatalf("expected 600 posts, found %d of %d %v", len(result), total, err)
+	}
+	for _, obj := range result {
+		if obj["tags"] != "t"+obj["title"]+",all" {
+			t.Errorf("unexpected tags %s of %s", obj["tags"], obj["title"])
+		}
+	}
+}
+
+// TestSQLiteUniqueMigration adds a unique index to duplicate values.
+func TestSQLiteUniqueMigration(t *testing.T) {
+	conn := newSQLite(t)
+	butter := newButter(t, conn)
+	for i := 0; i < 2; i++ {
+		if err := butter.Save(nil, map[string]string{"_schema": "Post", "title": "Hello"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unique := &model.Butter{Conn: conn, ConfiguredSchemas: []*model.Schema{{Name: "Post", Elements: []*model.Element{
+		{Name: "title", Type: "string", Unique: true},
+		{Name: "summary", Type: "string"},
+	}}}}
+	if err := unique.InitFunc(); err == nil || !strings.Contains(err.Error(), "unique") {
+		t.Fatalf("expected the error of the unique index, found %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSQLStatements(t *testing.T) {
+	author := &Schema{Name: "Author", Elements: []*Element{{Name: "name", Type: "string", Index: true}}}
+	post := &Schema{Name: "Post", Elements: []*Element{
+		{Name: "title", Type: "string"},
+		{Name: "views", Type: "int"},
+		{Name: "tags", Type: "[]string", Index: true},
+		{Name: "author", Type: "*Author"},
+	}}
+	b := &Butter{schemas: map[string]*Schema{"Author": author, "Post": post}}
+	for _, schema := range []*Schema{author, post} {
+		if err := schema.initialize(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &SQL{dialect: sqlDialects["postgres"], schemas: map[string]*Schema{"author": author, "authors": author}}
+
+	statements, migrations := s.schemaStatements(post, nil)
+	expected := []string{
+		`CREATE TABLE "posts" ("_id" TEXT PRIMARY KEY, "_seq" BIGINT NOT NULL, "_label" TEXT, "_content" TEXT, "_owner" TEXT, ` +
+			`"_read" TEXT NOT NULL DEFAULT 'all', "_write" TEXT NOT NULL DEFAULT 'all', "_delete" TEXT NOT NULL DEFAULT 'all', ` +
+			`"_created" BIGINT, "_modified" BIGINT, "title" TEXT, "views" BIGINT, ` +
+			`"author" TEXT REFERENCES "authors" ("_id") ON DELETE SET NULL)`,
+		`CREATE TABLE IF NOT EXISTS "posts_tags" ("_owner" TEXT NOT NULL REFERENCES "posts" ("_id") ON DELETE CASCADE, ` +
+			`"_position" BIGINT NOT NULL, "value" TEXT, PRIMARY KEY ("_owner", "_position"))`,
+		`CREATE INDEX IF NOT EXISTS "posts_tags_idx" ON "posts_tags" ("value")`,
+	}
+	if !reflect.DeepEqual(statements, expected) || migrations != 1 {
+		t.Errorf("expected\n%s\nfound %d\n%s", strings.Join(expected, "\n"), migrations, strings.Join(statements, "\n"))
+	}
+
+	existing := map[string]bool{"_id": true, "_seq": true, "title": true, "removed": true}
+	for _, column := range sqlMeta {
+		existing[column] = true
+	}
+	statements, migrations = s.schemaStatements(post, existing)
+	expected = []string{
+		`ALTER TABLE "posts" ADD COLUMN "views" BIGINT`,
+		`ALTER TABLE "posts" ADD COLUMN "author" TEXT REFERENCES "authors" ("_id") ON DELETE SET NULL`,
+	}
+	if !reflect.DeepEqual(statements[:migrations], expected) {
+		t.Errorf("expected migrations\n%s\nfound\n%s", strings.Join(expected, "\n"), strings.Join(statements[:migrations], "\n"))
+	}
+
+	if found := s.bind(`UPDATE "t" SET "a" = ? WHERE "_id" = ?`); found != `UPDATE "t" SET "a" = $1 WHERE "_id" = $2` {
+		t.Errorf("unexpected binding %s", found)
+	}
+	s.dialect = sqlDialects["sqlite"]
+	if found := s.bind(`"a" = ?`); found != `"a" = ?` {
+		t.Errorf("unexpected binding %s", found)
+	}
+	where, args := readable(NewContext("bob", "all", "r&d_team"))
+	if where != `((',' || "_read" || ',') LIKE ? ESCAPE '\' OR (',' || "_read" || ',') LIKE ? ESCAPE '\')` ||
+		!reflect.DeepEqual(args, []interface{}{"%,all,%", `%,r&d\_team,%`}) {
+		t.Errorf("unexpected condition %s %v", where, args)
+	}
+	if err := (&SQL{Driver: "mysql"}).InitFunc(); err == nil {
+		t.Error("expected error for unknown dialect")
+	}
+}
@@ -0,0 +1,546 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Meta holds the values managed by the connections. Structs bound with
+// Butter.Bind embed it to access id, label, groups and stamps of their
+// object. Empty groups are not saved, the stamps are never saved.
+type Meta struct {
+	ID       string    `model:"_id"`
+	Label    string    `model:"_label"`
+	Read     []string  `model:"_read"`
+	Write    []string  `model:"_write"`
+	Delete   []string  `model:"_delete"`
+	Created  time.Time `model:"_created"`
+	Modified time.Time `model:"_modified"`
+}
+
+// binding maps the fields of a struct to the values of an object.
+type binding struct {
+	typ    reflect.Type
+	fields []*boundField
+}
+
+type boundField struct {
+	name   string
+	index  []int
+	layout string
+	meta   bool
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// unixLayout formats times as unix timestamps like _created.
+const unixLayout = "unix"
+
+// structFields returns the fields of the struct t including those of
+// embedded structs. Fields are named by the first value of their model tag,
+// otherwise by their name starting lower case. Fields tagged - are skipped.
+func structFields(t reflect.Type, index []int) (fields []reflect.StructField, names, options [][]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("model")
+		if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		f.Index = append(append([]int(nil), index...), i)
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct && f.Type != timeType {
+			ef, en, eo := structFields(f.Type, f.Index)
+			fields, names, options = append(fields, ef...), append(names, en...), append(options, eo...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		if parts[0] == "" {
+			parts[0] = strings.ToLower(f.Name[:1]) + f.Name[1:]
+		}
+		fields = append(fields, f)
+		names = append(names, parts[:1])
+		options = append(options, parts[1:])
+	}
+	return
+}
+
+// deriveSchema returns the schema named name with an element for every
+// field of t. Besides the name, the model tag of a field accepts the
+// options index, sort, required, unique and immutable and the values
+// type=, ref=, default=, values= (separated by |), min=, max=, minLength=,
+// maxLength=, label=, help= and widget=. The type is derived from the Go
+// type unless given, ref=Name makes a string or []string a pointer to
+// objects of the schema Name.
+func deriveSchema(name string, t reflect.Type) (*Schema, error) {
+	schema := &Schema{Name: name}
+	fields, names, options := structFields(t, nil)
+	for i, f := range fields {
+		if strings.HasPrefix(names[i][0], "_") {
+			continue
+		}
+		e := &Element{Name: names[i][0]}
+		base, slice, err := goType(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", f.Name, err.Error())
+		}
+		for _, option := range options[i] {
+			key, value := option, ""
+			if j := strings.IndexByte(option, '='); j >= 0 {
+				key, value = option[:j], option[j+1:]
+			}
+			switch key {
+			case "index":
+				e.Index = true
+			case "sort":
+				e.Sort = true
+			case "required":
+				e.Required = true
+			case "unique":
+				e.Unique = true
+			case "immutable":
+				e.Immutable = true
+			case "type":
+				base = value
+			case "ref":
+				base = "*" + value
+			case "default":
+				e.Default = value
+			case "values":
+				e.Values = strings.Split(value, "|")
+			case "min", "max":
+				v, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: invalid %s %s", f.Name, key, value)
+				}
+				if key == "min" {
+					e.Min = &v
+				} else {
+					e.Max = &v
+				}
+			case "minLength", "maxLength":
+				v, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: invalid %s %s", f.Name, key, value)
+				}
+				if key == "minLength" {
+					e.MinLength = v
+				} else {
+					e.MaxLength = v
+				}
+			case "label":
+				e.Label = value
+			case "help":
+				e.Help = value
+			case "widget":
+				e.Widget = value
+			default:
+				return nil, fmt.Errorf("field %s: unknown option %s", f.Name, key)
+			}
+		}
+		e.Type = base
+		if slice {
+			e.Type = "[]" + base
+		}
+		schema.Elements = append(schema.Elements, e)
+	}
+	return schema, nil
+}
+
+// goType returns the element type of a value of t and whether it is a
+// slice. Pointers to values are optional values.
+func goType(t reflect.Type) (base string, slice bool, err error) {
+	if t.Kind() == reflect.Slice {
+		slice = true
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string", slice, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int", slice, nil
+	case reflect.Float32, reflect.Float64:
+		return "float", slice, nil
+	case reflect.Bool:
+		return "bool", slice, nil
+	}
+	if t == timeType {
+		return "datetime", slice, nil
+	}
+	return "", false, fmt.Errorf("unsupported type %s", t)
+}
+
+// newBinding maps the fields of t to the elements of schema.
+func newBinding(schema *Schema, t reflect.Type) (*binding, error) {
+	b := &binding{typ: t}
+	fields, names, _ := structFields(t, nil)
+	for i, f := range fields {
+		field := &boundField{name: names[i][0], index: f.Index, layout: time.RFC3339}
+		switch field.name {
+		case "_created", "_modified":
+			field.layout = unixLayout
+			field.meta = true
+		case "_id", "_label", "_read", "_write", "_delete", "_content", "_owner":
+			field.meta = true
+		default:
+			var e *Element
+			for _, current := range schema.Elements {
+				if current.Name == field.name {
+					e = current
+				}
+			}
+			if e == nil {
+				return nil, fmt.Errorf("schema %s does not contain element %s of field %s", schema.Name, field.name, f.Name)
+			}
+			if strings.TrimPrefix(strings.TrimSpace(e.Type), "[]") == "date" {
+				field.layout = "2006-01-02"
+			}
+		}
+		if _, _, err := goType(f.Type); err != nil {
+			return nil, fmt.Errorf("field %s: %s", f.Name, err.Error())
+		}
+		b.fields = append(b.fields, field)
+	}
+	return b, nil
+}
+
+// encode returns the object of the struct v.
+func (b *binding) encode(v reflect.Value) (map[string]string, error) {
+	data := make(map[string]string, len(b.fields))
+	for _, field := range b.fields {
+		if field.layout == unixLayout {
+			continue
+		}
+		value, err := encodeValue(v.FieldByIndex(field.index), field.layout)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", field.name, err.Error())
+		}
+		if value != "" || !field.meta {
+			data[field.name] = value
+		}
+	}
+	return data, nil
+}
+
+// decode sets the fields of the struct v to the values of data. Fields
+// without value in data are not changed.
+func (b *binding) decode(data map[string]string, v reflect.Value) error {
+	for _, field := range b.fields {
+		value, ok := data[field.name]
+		if !ok {
+			continue
+		}
+		if err := decodeValue(value, v.FieldByIndex(field.index), field.layout); err != nil {
+			return fmt.Errorf("%s: %s", field.name, err.Error())
+		}
+	}
+	return nil
+}
+
+func encodeValue(v reflect.Value, layout string) (string, error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return "", nil
+		}
+		return encodeValue(v.Elem(), layout)
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Slice:
+		values := make([]string, v.Len())
+		for i := range values {
+			value, err := encodeValue(v.Index(i), layout)
+			if err != nil {
+				return "", err
+			}
+			values[i] = value
+		}
+		return strings.Join(values, ","), nil
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		switch {
+		case t.IsZero():
+			return "", nil
+		case layout == unixLayout:
+			return strconv.FormatInt(t.Unix(), 10), nil
+		}
+		return t.Format(layout), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func decodeValue(value string, v reflect.Value, layout string) (err error) {
+	if value == "" {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if err = decodeValue(value, p.Elem(), layout); err == nil {
+			v.Set(p)
+		}
+		return
+	case reflect.String:
+		v.SetString(value)
+		return
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, err = strconv.ParseInt(value, 10, v.Type().Bits()); err == nil {
+			v.SetInt(i)
+		}
+		return
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		if u, err = strconv.ParseUint(value, 10, v.Type().Bits()); err == nil {
+			v.SetUint(u)
+		}
+		return
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(value, v.Type().Bits()); err == nil {
+			v.SetFloat(f)
+		}
+		return
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(value); err == nil {
+			v.SetBool(b)
+		}
+		return
+	case reflect.Slice:
+		values := strings.Split(value, ",")
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, current := range values {
+			if err = decodeValue(current, slice.Index(i), layout); err != nil {
+				return
+			}
+		}
+		v.Set(slice)
+		return
+	}
+	if v.Type() != timeType {
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	var t time.Time
+	if layout == unixLayout {
+		var sec int64
+		if sec, err = strconv.ParseInt(value, 10, 64); err == nil {
+			t = time.Unix(sec, 0)
+		}
+	} else if t, err = time.Parse(layout, value); err != nil && layout == time.RFC3339 {
+		// datetime elements accept the layouts of datetime-local inputs
+		for _, current := range dateTimeLayouts[1:] {
+			if t, err = time.Parse(current, value); err == nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		v.Set(reflect.ValueOf(t))
+	}
+	return
+}
+
+// structValue returns the struct v points to.
+func (b *binding) structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Type() != reflect.PtrTo(b.typ) || rv.IsNil() {
+		return reflect.Value{}, fmt.Errorf("expected *%s, found %T", b.typ, v)
+	}
+	return rv.Elem(), nil
+}
+
+// Encode returns the object of v, which points to the struct bound to the
+// schema with Butter.Bind.
+func (s *Schema) Encode(v interface{}) (map[string]string, error) {
+	if s.binding == nil {
+		return nil, fmt.Errorf("schema %s is not bound to a struct", s.Name)
+	}
+	rv, err := s.binding.structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.binding.encode(rv)
+	if err == nil {
+		data["_schema"] = s.Name
+	}
+	return data, err
+}
+
+// Decode sets the struct v points to the values of the object data. Fields
+// without value in data are not changed.
+func (s *Schema) Decode(data map[string]string, v interface{}) error {
+	if s.binding == nil {
+		return fmt.Errorf("schema %s is not bound to a struct", s.Name)
+	}
+	rv, err := s.binding.structValue(v)
+	if err != nil {
+		return err
+	}
+	return s.binding.decode(data, rv)
+}
+
+// Bind binds the struct prototype points to to the schema named like the
+// struct type. If no such schema is configured it is derived from the
+// fields of the struct, see deriveSchema for the model tags. Otherwise
+// every field must name an element or a value of Meta. Schemas referenced
+// by pointers must be bound or configured before. Bind is meant to be
+// called at start, before or after InitFunc.
+func (b *Butter) Bind(prototype interface{}) (*Schema, error) {
+	t := reflect.TypeOf(prototype)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected pointer to struct, found %T", prototype)
+	}
+	t = t.Elem()
+	if t.Name() == "" {
+		return nil, fmt.Errorf("cannot bind anonymous struct %s", t)
+	}
+	var schema *Schema
+	for _, current := range b.ConfiguredSchemas {
+		if current.Name == t.Name() {
+			schema = current
+		}
+	}
+	derived := schema == nil
+	if derived {
+		var err error
+		if schema, err = deriveSchema(t.Name(), t); err != nil {
+			return nil, fmt.Errorf("struct %s: %s", t.Name(), err.Error())
+		}
+	}
+	bd, err := newBinding(schema, t)
+	if err != nil {
+		return nil, err
+	}
+	if derived && b.schemas != nil {
+		// InitFunc is done, the schema is initialized like those configured
+		b.schemas[schema.Name] = schema
+		if err = schema.initialize(b); err != nil {
+			delete(b.schemas, schema.Name)
+			return nil, fmt.Errorf("schema %s: %s", schema.Name, err.Error())
+		}
+		if err = b.Conn.BuildIndex(schema); err != nil {
+			delete(b.schemas, schema.Name)
+			return nil, fmt.Errorf("schema %s: %s", schema.Name, err.Error())
+		}
+	}
+	if derived {
+		b.ConfiguredSchemas = append(b.ConfiguredSchemas, schema)
+	}
+	schema.binding = bd
+	if b.types == nil {
+		b.types = make(map[reflect.Type]*Schema)
+	}
+	b.types[t] = schema
+	return schema, nil
+}
+
+// boundSchema returns the schema bound to the struct type of v.
+func (b *Butter) boundSchema(t reflect.Type) (*Schema, error) {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if schema, ok := b.types[t]; ok {
+		return schema, nil
+	}
+	return nil, fmt.Errorf("type %s is not bound to a schema", t)
+}
+
+// SaveObject saves the struct v points to like Save. All elements are
+// saved, zero values of strings, slices, pointers and times unset them.
+// The fields of v are updated with the saved values, e.g. the id and the
+// computed values.
+func (b *Butter) SaveObject(ctx *Context, v interface{}) error {
+	schema, err := b.boundSchema(reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+	data, err := schema.Encode(v)
+	if err != nil {
+		return err
+	}
+	if err = b.Save(ctx, data); err != nil {
+		return err
+	}
+	return schema.Decode(data, v)
+}
+
+// LoadObject loads the object id into the struct v points to. Objects of
+// other schemas than the one bound to the struct are not found.
+func (b *Butter) LoadObject(ctx *Context, id string, v interface{}) (int, error) {
+	schema, err := b.boundSchema(reflect.TypeOf(v))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	status, data, err := b.Load(ctx, id)
+	if err != nil {
+		return status, err
+	}
+	if data["_schema"] != schema.Name {
+		return http.StatusNotFound, fmt.Errorf("object %s of %s not found", id, schema.Name)
+	}
+	rv, err := schema.binding.structValue(v)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	rv.Set(reflect.Zero(rv.Type()))
+	if err = schema.binding.decode(data, rv); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return status, nil
+}
+
+// SearchObjects searches like Search and stores the page of objects in
+// the slice result points to. Its elements are bound structs or pointers to
+// them. The total number of matching objects is returned.
+func (b *Butter) SearchObjects(ctx *Context, query, sort string, offset, num int, result interface{}) (int, error) {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return 0, fmt.Errorf("expected pointer to slice, found %T", result)
+	}
+	slice := rv.Elem()
+	et := slice.Type().Elem()
+	schema, err := b.boundSchema(et)
+	if err != nil {
+		return 0, err
+	}
+	objects, total, err := b.Search(ctx, schema.Name, query, sort, offset, num)
+	if err != nil {
+		return 0, err
+	}
+	values := reflect.MakeSlice(slice.Type(), len(objects), len(objects))
+	for i, data := range objects {
+		target := values.Index(i)
+		if et.Kind() == reflect.Ptr {
+			target.Set(reflect.New(et.Elem()))
+			target = target.Elem()
+		}
+		if err = schema.binding.decode(data, target); err != nil {
+			return 0, err
+		}
+	}
+	slice.Set(values)
+	return total, nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Writer struct {
+	Meta
+	Name string `model:"name,required,unique"`
+}
+
+type Article struct {
+	Meta
+	Title     string    `model:"title,index,required"`
+	Views     int       `model:",sort"`
+	Rating    *float64  `model:"rating,min=0,max=5"`
+	Tags      []string  `model:"tags"`
+	Published bool      `model:"published"`
+	Day       time.Time `model:"day,type=date"`
+	State     string    `model:"state,type=enum,values=draft|final,default=draft"`
+	Writer    string    `model:"writer,ref=Writer"`
+	internal  string
+	Ignored   string `model:"-"`
+}
+
+func TestBindDerivesSchema(t *testing.T) {
+	butter := &Butter{Conn: &Memory{}}
+	if _, err := butter.Bind(&Writer{}); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := butter.Bind(&Article{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range schema.Elements {
+		types = append(types, e.Name+":"+e.Type)
+	}
+	expected := []string{"title:string", "views:int", "rating:float", "tags:[]string", "published:bool",
+		"day:date", "state:enum", "writer:*Writer"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected %v, found %v", expected, types)
+	}
+	if e := schema.Element("title"); !e.Index || !e.Required || !schema.Element("views").Sort {
+		t.Errorf("expected options of the tags, found %+v", e)
+	}
+	if butter.Schema("Article") != schema {
+		t.Error("expected registered schema")
+	}
+	if _, err = butter.Bind(Article{}); err == nil {
+		t.Error("expected error for a struct value")
+	}
+	type Broken struct {
+		Value complex128
+	}
+	if _, err = butter.Bind(&Broken{}); err == nil {
+		t.Error("expected error for an unsupported type")
+	}
+}
+
+func TestTypedObjects(t *testing.T) {
+	conn := &Memory{}
+	butter := &Butter{Conn: conn}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	// binding after InitFunc builds the index
+	if _, err := butter.Bind(&Writer{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := butter.Bind(&Article{}); err != nil {
+		t.Fatal(err)
+	}
+	writer := &Writer{Name: "Ann"}
+	if err := butter.SaveObject(nil, writer); err != nil || writer.ID == "" || writer.Created.IsZero() {
+		t.Fatalf("expected id and stamp, found %+v %v", writer, err)
+	}
+	rating := 4.5
+	day := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	article := &Article{Title: "Typed", Views: 3, Rating: &rating, Tags: []string{"go", "web"}, Published: true,
+		Day: day, Writer: writer.ID}
+	if err := butter.SaveObject(nil, article); err != nil {
+		t.Fatal(err)
+	}
+	if article.State != "draft" {
+		t.Errorf("expected default state, found %q", article.State)
+	}
+	_, data, _ := conn.Load(nil, article.ID)
+	if data["views"] != "3" || data["rating"] != "4.5" || data["tags"] != "go,web" || data["day"] != "2018-03-01" || data["published"] != "true" {
+		t.Errorf("unexpected stored values %v", data)
+	}
+
+	var loaded Article
+	if status, err := butter.LoadObject(nil, article.ID, &loaded); err != nil {
+		t.Fatalf("%d %v", status, err)
+	}
+	if loaded.Title != "Typed" || loaded.Views != 3 || *loaded.Rating != 4.5 || !reflect.DeepEqual(loaded.Tags, []string{"go", "web"}) ||
+		!loaded.Published || !loaded.Day.Equal(day) || loaded.Writer != writer.ID || loaded.Read[0] != "all" {
+		t.Errorf("unexpected loaded article %+v", loaded)
+	}
+	if status, err := butter.LoadObject(nil, writer.ID, &loaded); err == nil || status != 404 {
+		t.Errorf("expected 404 for a writer, found %d %v", status, err)
+	}
+
+	// a zero value unsets the element
+	loaded.Rating = nil
+	loaded.Views = 7
+	if err := butter.SaveObject(nil, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	var articles []*Article
+	total, err := butter.SearchObjects(nil, "@views:[5 +inf]", "", 0, 10, &articles)
+	if err != nil || total != 1 || len(articles) != 1 || articles[0].Rating != nil || articles[0].Views != 7 {
+		t.Errorf("expected the updated article, found %d %v %v", total, articles, err)
+	}
+	var writers []Writer
+	if total, err = butter.SearchObjects(nil, "", "", 0, 10, &writers); err != nil || total != 1 || writers[0].Name != "Ann" {
+		t.Errorf("expected one writer, found %d %v %v", total, writers, err)
+	}
+
+	var ve *ValidationError
+	if err = butter.SaveObject(nil, &Writer{Name: "Ann"}); !errors.As(err, &ve) || ve.Fields[0].Code != UniqueViolation {
+		t.Errorf("expected unique violation, found %v", err)
+	}
+	if err = butter.SaveObject(nil, &Article{Title: "Dangling", Writer: "brot:none"}); !errors.As(err, &ve) || ve.Fields[0].Code != ReferenceViolation {
+		t.Errorf("expected reference violation, found %v", err)
+	}
+	type Unbound struct{ Name string }
+	if err = butter.SaveObject(nil, &Unbound{}); err == nil {
+		t.Error("expected error for an unbound type")
+	}
+}
+
+func TestBindConfiguredSchema(t *testing.T) {
+	butter := &Butter{Conn: &Memory{}, ConfiguredSchemas: []*Schema{
+		{Name: "Writer", Elements: []*Element{{Name: "name", Type: "string"}, {Name: "bio", Type: "text"}}},
+	}}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := butter.Bind(&Writer{})
+	if err != nil || schema != butter.Schema("Writer") || len(schema.Elements) != 2 {
+		t.Fatalf("expected the configured schema, found %v %v", schema, err)
+	}
+	// map based access keeps working
+	data := map[string]string{"_schema": "Writer", "name": "Bob", "bio": "Long text"}
+	if err = butter.Save(nil, data); err != nil {
+		t.Fatal(err)
+	}
+	var writer Writer
+	if err = (&Vanilla{Schema: schema, Data: data}).Decode(&writer); err != nil || writer.Name != "Bob" || writer.ID != data["_id"] {
+		t.Errorf("unexpected writer %+v %v", writer, err)
+	}
+	if _, err = butter.Bind(&struct{ Writer }{}); err == nil {
+		t.Error("expected error for an anonymous struct")
+	}
+	{
+		type Writer struct {
+			Missing string
+		}
+		if _, err = butter.Bind(&Writer{}); err == nil {
+			t.Error("expected error for a field without element")
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Codes of a FieldError.
+const (
+	RequiredViolation  = "required"
+	TypeViolation      = "type"
+	MinViolation       = "min"
+	MaxViolation       = "max"
+	EnumViolation      = "enum"
+	PatternViolation   = "pattern"
+	CountViolation     = "count"
+	ValueViolation     = "value"
+	UniqueViolation    = "unique"
+	ImmutableViolation = "immutable"
+	ReferenceViolation = "reference"
+	UnknownViolation   = "unknown"
+)
+
+// FieldError describes a violated constraint of an element or a request
+// value.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists all violations of an object or a request.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("invalid values: ")
+	for i, field := range e.Fields {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(field.Message)
+	}
+	return buffer.String()
+}
+
+// Add appends a violation of field with a message formatted like fmt.Sprintf.
+func (e *ValidationError) Add(field, code, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// errorOrNil returns the sorted error or nil without violations.
+func (e *ValidationError) errorOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	sort.SliceStable(e.Fields, func(i, j int) bool { return e.Fields[i].Field < e.Fields[j].Field })
+	return e
+}
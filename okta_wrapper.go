@@ -6,9 +6,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/fuxsig/brot/di"
 	"github.com/fuxsig/brot/wrapper"
@@ -45,6 +46,7 @@ func (h *OktaWrapper) Retry() bool {
 }
 
 func (h *OktaWrapper) exchangeCode(code string, r *http.Request) (exchange *Exchange, err error) {
+	l := logger("okta")
 	authHeader := base64.StdEncoding.EncodeToString(
 		[]byte(h.ClientID + ":" + h.ClientSecret))
 
@@ -61,8 +63,8 @@ func (h *OktaWrapper) exchangeCode(code string, r *http.Request) (exchange *Exch
 		body []byte
 	)
 
-	if req, err = http.NewRequest("POST", url, nil); err != nil {
-		log.Printf("[OktaWrapper.exchangeCode] request error: %s", err.Error())
+	if req, err = newRequest(r.Context(), "POST", url, nil); err != nil {
+		l.ErrorContext(r.Context(), "token request error", "error", err)
 		return
 	}
 
@@ -80,18 +82,18 @@ func (h *OktaWrapper) exchangeCode(code string, r *http.Request) (exchange *Exch
 	client := &http.Client{Transport: tr}
 
 	if resp, err = client.Do(req); err != nil {
-		log.Printf("[OktaWrapper.exchangeCode] http error: %s", err.Error())
+		l.ErrorContext(r.Context(), "token http error", "error", err)
 		return
 	}
 	defer resp.Body.Close()
 	if body, err = ioutil.ReadAll(resp.Body); err != nil {
-		log.Printf("[OktaWrapper.exchangeCode] read body error: %s", err.Error())
+		l.ErrorContext(r.Context(), "token read body error", "error", err)
 		return
 	}
 	exchange = new(Exchange)
 	err = json.Unmarshal(body, exchange)
 	if err != nil {
-		log.Printf("[OktaWrapper.exchangeCode] json unmarshall error: %s", err.Error())
+		l.ErrorContext(r.Context(), "token json unmarshal error", "error", err)
 		return nil, nil
 	}
 	return
@@ -108,43 +110,44 @@ func (h *OktaWrapper) verifyToken(nonce, t string) *verifier.Jwt {
 
 	result, err := jv.New().VerifyIdToken(t)
 	if err != nil {
-		log.Printf("[OktaWrapper.verifyToken] verify error: %s", err.Error())
+		logger("okta").Warn("verify error", "error", err)
 		return nil
 	}
 	return result
 }
 
 func (h *OktaWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l := logger("okta")
 	q := r.URL.Query()
 	state := q.Get("state")
 	if state != "ApplicationState" {
-		log.Printf("[OktaWrapper.ServeHTTP]: Wrong state %s in request %s\n", state, r.URL.String())
+		l.WarnContext(r.Context(), "wrong state in callback", "state", state, "path", r.URL.Path)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	code := q.Get("code")
 	if code == "" {
-		log.Printf("[OktaWrapper.ServeHTTP]: Missing code in request %s\n", r.URL.String())
+		l.WarnContext(r.Context(), "missing code in callback", "path", r.URL.Path)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	exchange, err := h.exchangeCode(code, r)
 	if err != nil {
-		log.Printf("[OktaWrapper.ServeHTTP]: Exchange error: %s\n", err.Error())
+		l.WarnContext(r.Context(), "exchange error", "error", err)
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
 	session, err := sessionStore.Get(r, "brot-store")
 	if err != nil {
-		log.Printf("[OktaWrapper.ServeHTTP]: Session error: %s\n", err.Error())
+		l.ErrorContext(r.Context(), "session error", "error", err)
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 	/*nonce, ok := session.Values["nonce"].(string)
 	if !ok {
-		log.Printf("[OktaWrapper.ServeHTTP]: Missing nonce value in session\n")
+		l.WarnContext(r.Context(), "missing nonce value in session")
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}*/
@@ -155,7 +158,7 @@ func (h *OktaWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// the external lib does not work well at the moment :(
 	/*if _, err = h.verifyToken(nonce, exchange.IdToken); err != nil {
-		log.Printf("[OktaWrapper.ServeHTTP]: Verify token error: %s\n", err.Error())
+		l.WarnContext(r.Context(), "verify token error", "error", err)
 		w.WriteHeader(http.StatusForbidden)
 		return
 	} else {*/
@@ -174,7 +177,11 @@ func (h *OktaWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	)
 
 	reqURL := h.Issuer + "/v1/userinfo"
-	req, err = http.NewRequest("GET", reqURL, nil)
+	if req, err = newRequest(r.Context(), "GET", reqURL, nil); err != nil {
+		l.ErrorContext(r.Context(), "user info request error", "error", err)
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+		return
+	}
 	head := req.Header
 	head.Add("Authorization", "Bearer "+exchange.AccessToken)
 	head.Add("Accept", "application/json")
@@ -188,27 +195,29 @@ func (h *OktaWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// this is the trick
 	defer http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 	if resp, err = client.Do(req); err != nil {
-		log.Printf("[OktaWrapper.ServeHTTP] user info request error: %s", err.Error())
+		l.ErrorContext(r.Context(), "user info request error", "error", err)
 		return
 	}
 	defer resp.Body.Close()
 	var body []byte
 	if body, err = ioutil.ReadAll(resp.Body); err != nil {
-		log.Printf("[OktaWrapper.ServeHTTP] body read error: %s", err.Error())
+		l.ErrorContext(r.Context(), "user info read body error", "error", err)
 		return
 	}
 	m := make(map[string]interface{})
 	if err = json.Unmarshal(body, &m); err != nil {
-		log.Printf("[OktaWrapper.ServeHTTP] json unmarshall error: %s", err.Error())
+		l.ErrorContext(r.Context(), "user info json unmarshal error", "error", err)
 		return
 	}
 	session.Values["email"] = m["email"]
 	session.Values["given_name"] = m["given_name"]
+	// the groups claim is only present if the groups scope is granted
+	session.Values["groups"] = strings.Join(stringValues(m["groups"]), ",")
 	if err = session.Save(r, w); err != nil {
-		log.Printf("[OktaWrapper.ServeHTTP] could not save seesion: %s", err.Error())
+		l.ErrorContext(r.Context(), "could not save session", "error", err)
 		return
 	}
-	log.Printf("[OktaWrapper.ServeHTTP] retrieved user profile for %s", m["email"])
+	l.InfoContext(r.Context(), "retrieved user profile", "user", m["email"])
 }
 
 func isAuthenticated(r *http.Request) bool {
@@ -229,12 +238,18 @@ func generateNonce() string {
 func (h *OktaWrapper) ServeChain(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 
 	if isAuthenticated(r) {
+		if session, err := sessionStore.Get(r, "brot-store"); err == nil {
+			if email, ok := session.Values["email"].(string); ok && email != "" {
+				r = r.WithContext(WithLogAttrs(r.Context(), slog.String("user", email)))
+			}
+		}
 		next.ServeHTTP(w, r)
 		return
 	}
+	l := logger("okta")
 	session, err := sessionStore.Get(r, "brot-store")
 	if err != nil {
-		log.Printf("[OktaWrapper.ServeChain]: Session error: %s", err.Error())
+		l.ErrorContext(r.Context(), "session error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -244,7 +259,7 @@ func (h *OktaWrapper) ServeChain(w http.ResponseWriter, r *http.Request, next ht
 	session.Values["url"] = r.URL.String()
 	err = session.Save(r, w)
 	if err != nil {
-		log.Printf("[OktaWrapper.ServeChain]: Session error: %s", err.Error())
+		l.ErrorContext(r.Context(), "session error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -289,10 +304,10 @@ func (o *OktaLogout) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	delete(session.Values, "access_token")
 	delete(session.Values, "nonce")
 	if err = session.Save(r, w); err != nil {
-		log.Printf("[OktaLogout.ServeHTTP] could not save session: %s", err.Error())
+		logger("okta").ErrorContext(r.Context(), "could not save session", "error", err)
 		return
 	}
-	log.Printf("[OktaLogout.ServeHTTP] successfully deleted session for id_token %s", idToken)
+	logger("okta").InfoContext(r.Context(), "deleted session")
 }
 
 func (o *OktaLogout) HandlerFunc() http.Handler {
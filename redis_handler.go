@@ -7,7 +7,6 @@ package brot
 import (
 	"bytes"
 	"encoding/gob"
-	"log"
 	"reflect"
 	"time"
 
@@ -29,7 +28,7 @@ func (r *RedisHandler) InitFunc() (err error) {
 	r.pool, err = pool.New(r.Network, r.Address, r.Size)
 	if err != nil {
 		// handle err
-		log.Printf("Error in RedisHandler: %s", err.Error())
+		logger("redis").Error("could not create pool", "address", r.Address, "error", err)
 		panic("No Database!!!")
 	}
 	return
@@ -6,9 +6,11 @@ package brot
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -26,6 +28,31 @@ type RedisearchHandler struct {
 	RetryMax int    `brot:"retry"`
 	retry    int
 	client   *redisearch.Client
+	idle     chan *redisConn
+}
+
+// maxIdleRedisConns limits the connections kept open for later operations.
+const maxIdleRedisConns = 8
+
+// redisConn is a pooled connection whose reads and writes end at the
+// deadline of the context of the current operation.
+type redisConn struct {
+	redis.Conn
+	net      net.Conn
+	deadline time.Time
+}
+
+// Do sends the command and waits for its reply until the deadline.
+func (c *redisConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if c.deadline.IsZero() {
+		return c.Conn.Do(cmd, args...)
+	}
+	// redigo resets the read deadline, so it is passed as timeout
+	timeout := time.Until(c.deadline)
+	if timeout <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	return redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
 }
 
 func (r *RedisearchHandler) Allocated() {
@@ -34,6 +61,7 @@ func (r *RedisearchHandler) Allocated() {
 
 // InitFunc initialize opens a new connection to the configured Redis database
 func (r *RedisearchHandler) InitFunc() (err error) {
+	r.idle = make(chan *redisConn, maxIdleRedisConns)
 	r.client = redisearch.NewClient(r.Address, "vanilla")
 	if _, err = r.client.Info(); err != nil {
 		schema := redisearch.NewSchema(redisearch.DefaultOptions).
@@ -59,7 +87,7 @@ func (r *RedisearchHandler) Retry() bool {
 	return false
 }
 
-func (r *RedisearchHandler) BuildIndex(schema *model.Schema) {
+func (r *RedisearchHandler) BuildIndex(schema *model.Schema) error {
 	r.client = redisearch.NewClient(r.Address, schema.Plural)
 	if _, err := r.client.Info(); err != nil {
 		s := redisearch.NewSchema(redisearch.DefaultOptions).
@@ -72,44 +100,89 @@ func (r *RedisearchHandler) BuildIndex(schema *model.Schema) {
 			AddField(redisearch.NewTagField("_read")).
 			AddField(redisearch.NewTagField("_write")).
 			AddField(redisearch.NewTagField("_delete"))
+		// unique elements are indexed for the search of Schema.Check
 		for _, element := range schema.Elements {
-			if !element.Index {
+			if !element.Index && !element.Unique {
 				continue
 			}
 			name := strings.ToLower(element.Name)
-			switch element.Type {
-			case "string":
+			switch {
+			case element.Type == "string" || element.Unique && element.Kind() == reflect.String:
 				if element.Sort {
 					s.AddField(redisearch.NewSortableTextField(name, 1.0))
 				} else {
 					s.AddField(redisearch.NewTextField(name))
 				}
-			case "int":
+			case element.Type == "int" || element.Unique && element.Kind() == reflect.Float64:
 				if element.Sort {
 					s.AddField(redisearch.NewSortableNumericField(name))
 				} else {
 					s.AddField(redisearch.NewNumericField(name))
 				}
-			default:
-				log.Panicf("ups, not implemented yet")
+			case element.Index:
+				return fmt.Errorf("element %s of type %s cannot be indexed", element.Name, element.Type)
 			}
 		}
 		if err := r.client.CreateIndex(s); err != nil {
-			log.Panicf("Could not create index for %s", r.Address)
+			return fmt.Errorf("could not create index %s at %s: %w", schema.Plural, r.Address, err)
 		}
 	}
+	return nil
+}
+
+// dial returns an idle or a new connection, whose reads and writes fail
+// once the deadline of ctx passed. The returned func releases the
+// connection.
+func (r *RedisearchHandler) dial(ctx context.Context) (redis.Conn, func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	var conn *redisConn
+	select {
+	case conn = <-r.idle:
+	default:
+		var dialer net.Dialer
+		c, err := dialer.DialContext(ctx, "tcp", r.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn = &redisConn{Conn: redis.NewConn(c, 0, 0), net: c}
+	}
+	conn.deadline, _ = ctx.Deadline()
+	if err := conn.net.SetWriteDeadline(conn.deadline); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, func() { r.release(conn) }, nil
+}
+
+// release keeps conn for later operations unless it failed or enough
+// connections are idle.
+func (r *RedisearchHandler) release(conn *redisConn) {
+	if conn.Err() != nil || conn.net.SetWriteDeadline(time.Time{}) != nil {
+		conn.Close()
+		return
+	}
+	select {
+	case r.idle <- conn:
+	default:
+		conn.Close()
+	}
 }
 
 func (r *RedisearchHandler) Save(ctx *model.Context, data map[string]string, schema *model.Schema) (err error) {
 	if ctx == nil {
 		ctx = model.Anonymous
 	}
-	var conn redis.Conn
-	conn, err = redis.Dial("tcp", r.Address)
+	var (
+		conn    redis.Conn
+		release func()
+	)
+	conn, release, err = r.dial(ctx.Context())
 	if err != nil {
 		return
 	}
-	defer conn.Close()
+	defer release()
 	// check the id
 	id := data["_id"]
 	create := id == ""
@@ -146,7 +219,7 @@ func (r *RedisearchHandler) Save(ctx *model.Context, data map[string]string, sch
 			// check write groups
 			groups := strings.Split(values[1], ",")
 			if !ctx.MemberOf(groups...) {
-				return fmt.Errorf("access denied")
+				return model.ErrAccessDenied
 			}
 		}
 	}
@@ -180,6 +253,10 @@ func (r *RedisearchHandler) Save(ctx *model.Context, data map[string]string, sch
 	for key, value := range data {
 		doc.Set(key, value)
 	}
+	// the RediSearch client cannot be cancelled, so check before indexing
+	if err = ctx.Context().Err(); err != nil {
+		return
+	}
 	c := redisearch.NewClient(r.Address, schema.Plural)
 	if create {
 		if err = c.Index(doc); err != nil {
@@ -201,16 +278,19 @@ func (r *RedisearchHandler) Load(ctx *model.Context, id string) (int, map[string
 	if ctx == nil {
 		ctx = model.Anonymous
 	}
-	conn, err := redis.Dial("tcp", r.Address)
+	conn, release, err := r.dial(ctx.Context())
 	if err != nil {
 		return http.StatusInternalServerError, nil, err
 	}
-	defer conn.Close()
+	defer release()
 	var result map[string]string
 	result, err = redis.StringMap(conn.Do("HGETALL", id))
 	if err != nil {
 		return http.StatusInternalServerError, nil, err
 	}
+	if len(result) == 0 {
+		return http.StatusNotFound, nil, fmt.Errorf("object %s not found", id)
+	}
 	read := result["_read"]
 	groups := strings.Split(read, ",")
 	if !ctx.MemberOf(groups...) {
@@ -223,52 +303,83 @@ func (r *RedisearchHandler) Delete(ctx *model.Context, index, id string, documen
 	if ctx == nil {
 		ctx = model.Anonymous
 	}
-	conn, err := redis.Dial("tcp", r.Address)
+	conn, release, err := r.dial(ctx.Context())
 	if err != nil {
 		return false, err
 	}
-	defer conn.Close()
+	defer release()
+	var groups string
+	if groups, err = redis.String(conn.Do("HGET", id, "_delete")); err == redis.ErrNil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !ctx.MemberOf(strings.Split(groups, ",")...) {
+		return false, model.ErrAccessDenied
+	}
 	args := redis.Args{strings.ToLower(index), id}
 	if document {
 		args = append(args, "DD")
 	}
-	return redis.Bool(conn.Do("FT.SEARCH", args...))
+	return redis.Bool(conn.Do("FT.DEL", args...))
 }
 
+// searchBatch is the number of objects fetched at once by searches
+// without limit.
+const searchBatch = 1000
+
+// Search returns num objects from offset, all of them if num is negative.
+// The objects are limited to those readable by ctx unless it is
+// privileged.
 func (r *RedisearchHandler) Search(ctx *model.Context, index, query, sort string, offset, num int) ([]map[string]string, int, error) {
 	if ctx == nil {
 		ctx = model.Anonymous
 	}
-	conn, err := redis.Dial("tcp", r.Address)
+	conn, release, err := r.dial(ctx.Context())
 	if err != nil {
 		return nil, 0, err
 	}
-	defer conn.Close()
+	defer release()
 
 	var buf bytes.Buffer
 	buf.WriteString(query)
-	buf.WriteString(" @_read:{")
-	buf.WriteString(ctx.GroupsString())
-	buf.WriteString("}")
+	if !ctx.Privileged() {
+		buf.WriteString(" @_read:{")
+		buf.WriteString(ctx.GroupsString())
+		buf.WriteString("}")
+	}
 
-	var values []interface{}
-	args := redis.Args{strings.ToLower(index), buf.String(), "LIMIT", offset, num}
+	var order []interface{}
 	if sort != "" {
-		asc := !strings.HasPrefix(sort, "-")
-		var order string
-		if asc {
-			if strings.HasPrefix(sort, "+") {
-				sort = sort[1:]
-			}
-			order = "ASC"
+		if strings.HasPrefix(sort, "-") {
+			order = []interface{}{"SORTBY", sort[1:], "DESC"}
 		} else {
-			sort = sort[1:]
-			order = "DESC"
+			order = []interface{}{"SORTBY", strings.TrimPrefix(sort, "+"), "ASC"}
 		}
-		args = append(args, "SORTBY", sort, order)
-
 	}
-	values, err = redis.Values(conn.Do("FT.SEARCH", args...))
+	limit := num
+	if num < 0 {
+		limit = searchBatch
+	}
+	var result []map[string]string
+	for {
+		args := redis.Args{strings.ToLower(index), buf.String(), "LIMIT", offset, limit}
+		page, total, err := r.searchPage(conn, append(args, order...))
+		if err != nil {
+			return nil, 0, err
+		}
+		result = append(result, page...)
+		offset += len(page)
+		if num >= 0 || len(page) == 0 || offset >= total {
+			return result, total, nil
+		}
+	}
+}
+
+// searchPage returns the objects of a single FT.SEARCH and the number of
+// all matching objects.
+func (r *RedisearchHandler) searchPage(conn redis.Conn, args redis.Args) ([]map[string]string, int, error) {
+	values, err := redis.Values(conn.Do("FT.SEARCH", args...))
 	if err != nil {
 		return nil, 0, err
 	}
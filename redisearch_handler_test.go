@@ -0,0 +1,182 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuxsig/brot/model"
+	"github.com/fuxsig/brot/model/modeltest"
+	"github.com/garyburd/redigo/redis"
+)
+
+// TestRedisearchConformance runs the model suite against the RediSearch
+// server BROT_REDISEARCH_ADDRESS. The database is flushed before every test.
+func TestRedisearchConformance(t *testing.T) {
+	address := os.Getenv("BROT_REDISEARCH_ADDRESS")
+	if address == "" {
+		t.Skip("no RediSearch server configured")
+	}
+	modeltest.Run(t, func(t *testing.T) model.Connection {
+		conn, err := redis.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err = conn.Do("FLUSHALL"); err != nil {
+			t.Fatal(err)
+		}
+		handler := &RedisearchHandler{Address: address}
+		if err = handler.InitFunc(); err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	})
+}
+
+// TestRedisearchDial checks the connection pool against a server answering
+// PING with PONG and ignoring all other commands.
+func TestRedisearchDial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if strings.EqualFold(strings.TrimSpace(line), "PING") {
+						c.Write([]byte("+PONG\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	handler := &RedisearchHandler{Address: listener.Addr().String(), idle: make(chan *redisConn, maxIdleRedisConns)}
+	do := func(ctx context.Context, cmd string) (interface{}, error) {
+		conn, release, err := handler.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return conn.Do(cmd)
+	}
+	if reply, err := redis.String(do(context.Background(), "PING")); err != nil || reply != "PONG" {
+		t.Fatalf("expected PONG, found %q %v", reply, err)
+	}
+	if len(handler.idle) != 1 {
+		t.Fatalf("expected an idle connection, found %d", len(handler.idle))
+	}
+	// the deadline of the context ends commands without reply
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = do(ctx, "SLOW"); err == nil {
+		t.Error("expected a timeout")
+	}
+	if len(handler.idle) != 0 {
+		t.Errorf("expected the failed connection to be closed, found %d idle", len(handler.idle))
+	}
+	if reply, err := redis.String(do(context.Background(), "PING")); err != nil || reply != "PONG" {
+		t.Errorf("expected PONG after the timeout, found %q %v", reply, err)
+	}
+}
+
+// TestRedisearchSearchAll checks that a search without limit is paged by a
+// server holding 2500 objects, which answers FT.SEARCH with LIMIT only.
+func TestRedisearchSearchAll(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	const objects = 2500
+	queries := make(chan string, 10)
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		for {
+			args, err := readCommand(r)
+			if err != nil {
+				return
+			}
+			// FT.SEARCH index query LIMIT offset num
+			queries <- args[2]
+			offset, _ := strconv.Atoi(args[4])
+			num, _ := strconv.Atoi(args[5])
+			if num < 0 {
+				c.Write([]byte("-ERR invalid limit\r\n"))
+				continue
+			}
+			var reply bytes.Buffer
+			var ids []int
+			for i := offset; i < offset+num && i < objects; i++ {
+				ids = append(ids, i)
+			}
+			fmt.Fprintf(&reply, "*%d\r\n:%d\r\n", 1+2*len(ids), objects)
+			for _, i := range ids {
+				id := "brot:" + strconv.Itoa(i)
+				fmt.Fprintf(&reply, "$%d\r\n%s\r\n*2\r\n$3\r\n_id\r\n$%d\r\n%s\r\n", len(id), id, len(id), id)
+			}
+			c.Write(reply.Bytes())
+		}
+	}()
+	handler := &RedisearchHandler{Address: listener.Addr().String(), idle: make(chan *redisConn, maxIdleRedisConns)}
+	result, total, err := handler.Search(model.NewContext("ada", "all"), "posts", "*", "", 10, -1)
+	if err != nil || total != objects || len(result) != objects-10 || result[0]["_id"] != "brot:10" {
+		t.Fatalf("expected %d objects, found %d of %d %v", objects-10, len(result), total, err)
+	}
+	for i := 0; i < 3; i++ {
+		if query := <-queries; query != "* @_read:{all}" {
+			t.Errorf("unexpected query %q", query)
+		}
+	}
+}
+
+// readCommand reads a command of the redis protocol.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		if args[i], err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(args[i], "\r\n")
+	}
+	return args, nil
+}
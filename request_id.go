@@ -0,0 +1,131 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"context"
+	cr "crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/wrapper"
+)
+
+// RequestIDHeader is the default header carrying the request id.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDWrapper assigns an id to every request. A valid id sent by the
+// client is kept unless Overwrite is set, otherwise a random id is
+// generated. The id is stored in the request context, added to all log
+// records of the request and echoed in the response header.
+type RequestIDWrapper struct {
+	Header    string `brot:"header"`
+	Overwrite bool   `brot:"overwrite"`
+}
+
+func (rw *RequestIDWrapper) InitFunc() (err error) {
+	if rw.Header == "" {
+		rw.Header = RequestIDHeader
+	}
+	return
+}
+
+func (rw *RequestIDWrapper) Retry() bool {
+	return false
+}
+
+func (rw *RequestIDWrapper) ServeChain(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(rw.Header)
+	if rw.Overwrite || !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(rw.Header, id)
+	next(w, r.WithContext(withRequestID(r.Context(), id, rw.Header)))
+}
+
+func (rw *RequestIDWrapper) WrapperFunc() func(http.Handler) http.Handler {
+	return wrapper.Middleware(rw)
+}
+
+// validRequestID accepts ids of up to 128 printable ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	cr.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDValue is the request id stored in a context with the header
+// forwarding it. An empty value is reserved by an outer wrapper and filled
+// by the RequestIDWrapper behind it, so that the outer wrapper can read the
+// id after the request.
+type requestIDValue struct {
+	id     string
+	header string
+}
+
+// WithRequestID returns a copy of ctx carrying the request id, which is
+// forwarded in RequestIDHeader.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return withRequestID(ctx, id, RequestIDHeader)
+}
+
+func withRequestID(ctx context.Context, id, header string) context.Context {
+	if reserved, ok := ctx.Value(requestIDKey).(*requestIDValue); ok && reserved.id == "" {
+		reserved.id, reserved.header = id, header
+	} else {
+		ctx = context.WithValue(ctx, requestIDKey, &requestIDValue{id: id, header: header})
+	}
+	return WithLogAttrs(ctx, slog.String("request_id", id))
+}
+
+// reserveRequestID returns a copy of ctx in which the request id assigned
+// later on is visible, see requestIDValue.
+func reserveRequestID(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(requestIDKey).(*requestIDValue); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey, &requestIDValue{})
+}
+
+// RequestID returns the request id stored in ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	if value, ok := ctx.Value(requestIDKey).(*requestIDValue); ok {
+		return value.id
+	}
+	return ""
+}
+
+// newRequest creates an outbound request bound to ctx, which forwards the
+// request id of ctx in the header of the RequestIDWrapper assigning it.
+func newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := ctx.Value(requestIDKey).(*requestIDValue); ok && value.id != "" {
+		req.Header.Set(value.header, value.id)
+	}
+	return req, nil
+}
+
+var _ di.ProvidesInit = (*RequestIDWrapper)(nil)
+var _ wrapper.Handler = (*RequestIDWrapper)(nil)
+var _ ProvidesWrapper = (*RequestIDWrapper)(nil)
+var _ = di.GlobalScope.Declare((*RequestIDWrapper)(nil))
@@ -0,0 +1,151 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuxsig/brot/model"
+)
+
+func TestRequestIDWrapper(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	tables := []struct {
+		header    string
+		overwrite bool
+		sent      string
+		kept      bool
+	}{
+		{"", false, "", false},
+		{"", false, "abc-123", true},
+		{"", true, "abc-123", false},
+		{"", false, "has space", false},
+		{"", false, strings.Repeat("a", 129), false},
+		{"X-Trace", false, "trace-1", true},
+	}
+	for _, table := range tables {
+		rw := &RequestIDWrapper{Header: table.header, Overwrite: table.overwrite}
+		if err := rw.InitFunc(); err != nil {
+			t.Fatal(err)
+		}
+		var seen string
+		var values map[string][]string
+		handler := rw.WrapperFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = RequestID(r.Context())
+			values = provided(&ContextProvider{Mapping: map[string]string{"requestId": "rid"}}, r)
+		}))
+		r := httptest.NewRequest("GET", "/", nil)
+		if table.sent != "" {
+			r.Header.Set(rw.Header, table.sent)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		echoed := w.Header().Get(rw.Header)
+		if echoed != seen || len(values["rid"]) != 1 || values["rid"][0] != seen {
+			t.Errorf("%q: expected echoed id %q, found %q and values %v", table.sent, seen, echoed, values)
+		}
+		if table.kept && seen != table.sent || !table.kept && !generated.MatchString(seen) {
+			t.Errorf("%q: unexpected id %q", table.sent, seen)
+		}
+	}
+}
+
+// provided returns the values p provides for r.
+func provided(p ValueProvider, r *http.Request) map[string][]string {
+	m := make(map[string][]string)
+	p.Initialize(r, m)
+	return m
+}
+
+func TestNewRequest(t *testing.T) {
+	var forwarded string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(RequestIDHeader)
+	}))
+	defer server.Close()
+
+	ctx := WithRequestID(context.Background(), "abc-123")
+	req, err := newRequest(ctx, "GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = http.DefaultClient.Do(req); err != nil || forwarded != "abc-123" {
+		t.Errorf("expected forwarded id, found %q (%v)", forwarded, err)
+	}
+	if req, _ = newRequest(context.Background(), "GET", server.URL, nil); req.Header.Get(RequestIDHeader) != "" {
+		t.Error("expected no id without id in context")
+	}
+	// the id is forwarded in the header of the wrapper
+	rw := &RequestIDWrapper{Header: "X-Trace-ID"}
+	if err = rw.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	inbound := httptest.NewRequest("GET", "/", nil)
+	inbound.Header.Set("X-Trace-ID", "trace-1")
+	rw.ServeChain(httptest.NewRecorder(), inbound, func(w http.ResponseWriter, r *http.Request) {
+		req, _ = newRequest(r.Context(), "GET", server.URL, nil)
+	})
+	if req.Header.Get("X-Trace-ID") != "trace-1" || req.Header.Get(RequestIDHeader) != "" {
+		t.Errorf("expected the id in X-Trace-ID, found %v", req.Header)
+	}
+
+	// outbound requests end with the inbound request
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	req, _ = newRequest(cancelled, "GET", server.URL, nil)
+	if _, err = http.DefaultClient.Do(req); err == nil {
+		t.Error("expected error of cancelled request")
+	}
+}
+
+func TestContextPropagation(t *testing.T) {
+	butter := &model.Butter{
+		Conn:              &model.Memory{},
+		ConfiguredSchemas: []*model.Schema{{Name: "Post", Elements: []*model.Element{{Name: "title", Type: "string"}}}},
+	}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	post := map[string]string{"_schema": "Post", "title": "Hello"}
+	if err := butter.Save(nil, post); err != nil {
+		t.Fatal(err)
+	}
+	rh := &RestHandler{
+		Model: butter,
+		Data: &DataLayer{Providers: []ValueProvider{&URLParameterProvider{
+			Mapping: map[string]string{"id": "id", "schema": "schema"}}}},
+	}
+	if err := rh.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	handler := rh.HandlerFunc()
+	target := "/posts/" + post["_id"] + "?id=" + post["_id"]
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil).WithContext(expired))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504 after deadline, found %d %s", w.Code, w.Body.String())
+	}
+
+	// a client which went away gets no response
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil).WithContext(cancelled))
+	if w.Body.Len() != 0 || w.Result().Header.Get("Content-Type") != "" {
+		t.Errorf("expected nothing written, found %d %s", w.Code, w.Body.String())
+	}
+	if status, _, err := butter.Load(model.Anonymous.WithContext(cancelled), post["_id"]); status != model.StatusClientClosedRequest || err == nil {
+		t.Errorf("expected %d, found %d %v", model.StatusClientClosedRequest, status, err)
+	}
+}
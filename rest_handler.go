@@ -6,103 +6,513 @@ package brot
 
 import (
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/fuxsig/brot/di"
 	"github.com/fuxsig/brot/model"
+	"github.com/gorilla/mux"
 )
 
+// RestHandler returns the object of the id value or lists the objects of
+// the schema value. Results are rendered by Views with the view named by
+// the view value or else by the schema, see ViewRegistry.Render. Lists are
+// paged by the offset and limit values, which default to 0 and 10, and
+// ordered by the sort value. InitFunc adds rules for them and the fields
+// and expand values described below to Data and reads them from query
+// parameters of the same name, unless Data provides them.
+//
+// Objects are created by a POST of a JSON object to the schema, which
+// answers 201 with the Location of the new object. The Location is the URL
+// of the route ItemRoute of the router named Router, built with the
+// variables of the current route and the new id as id variable. Without
+// ItemRoute the id is appended to the request path. PUT replaces the object
+// of the id value, elements missing in the body are unset unless they are
+// immutable. PATCH applies a JSON merge patch, null unsets an element.
+// DELETE removes the object.
+// Values are checked with Schema.CheckValue and by Butter.Save with
+// Schema.Check, violations are returned as field errors of a
+// model.ValidationError with status 400.
+//
+// JSON results are typed by the schema, see Schema.MarshalObject. The
+// fields value limits objects to the comma separated fields. The expand
+// value lists pointers like author,tags or author.company, whose objects
+// are embedded for the current user, see Butter.Expand. ExpandDepth limits
+// the length of these paths and defaults to 2. Templates get the objects
+// as maps.
 type RestHandler struct {
-	Model *model.Butter `brot:"model"`
-	Data  *DataLayer    `brot:"data"`
+	Model       *model.Butter `brot:"model"`
+	Data        *DataLayer    `brot:"data"`
+	Views       *Views        `brot:"views"`
+	ExpandDepth int           `brot:"expandDepth"`
+	Router      string        `brot:"router"`
+	ItemRoute   string        `brot:"itemRoute"`
 }
 
+func (h *RestHandler) InitFunc() error {
+	if h.ItemRoute != "" && h.Router == "" {
+		return fmt.Errorf("item route %s needs a router", h.ItemRoute)
+	}
+	zero, one := 0.0, 1.0
+	// query parameters are read for compatibility with older
+	// configurations, configured providers take precedence
+	query := &URLParameterProvider{Mapping: map[string]string{
+		"offset": "offset", "limit": "limit", "sort": "sort", "fields": "fields", "expand": "expand"}}
+	h.Data = h.Data.extend(map[string]*ValueRule{
+		"offset": {Type: IntValue, Min: &zero, Default: "0", MaxCount: 1},
+		"limit":  {Type: IntValue, Min: &one, Default: "10", MaxCount: 1},
+		"sort":   {MaxCount: 1},
+		"fields": {MaxCount: 1},
+		"expand": {MaxCount: 1},
+	}, query)
+	for name := range query.Mapping {
+		if _, ok := h.Data.Precedence[name]; !ok {
+			h.Data.Precedence[name] = FirstValues
+		}
+	}
+	return h.Data.InitFunc()
+}
+
+func (h *RestHandler) Retry() bool {
+	return false
+}
+
+// location returns the URL of the object id created by r.
+func (h *RestHandler) location(r *http.Request, id string) (string, error) {
+	if h.ItemRoute == "" {
+		return strings.TrimSuffix(r.URL.Path, "/") + "/" + url.PathEscape(id), nil
+	}
+	// the router is registered by its InitFunc, usually after the handler
+	router, _ := di.GlobalScope.Get(h.Router).(*mux.Router)
+	if router == nil {
+		return "", fmt.Errorf("router %s does not exist", h.Router)
+	}
+	route := router.Get(h.ItemRoute)
+	if route == nil {
+		return "", fmt.Errorf("route %s does not exist", h.ItemRoute)
+	}
+	pairs := []string{"id", id}
+	for name, value := range mux.Vars(r) {
+		if name != "id" {
+			pairs = append(pairs, name, value)
+		}
+	}
+	u, err := route.URL(pairs...)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// defaultExpandDepth limits expansions without configured depth.
+const defaultExpandDepth = 2
+
+// restList is the result of a list request.
+type restList struct {
+	Offset   int                 `json:"offset"`
+	Number   int                 `json:"number"`
+	Total    int                 `json:"total"`
+	Objects  []map[string]string `json:"objects"`
+	schema   *model.Schema
+	fields   []string
+	expanded []map[string]interface{}
+}
+
+// MarshalJSON encodes the objects with Schema.MarshalObject or the
+// expanded objects.
+func (l *restList) MarshalJSON() ([]byte, error) {
+	objects := make([]json.RawMessage, len(l.Objects))
+	for i, obj := range l.Objects {
+		var err error
+		if l.expanded != nil {
+			objects[i], err = json.Marshal(project(l.expanded[i], l.fields))
+		} else {
+			objects[i], err = l.schema.MarshalObject(obj, l.fields...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(&struct {
+		Offset  int               `json:"offset"`
+		Number  int               `json:"number"`
+		Total   int               `json:"total"`
+		Objects []json.RawMessage `json:"objects"`
+	}{l.Offset, l.Number, l.Total, objects})
+}
+
+// restObject is the result of an item request. Templates get the object,
+// JSON is encoded with Schema.MarshalObject.
+type restObject struct {
+	data     map[string]string
+	schema   *model.Schema
+	fields   []string
+	expanded map[string]interface{}
+}
+
+func (o *restObject) MarshalJSON() ([]byte, error) {
+	if o.expanded != nil {
+		return json.Marshal(project(o.expanded, o.fields))
+	}
+	return o.schema.MarshalObject(o.data, o.fields...)
+}
+
+func (o *restObject) ViewData() interface{} {
+	return o.data
+}
+
+// project returns the given fields of value, all without fields.
+func project(value map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return value
+	}
+	result := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if v, ok := value[field]; ok {
+			result[field] = v
+		}
+	}
+	return result
+}
+
+// expand returns the objects with the pointers of the expand value
+// embedded, nil without expand value. Invalid paths are returned as
+// ValidationError with status 400.
+func (h *RestHandler) expand(values MultiValueMap, r *http.Request, objects []map[string]string) ([]map[string]interface{}, int, error) {
+	paths := listParameter(values, "expand")
+	if len(paths) == 0 {
+		return nil, http.StatusOK, nil
+	}
+	depth := h.ExpandDepth
+	if depth <= 0 {
+		depth = defaultExpandDepth
+	}
+	expanded, err := h.Model.Expand(modelContext(r), objects, paths, depth)
+	if errors.Is(err, model.ErrInvalidExpansion) {
+		var ve model.ValidationError
+		ve.Add("expand", model.ValueViolation, "%s", err.Error())
+		return nil, http.StatusBadRequest, &ve
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return expanded, http.StatusOK, nil
+}
+
+// render renders the object data with status.
+func (h *RestHandler) render(w http.ResponseWriter, r *http.Request, values MultiValueMap, view string, status int, data map[string]string) {
+	obj := &restObject{data: data, schema: h.Model.Schema(data["_schema"]), fields: listParameter(values, "fields")}
+	expanded, failed, err := h.expand(values, r, []map[string]string{data})
+	if err != nil {
+		Error(w, r, failed, err)
+		return
+	}
+	if expanded != nil {
+		obj.expanded = expanded[0]
+	}
+	h.Views.Render(w, r, view, status, obj)
+}
+
+// maxRestBody limits the size of request bodies.
+const maxRestBody = 1 << 20
+
 func (h *RestHandler) HandlerFunc() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		values := h.Data.Values(r)
-		query := r.URL.Query()
-		var (
-			err  error
-			str  []string
-			sort string
-		)
+		values, err := h.Data.Parse(r)
+		if err != nil {
+			Error(w, r, http.StatusBadRequest, err)
+			return
+		}
 		id, ok := values.Get("id")
+		switch {
+		case r.Method == http.MethodPost && !ok:
+			h.create(w, r, values)
+			return
+		case (r.Method == http.MethodPut || r.Method == http.MethodPatch) && ok:
+			h.update(w, r, values, id)
+			return
+		case r.Method == http.MethodDelete && ok:
+			h.delete(w, r, id)
+			return
+		case r.Method != http.MethodGet && r.Method != http.MethodHead:
+			if ok {
+				w.Header().Set("Allow", "GET, HEAD, PUT, PATCH, DELETE")
+			} else {
+				w.Header().Set("Allow", "GET, HEAD, POST")
+			}
+			Error(w, r, http.StatusMethodNotAllowed, nil)
+			return
+		}
 		if !ok {
 			var schema string
 			if schema, ok = values.Get("schema"); !ok {
-				http.Error(w, "schema missing", http.StatusBadRequest)
+				Error(w, r, http.StatusBadRequest, errors.New("schema missing"))
 				return
 			}
 
-			offset := 0
-			limit := 10
-			if str, ok = query["offset"]; ok {
-				if offset, err = strconv.Atoi(str[0]); err != nil {
-					http.Error(w, "invalid offset", http.StatusBadRequest)
-					return
-				}
+			offset, _ := values.Int("offset")
+			limit, _ := values.Int("limit")
+			sort, _ := values.Get("sort")
+			result, total, err := h.Model.Search(modelContext(r), schema, "", sort, offset, limit)
+			if err != nil {
+				Error(w, r, http.StatusInternalServerError, err)
+				return
 			}
-			if str, ok = query["limit"]; ok {
-				if limit, err = strconv.Atoi(str[0]); err != nil {
-					http.Error(w, "invalid limit", http.StatusBadRequest)
-					return
-				}
+			if result == nil {
+				result = []map[string]string{}
 			}
-			if str, ok = query["sort"]; ok {
-				sort = str[0]
+			view, ok := values.Get("view")
+			if !ok {
+				view = schema
 			}
-
-			result, total, err := h.Model.Search(nil, schema, "", sort, offset, limit)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			list := &restList{Offset: offset, Number: len(result), Total: total, Objects: result,
+				schema: h.Model.Schema(schema), fields: listParameter(values, "fields")}
+			var status int
+			if list.expanded, status, err = h.expand(values, r, result); err != nil {
+				Error(w, r, status, err)
 				return
 			}
-			i := len(result)
-			w.WriteHeader(http.StatusOK)
-			w.Header().Set("Content-Type", "application/json")
-
-			io.WriteString(w, `{"offset":`)
-			io.WriteString(w, strconv.Itoa(offset))
-			io.WriteString(w, `,"number":`)
-			io.WriteString(w, strconv.Itoa(i))
-			io.WriteString(w, `,"total":`)
-			io.WriteString(w, strconv.Itoa(total))
-			io.WriteString(w, `,"objects":[`)
-			var j []byte
-			for _, current := range result {
-				if j, err = json.Marshal(current); err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				w.Write(j)
-				i--
-				if i > 0 {
-					io.WriteString(w, ",")
-				}
-			}
-			io.WriteString(w, "]}")
-
+			h.Views.Render(w, r, view, http.StatusOK, list)
 			return
 		}
 
-		status, data, err := h.Model.Load(nil, id)
+		status, data, err := h.Model.Load(modelContext(r), id)
 		if err != nil {
-			http.Error(w, err.Error(), status)
+			Error(w, r, status, err)
 			return
 		}
-		var j []byte
-		if j, err = json.Marshal(data); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		view, ok := values.Get("view")
+		if !ok {
+			view = data["_schema"]
 		}
-		w.WriteHeader(status)
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(j)
-
+		h.render(w, r, values, view, status, data)
 	})
 }
 
+// create saves a new object of the schema value.
+func (h *RestHandler) create(w http.ResponseWriter, r *http.Request, values MultiValueMap) {
+	name, ok := values.Get("schema")
+	if !ok {
+		Error(w, r, http.StatusBadRequest, errors.New("schema missing"))
+		return
+	}
+	schema := h.Model.Schema(name)
+	if schema == nil {
+		Error(w, r, http.StatusNotFound, fmt.Errorf("schema %s is not registered", name))
+		return
+	}
+	data, links, status, err := decodeObject(r, false)
+	if err == nil {
+		status, err = http.StatusBadRequest, checkObject(schema, data, links)
+	}
+	if err != nil {
+		Error(w, r, status, err)
+		return
+	}
+	delete(data, "_id")
+	data["_schema"] = schema.Name
+	if err = h.Model.Save(modelContext(r), data); err != nil {
+		Error(w, r, saveStatus(err), err)
+		return
+	}
+	if location, err := h.location(r, data["_id"]); err == nil {
+		w.Header().Set("Location", location)
+	} else {
+		logger("rest").WarnContext(r.Context(), "could not build location", "id", data["_id"], "error", err)
+	}
+	view, ok := values.Get("view")
+	if !ok {
+		view = schema.Name
+	}
+	h.render(w, r, values, view, http.StatusCreated, data)
+}
+
+// update replaces or patches the object id.
+func (h *RestHandler) update(w http.ResponseWriter, r *http.Request, values MultiValueMap, id string) {
+	ctx := modelContext(r)
+	status, current, err := h.Model.Load(ctx, id)
+	if err != nil {
+		Error(w, r, status, err)
+		return
+	}
+	schema := h.Model.Schema(current["_schema"])
+	if schema == nil {
+		Error(w, r, http.StatusInternalServerError, fmt.Errorf("schema %s of %s is not registered", current["_schema"], id))
+		return
+	}
+	patch := r.Method == http.MethodPatch
+	data, links, status, err := decodeObject(r, patch)
+	if err == nil {
+		status, err = http.StatusBadRequest, checkObject(schema, data, links)
+	}
+	if err != nil {
+		Error(w, r, status, err)
+		return
+	}
+	if !patch {
+		// a replacement unsets all elements missing in the body except
+		// the immutable ones
+		for _, e := range schema.Elements {
+			if _, ok := data[e.Name]; !ok && !e.Immutable {
+				data[e.Name] = ""
+			}
+		}
+		if _, ok := data["_label"]; !ok {
+			data["_label"] = ""
+		}
+	}
+	data["_id"] = id
+	data["_schema"] = schema.Name
+	if err = h.Model.Save(ctx, data); err != nil {
+		Error(w, r, saveStatus(err), err)
+		return
+	}
+	if status, current, err = h.Model.Load(ctx, id); err != nil {
+		Error(w, r, status, err)
+		return
+	}
+	view, ok := values.Get("view")
+	if !ok {
+		view = schema.Name
+	}
+	h.render(w, r, values, view, http.StatusOK, current)
+}
+
+// delete removes the object id.
+func (h *RestHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	if status, err := h.Model.Delete(modelContext(r), id); err != nil {
+		Error(w, r, status, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveStatus returns the status of an error returned by Butter.Save.
+func saveStatus(err error) int {
+	var ve *model.ValidationError
+	switch {
+	case errors.Is(err, model.ErrAccessDenied):
+		return http.StatusForbidden
+	case errors.As(err, &ve):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// decodeObject reads the JSON object of the body as model object. Numbers
+// and booleans are formatted, arrays are joined by commas and null becomes
+// the empty value. Links to objects are replaced by their id, the keys of
+// the links are returned as well. A patch accepts the merge patch media
+// type.
+func decodeObject(r *http.Request, patch bool) (map[string]string, map[string]bool, int, error) {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != "application/json" && (!patch || mt != "application/merge-patch+json") {
+		return nil, nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %s", r.Header.Get("Content-Type"))
+	}
+	var body map[string]interface{}
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRestBody))
+	decoder.UseNumber()
+	if err = decoder.Decode(&body); err != nil {
+		return nil, nil, http.StatusBadRequest, fmt.Errorf("invalid JSON object: %s", err.Error())
+	}
+	var ve model.ValidationError
+	result := make(map[string]string, len(body))
+	links := make(map[string]bool)
+	for key, value := range body {
+		ok, link := true, false
+		if list, isList := value.([]interface{}); isList {
+			parts := make([]string, len(list))
+			for i := 0; i < len(list) && ok; i++ {
+				// lists of lists or with null cannot be stored
+				var current bool
+				parts[i], current, ok = jsonScalar(list[i])
+				ok = ok && list[i] != nil
+				link = link || current
+			}
+			result[key] = strings.Join(parts, ",")
+		} else {
+			result[key], link, ok = jsonScalar(value)
+		}
+		if !ok {
+			ve.Add(key, model.TypeViolation, "%s must be a string, number, boolean or list of them", key)
+		}
+		if link {
+			links[key] = true
+		}
+	}
+	if ve.Fields != nil {
+		return nil, nil, http.StatusBadRequest, &ve
+	}
+	return result, links, http.StatusOK, nil
+}
+
+// jsonScalar formats a scalar JSON value. Link reports a link to an object
+// as returned for pointers, which is replaced by its id.
+func jsonScalar(value interface{}) (str string, link, ok bool) {
+	switch value := value.(type) {
+	case nil:
+		return "", false, true
+	case string:
+		return value, false, true
+	case json.Number:
+		return value.String(), false, true
+	case bool:
+		return strconv.FormatBool(value), false, true
+	case map[string]interface{}:
+		if id, ok := value["_id"].(string); ok {
+			return id, true, true
+		}
+	}
+	return "", false, false
+}
+
+// checkObject checks all values of data with schema. The _id and _schema
+// values are managed by the handler and must match if given. Links are
+// only accepted for pointers, not for meta values like _read.
+func checkObject(schema *model.Schema, data map[string]string, links map[string]bool) error {
+	var ve model.ValidationError
+	for key, value := range data {
+		switch key {
+		case "_id":
+			continue
+		case "_schema":
+			if value != schema.Name {
+				ve.Add(key, model.ValueViolation, "_schema must be %s", schema.Name)
+			}
+			continue
+		}
+		if e := schema.Element(key); links[key] && (e == nil || !e.Pointer()) {
+			ve.Add(key, model.TypeViolation, "%s must be a string, number, boolean or list of them", key)
+			continue
+		}
+		ve.Fields = append(ve.Fields, fieldErrors(schema.CheckValue(key, value))...)
+	}
+	if ve.Fields != nil {
+		sort.Slice(ve.Fields, func(i, j int) bool { return ve.Fields[i].Field < ve.Fields[j].Field })
+		return &ve
+	}
+	return nil
+}
+
+// listParameter returns the comma separated values of the value name like
+// fields or expand.
+func listParameter(values MultiValueMap, name string) []string {
+	str, _ := values.Get(name)
+	var result []string
+	for _, value := range strings.Split(str, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+var _ di.ProvidesInit = (*RestHandler)(nil)
 var _ ProvidesHandler = (*RestHandler)(nil)
 var _ = di.GlobalScope.Declare((*RestHandler)(nil))
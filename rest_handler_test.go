@@ -0,0 +1,244 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/model"
+	"github.com/gorilla/mux"
+)
+
+func TestRestHandlerCRUD(t *testing.T) {
+	conn := &model.Memory{}
+	butter := &model.Butter{
+		Conn: conn,
+		ConfiguredSchemas: []*model.Schema{{Name: "Post", Elements: []*model.Element{
+			{Name: "title", Type: "string"},
+			{Name: "views", Type: "int"},
+			{Name: "tags", Type: "[]string"},
+		}}},
+	}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	rh := &RestHandler{
+		Model: butter,
+		Data: &DataLayer{Providers: []ValueProvider{&URLParameterProvider{
+			Mapping: map[string]string{"id": "id", "schema": "schema"}}}},
+	}
+	if err := rh.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	handler := rh.HandlerFunc()
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		var result map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("invalid body %s: %s", w.Body.String(), err)
+		}
+		return result
+	}
+
+	w := serve("POST", "/posts?schema=Post", "application/json", `{"title":"Hello","views":3,"tags":["go","web"]}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/posts/brot:1" {
+		t.Fatalf("expected 201 with location, found %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	if _, obj, _ := conn.Load(nil, "brot:1"); obj["views"] != "3" || obj["tags"] != "go,web" || obj["_schema"] != "Post" {
+		t.Errorf("unexpected object %v", obj)
+	}
+	if obj := decode(w); obj["views"] != 3.0 || !reflect.DeepEqual(obj["tags"], []interface{}{"go", "web"}) {
+		t.Errorf("expected typed JSON, found %s", w.Body.String())
+	}
+	// only admins may change the locked post, so that anonymous requests
+	// are denied by the connection like any other write without access
+	locked := map[string]string{"_schema": "Post", "title": "Locked", "_write": "admins"}
+	if err := conn.Save(nil, locked, butter.Schema("Post")); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve("POST", "/posts?schema=Post", "application/json", `{"title":"Hello","views":"many","size":1,"_schema":"Page"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, found %d", w.Code)
+	}
+	var fields []string
+	for _, field := range decode(w)["fields"].([]interface{}) {
+		fields = append(fields, field.(map[string]interface{})["field"].(string))
+	}
+	if strings.Join(fields, ",") != "_schema,size,views" {
+		t.Errorf("expected field errors of _schema, size and views, found %v", fields)
+	}
+
+	w = serve("PATCH", "/posts/brot:1?id=brot:1", "application/merge-patch+json", `{"views":null,"title":"Patched"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, found %d %s", w.Code, w.Body.String())
+	}
+	if obj := decode(w); obj["title"] != "Patched" || obj["views"] != nil || !reflect.DeepEqual(obj["tags"], []interface{}{"go", "web"}) {
+		t.Errorf("unexpected patched object %v", obj)
+	}
+
+	w = serve("GET", "/posts/brot:1?id=brot:1&fields=title,views", "", "")
+	if obj := decode(w); len(obj) != 1 || obj["title"] != "Patched" {
+		t.Errorf("expected projected object, found %s", w.Body.String())
+	}
+	w = serve("GET", "/posts?schema=Post&fields=title", "", "")
+	if list := decode(w); list["total"] != 2.0 || !strings.Contains(w.Body.String(), `"objects":[{"title":"Patched"},{"title":"Locked"}]`) {
+		t.Errorf("unexpected list %s", w.Body.String())
+	}
+	w = serve("GET", "/posts?schema=Post&fields=title&offset=1&limit=01", "", "")
+	if list := decode(w); list["offset"] != 1.0 || list["number"] != 1.0 || !strings.Contains(w.Body.String(), `"objects":[{"title":"Locked"}]`) {
+		t.Errorf("unexpected page %s", w.Body.String())
+	}
+
+	w = serve("PUT", "/posts/brot:1?id=brot:1", "application/json", `{"title":"Replaced"}`)
+	if obj := decode(w); w.Code != http.StatusOK || obj["title"] != "Replaced" || obj["tags"] != nil {
+		t.Errorf("unexpected replaced object %d %v", w.Code, obj)
+	}
+
+	tables := []struct {
+		method, target, contentType, body string
+		status                            int
+	}{
+		{"PUT", "/posts/x?id=x", "application/json", `{}`, http.StatusNotFound},
+		{"PUT", "/posts/brot:2?id=brot:2", "application/json", `{"title":"Open"}`, http.StatusForbidden},
+		{"PUT", "/posts/brot:1?id=brot:1", "text/plain", `title`, http.StatusUnsupportedMediaType},
+		{"PUT", "/posts/brot:1?id=brot:1", "application/merge-patch+json", `{}`, http.StatusUnsupportedMediaType},
+		{"PATCH", "/posts/brot:1?id=brot:1", "application/json", `{"title":{"a":1}}`, http.StatusBadRequest},
+		{"PATCH", "/posts/brot:1?id=brot:1", "application/json", `{"title":{"_id":"brot:2"}}`, http.StatusBadRequest},
+		{"PATCH", "/posts/brot:1?id=brot:1", "application/json", `{"tags":[{"_id":"brot:2"}]}`, http.StatusBadRequest},
+		{"PATCH", "/posts/brot:1?id=brot:1", "application/json", `{"_read":{"_id":"brot:2"}}`, http.StatusBadRequest},
+		{"PATCH", "/posts/brot:1?id=brot:1", "application/json", `[1]`, http.StatusBadRequest},
+		{"POST", "/posts/brot:1?id=brot:1", "application/json", `{}`, http.StatusMethodNotAllowed},
+		{"GET", "/posts?schema=Post&limit=0", "", ``, http.StatusBadRequest},
+		{"GET", "/posts?schema=Post&limit=ten", "", ``, http.StatusBadRequest},
+		{"GET", "/posts?schema=Post&offset=-1", "", ``, http.StatusBadRequest},
+		{"GET", "/posts?schema=Post&offset=1&offset=2", "", ``, http.StatusBadRequest},
+		{"DELETE", "/posts?schema=Post", "", ``, http.StatusMethodNotAllowed},
+		{"DELETE", "/posts/brot:1?id=brot:1", "", ``, http.StatusNoContent},
+		{"DELETE", "/posts/brot:1?id=brot:1", "", ``, http.StatusNotFound},
+	}
+	for _, table := range tables {
+		if w = serve(table.method, table.target, table.contentType, table.body); w.Code != table.status {
+			t.Errorf("%s %s %s: expected %d, found %d %s", table.method, table.target, table.body, table.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRestHandlerExpand(t *testing.T) {
+	conn := &model.Memory{}
+	butter := &model.Butter{
+		Conn: conn,
+		ConfiguredSchemas: []*model.Schema{
+			{Name: "Author", Elements: []*model.Element{{Name: "name", Type: "string"}}},
+			{Name: "Post", Elements: []*model.Element{
+				{Name: "title", Type: "string"},
+				{Name: "author", Type: "*Author"},
+			}},
+		},
+	}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	author := map[string]string{"_schema": "Author", "name": "Ada"}
+	if err := butter.Save(nil, author); err != nil {
+		t.Fatal(err)
+	}
+	post := map[string]string{"_schema": "Post", "title": "Notes", "author": author["_id"]}
+	if err := butter.Save(nil, post); err != nil {
+		t.Fatal(err)
+	}
+	rh := &RestHandler{
+		Model: butter,
+		Data: &DataLayer{Providers: []ValueProvider{&URLParameterProvider{
+			Mapping: map[string]string{"id": "id", "schema": "schema"}}}},
+		ExpandDepth: 1,
+	}
+	if err := rh.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	handler := rh.HandlerFunc()
+	tables := []struct {
+		target   string
+		status   int
+		expected string
+	}{
+		{"/posts?schema=Post&expand=author&fields=author", http.StatusOK, `"objects":[{"author":{"_created"`},
+		{"/posts/" + post["_id"] + "?id=" + post["_id"] + "&fields=author", http.StatusOK, `{"author":{"_id":"` + author["_id"] + `","_schema":"Author"}}`},
+		{"/posts/" + post["_id"] + "?id=" + post["_id"] + "&expand=author&fields=title", http.StatusOK, `{"title":"Notes"}`},
+		{"/posts/" + post["_id"] + "?id=" + post["_id"] + "&expand=author.name", http.StatusBadRequest, `"field":"expand"`},
+		{"/posts?schema=Post&expand=title", http.StatusBadRequest, `"field":"expand"`},
+	}
+	for _, table := range tables {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", table.target, nil))
+		if w.Code != table.status || !strings.Contains(w.Body.String(), table.expected) {
+			t.Errorf("%s: expected %d %s, found %d %s", table.target, table.status, table.expected, w.Code, w.Body.String())
+		}
+	}
+	// links of pointers are accepted as returned
+	r := httptest.NewRequest("PATCH", "/posts/"+post["_id"]+"?id="+post["_id"], strings.NewReader(`{"author":{"_id":"`+author["_id"]+`"}}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the link to be accepted, found %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/posts/"+post["_id"]+"?id="+post["_id"]+"&expand=author", nil))
+	var obj map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &obj); err != nil {
+		t.Fatal(err)
+	}
+	if embedded, ok := obj["author"].(map[string]interface{}); !ok || embedded["name"] != "Ada" {
+		t.Errorf("expected embedded author, found %s", w.Body.String())
+	}
+}
+
+func TestRestHandlerLocation(t *testing.T) {
+	butter := &model.Butter{
+		Conn:              &model.Memory{},
+		ConfiguredSchemas: []*model.Schema{{Name: "Post", Elements: []*model.Element{{Name: "title", Type: "string"}}}},
+	}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	rh := &RestHandler{
+		Model:     butter,
+		Data:      &DataLayer{Providers: []ValueProvider{&MuxVarsProvider{Mapping: map[string]string{"id": "id", "schema": "schema"}}}},
+		Router:    "testRestRouter",
+		ItemRoute: "item",
+	}
+	if err := rh.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.Handle("/api/{schema}", rh.HandlerFunc()).Methods("POST")
+	router.Handle("/api/{schema}/objects/{id}", rh.HandlerFunc()).Name("item")
+	di.GlobalScope.Set("testRestRouter", router)
+	r := httptest.NewRequest("POST", "/api/Post", strings.NewReader(`{"title":"Hello"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); w.Code != http.StatusCreated || !strings.HasPrefix(location, "/api/Post/objects/brot:") {
+		t.Fatalf("expected the location of the item route, found %d %q %s", w.Code, location, w.Body.String())
+	}
+	if (&RestHandler{ItemRoute: "item"}).InitFunc() == nil {
+		t.Error("expected an error for an item route without router")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/fuxsig/brot/di"
+)
+
+// RotatingFile is an io.Writer appending to the file at Path. If the file
+// grows beyond MaxSize megabytes it is renamed to Path.1, older files are
+// shifted up to Path.<MaxBackups> and then removed.
+type RotatingFile struct {
+	Path       string `brot:"path,mandatory"`
+	MaxSize    int    `brot:"maxSize"`
+	MaxBackups int    `brot:"maxBackups"`
+	file       *os.File
+	size       int64
+	mu         sync.Mutex
+}
+
+func (rf *RotatingFile) InitFunc() (err error) {
+	if rf.MaxSize <= 0 {
+		rf.MaxSize = 100
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) Retry() bool {
+	return false
+}
+
+func (rf *RotatingFile) open() (err error) {
+	if rf.file, err = os.OpenFile(rf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+		return
+	}
+	var info os.FileInfo
+	if info, err = rf.file.Stat(); err != nil {
+		rf.file.Close()
+		rf.file = nil
+		return
+	}
+	rf.size = info.Size()
+	return
+}
+
+func (rf *RotatingFile) rotate() (err error) {
+	if err = rf.file.Close(); err != nil {
+		return
+	}
+	rf.file = nil
+	if rf.MaxBackups > 0 {
+		for i := rf.MaxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", rf.Path, i), fmt.Sprintf("%s.%d", rf.Path, i+1))
+		}
+		if err = os.Rename(rf.Path, rf.Path+".1"); err != nil {
+			return
+		}
+	} else if err = os.Remove(rf.Path); err != nil {
+		return
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) Write(p []byte) (n int, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		if err = rf.open(); err != nil {
+			return
+		}
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > int64(rf.MaxSize)<<20 {
+		if err = rf.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = rf.file.Write(p)
+	rf.size += int64(n)
+	return
+}
+
+// Close closes the current file.
+func (rf *RotatingFile) Close() (err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file != nil {
+		err = rf.file.Close()
+		rf.file = nil
+	}
+	return
+}
+
+var _ di.ProvidesInit = (*RotatingFile)(nil)
+var _ io.WriteCloser = (*RotatingFile)(nil)
+var _ = di.GlobalScope.Declare((*RotatingFile)(nil))
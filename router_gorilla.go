@@ -5,18 +5,24 @@
 package brot
 
 import (
-	"log"
+	"log/slog"
+	"net/http"
 
 	"github.com/fuxsig/brot/di"
 	"github.com/gorilla/mux"
 )
 
-// GorillaRouter defines the rules for the Gorilla multiplexer
+// GorillaRouter defines the rules for the Gorilla multiplexer. NotFound and
+// MethodNotAllowed name handlers for unmatched requests, Error names the
+// ErrorRenderer used by all handlers of the router.
 type GorillaRouter struct {
-	Name      string   `brot:"name"`
-	Subrouter string   `brot:"subrouter"`
-	Use       []string `brot:"use"`
-	Routes    []struct {
+	Name             string   `brot:"name"`
+	Subrouter        string   `brot:"subrouter"`
+	Use              []string `brot:"use"`
+	NotFound         string   `brot:"notFound"`
+	MethodNotAllowed string   `brot:"methodNotAllowed"`
+	Error            string   `brot:"error"`
+	Routes           []struct {
 		Name string `brot:"name"`
 		Path string `brot:"path"`
 		// todo: make Handler a real handler
@@ -33,17 +39,18 @@ type GorillaRouter struct {
 // InitFunc initialize opens a new connection to the configured Redis database
 func (gr *GorillaRouter) InitFunc() (err error) {
 
+	l := logger("router").With("router", gr.Name)
 	var router *mux.Router
 	if gr.Subrouter != "" {
 		if subrouter := di.GlobalScope.Get(gr.Subrouter); subrouter != nil {
 			if subrouter, ok := subrouter.(*mux.Router); ok {
 				router = subrouter
 			} else {
-				log.Printf("Warning: skipping route %s. Subrouter %s is not a mux.Router struct", gr.Name, gr.Subrouter)
+				l.Warn("skipping router, subrouter is not a mux.Router struct", "subrouter", gr.Subrouter)
 				return
 			}
 		} else {
-			log.Printf("Warning: skipping route %s. Could not find subrouter %s", gr.Name, gr.Subrouter)
+			l.Warn("skipping router, could not find subrouter", "subrouter", gr.Subrouter)
 			return
 		}
 	} else {
@@ -53,6 +60,34 @@ func (gr *GorillaRouter) InitFunc() (err error) {
 		}
 	}
 
+	renderer := DefaultErrorRenderer
+	if gr.Error != "" {
+		if obj := di.GlobalScope.Get(gr.Error); obj != nil {
+			if obj, ok := obj.(ErrorRenderer); ok {
+				renderer = obj
+				// attach the renderer first, so that all wrappers can use it
+				router.Use(func(h http.Handler) http.Handler {
+					return withErrorRenderer(h, renderer)
+				})
+			} else {
+				l.Warn("skipping error renderer, object does not support interface ErrorRenderer", "object", gr.Error)
+			}
+		} else {
+			l.Warn("skipping error renderer, object does not exist", "object", gr.Error)
+		}
+	}
+	router.NotFoundHandler = gr.statusHandler(l, gr.NotFound, renderer, http.StatusNotFound)
+	router.MethodNotAllowedHandler = gr.statusHandler(l, gr.MethodNotAllowed, renderer, http.StatusMethodNotAllowed)
+	// log records of matched requests carry the route name
+	router.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+				r = r.WithContext(WithLogAttrs(r.Context(), slog.String("route", route.GetName())))
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+
 	for _, current := range gr.Routes {
 		// do we have a handler object with the given name?
 		if handler := di.GlobalScope.Get(current.Handler); handler != nil {
@@ -95,27 +130,39 @@ func (gr *GorillaRouter) InitFunc() (err error) {
 				}
 				route.Handler(handler.HandlerFunc())
 			} else {
-				log.Printf("Warning: skipping route for %s. Handler %s does not support interface ProvidesHandler", current.Path, current.Handler)
+				l.Warn("skipping route, handler does not support interface ProvidesHandler", "path", current.Path, "handler", current.Handler)
 			}
 		} else {
-			log.Printf("Warning: skipping route for %s. Could not find handler %s", current.Path, current.Handler)
+			l.Warn("skipping route, could not find handler", "path", current.Path, "handler", current.Handler)
 		}
 	}
 	// http wrapper or middleware in mux language
 	for _, use := range gr.Use {
-		if wrapper := di.GlobalScope.Get(use); wrapper != nil {
-			if wrapper, ok := wrapper.(ProvidesWrapper); ok {
-				router.Use(wrapper.WrapperFunc())
-			} else {
-				log.Printf("Warning: skipping wrapper for %s. Object %s does not support interface ProvidesWrapper", gr.Name, use)
-			}
+		if mw, err := lookupMiddleware(use); err == nil {
+			router.Use(mw)
 		} else {
-			log.Printf("Warning: skipping wrapper for %s. Object %s does not exist", gr.Name, use)
+			l.Warn("skipping wrapper", "wrapper", use, "error", err)
 		}
 	}
 	return
 }
 
+// statusHandler returns the handler with the given name or, if the name is
+// empty or invalid, a handler rendering status with renderer.
+func (gr *GorillaRouter) statusHandler(l *slog.Logger, name string, renderer ErrorRenderer, status int) http.Handler {
+	if name != "" {
+		if handler := di.GlobalScope.Get(name); handler != nil {
+			if handler, ok := handler.(ProvidesHandler); ok {
+				return withErrorRenderer(handler.HandlerFunc(), renderer)
+			}
+			l.Warn("skipping status handler, handler does not support interface ProvidesHandler", "status", status, "handler", name)
+		} else {
+			l.Warn("skipping status handler, could not find handler", "status", status, "handler", name)
+		}
+	}
+	return statusHandler(renderer, status)
+}
+
 func (gr *GorillaRouter) Retry() bool {
 	return false
 }
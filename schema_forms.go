@@ -0,0 +1,329 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/fuxsig/brot/model"
+)
+
+// formOptionLimit limits the objects offered by the select of a pointer.
+const formOptionLimit = 100
+
+// formInputs are the inputs of the element types stored as text.
+var formInputs = map[string]string{
+	"string":   "text",
+	"text":     "textarea",
+	"json":     "textarea",
+	"date":     "date",
+	"datetime": "datetime-local",
+	"email":    "email",
+	"url":      "url",
+}
+
+// formField is a field of a generated form.
+type formField struct {
+	ID, Name, Label, Help string
+	// Input is the type of the input element or select or textarea.
+	Input    string
+	Step     string
+	Values   []string
+	Options  []formOption
+	Multiple bool
+	Repeated bool
+	Required bool
+	Error    string
+}
+
+type formOption struct {
+	Value, Label string
+	Selected     bool
+}
+
+type schemaForm struct {
+	Schema string
+	ID     string
+	Fields []*formField
+	Submit string
+}
+
+var schemaFormTemplate = template.Must(template.New("form").Parse(`<form method="post" class="brot-form brot-form-{{.Schema}}">
+<input type="hidden" name="_schema" value="{{.Schema}}">
+{{- if .ID}}
+<input type="hidden" name="_id" value="{{.ID}}">
+{{- end}}
+{{- range .Fields}}
+{{- if eq .Input "hidden"}}
+{{- $f := .}}{{range .Values}}
+<input type="hidden" name="{{$f.Name}}" value="{{.}}">
+{{- end}}
+{{- else}}
+<div class="brot-field{{if .Error}} brot-invalid{{end}}">
+<label for="{{.ID}}">{{.Label}}</label>
+{{- $f := .}}
+{{- if eq .Input "select"}}
+<select id="{{.ID}}" name="{{.Name}}"{{if .Multiple}} multiple{{end}}{{if .Required}} required{{end}}>
+{{- if not .Multiple}}
+<option value=""></option>
+{{- end}}
+{{- range .Options}}
+<option value="{{.Value}}"{{if .Selected}} selected{{end}}>{{.Label}}</option>
+{{- end}}
+</select>
+{{- else}}
+{{- range $i, $v := .Values}}
+{{- if eq $f.Input "textarea"}}
+<textarea{{if eq $i 0}} id="{{$f.ID}}"{{end}} name="{{$f.Name}}"{{if $f.Required}} required{{end}}>{{$v}}</textarea>
+{{- else if eq $f.Input "checkbox"}}
+<input type="checkbox"{{if eq $i 0}} id="{{$f.ID}}"{{end}} name="{{$f.Name}}" value="true"{{if eq $v "true"}} checked{{end}}>
+{{- else}}
+<input type="{{$f.Input}}"{{if eq $i 0}} id="{{$f.ID}}"{{end}} name="{{$f.Name}}" value="{{$v}}"{{with $f.Step}} step="{{.}}"{{end}}{{if and $f.Required (eq $i 0)}} required{{end}}>
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Help}}
+<small class="brot-help">{{.Help}}</small>
+{{- end}}
+{{- if .Error}}
+<div class="brot-error">{{.Error}}</div>
+{{- end}}
+</div>
+{{- end}}
+{{- end}}
+<button type="submit">{{.Submit}}</button>
+</form>`))
+
+type schemaTable struct {
+	Schema  string
+	Headers []string
+	Rows    [][][]string
+}
+
+var schemaTableTemplate = template.Must(template.New("table").Parse(`<table class="brot-table brot-table-{{.Schema}}">
+<thead><tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr>{{range .}}<td>{{range $i, $v := .}}{{if $i}}, {{end}}{{$v}}{{end}}</td>{{end}}</tr>
+{{- end}}
+</tbody>
+</table>`))
+
+// fieldValues returns the values of name in values, which is a model
+// object, a MultiValueMap or url.Values. Values of slices are stored comma
+// separated in model objects.
+func fieldValues(values interface{}, name string, slice bool) []string {
+	switch values := values.(type) {
+	case map[string]string:
+		value, ok := values[name]
+		if !ok || value == "" {
+			return nil
+		}
+		if slice {
+			return strings.Split(value, ",")
+		}
+		return []string{value}
+	case MultiValueMap:
+		return values[name]
+	case url.Values:
+		return values[name]
+	case map[string][]string:
+		return values[name]
+	}
+	return nil
+}
+
+// translator returns the message translation of the locale.
+func (tf *TemplateFuncs) translator(locale string) func(string) string {
+	return func(key string) string {
+		if tf.Locales == nil || key == "" {
+			return key
+		}
+		return tf.Locales.Translate(locale, key)
+	}
+}
+
+// lookupSchema returns the registered schema name.
+func (tf *TemplateFuncs) lookupSchema(name string) (*model.Schema, error) {
+	if tf.Model == nil {
+		return nil, errNoModel
+	}
+	schema := tf.Model.Schema(name)
+	if schema == nil {
+		return nil, fmt.Errorf("schema %s is not registered", name)
+	}
+	return schema, nil
+}
+
+// options returns the objects of the schema referenced by e. The selected
+// objects are always offered, those which cannot be loaded by their id.
+func (tf *TemplateFuncs) options(r *http.Request, e *model.Element, selected []string) ([]formOption, error) {
+	if r == nil {
+		return nil, errNoRequest
+	}
+	ctx := modelContext(r)
+	objects, _, err := tf.Model.Search(ctx, e.Target(), "", "", 0, formOptionLimit)
+	if err != nil {
+		return nil, err
+	}
+	missing := make(map[string]bool, len(selected))
+	for _, value := range selected {
+		missing[value] = value != ""
+	}
+	for _, obj := range objects {
+		missing[obj["_id"]] = false
+	}
+	for _, value := range selected {
+		if !missing[value] {
+			continue
+		}
+		missing[value] = false
+		status, obj, err := tf.Model.Load(ctx, value)
+		switch {
+		case err == nil:
+			objects = append(objects, obj)
+		case status == http.StatusForbidden || status == http.StatusNotFound:
+			objects = append(objects, map[string]string{"_id": value})
+		default:
+			return nil, err
+		}
+	}
+	result := make([]formOption, 0, len(objects))
+	for _, obj := range objects {
+		option := formOption{Value: obj["_id"], Label: obj["_label"]}
+		if option.Label == "" {
+			option.Label = option.Value
+		}
+		for _, value := range selected {
+			if value == option.Value {
+				option.Selected = true
+			}
+		}
+		result = append(result, option)
+	}
+	return result, nil
+}
+
+// form renders a form for the schema name filled with values. Errors maps
+// element names to messages, values without message are checked with
+// Schema.CheckValue. Password widgets are rendered empty.
+func (tf *TemplateFuncs) form(r *http.Request, locale, name string, values interface{}, errs ...map[string]string) (template.HTML, error) {
+	schema, err := tf.lookupSchema(name)
+	if err != nil {
+		return "", err
+	}
+	tr := tf.translator(locale)
+	form := &schemaForm{Schema: schema.Name, Submit: tr("Save")}
+	if ids := fieldValues(values, "_id", false); len(ids) > 0 {
+		form.ID = ids[0]
+	}
+	for _, e := range schema.Elements {
+		field := &formField{
+			ID:       "brot-" + schema.Name + "-" + e.Name,
+			Name:     e.Name,
+			Label:    tr(e.Label),
+			Help:     tr(e.Help),
+			Values:   fieldValues(values, e.Name, e.Slice()),
+			Required: e.Required,
+		}
+		for _, current := range errs {
+			if message, ok := current[e.Name]; ok {
+				field.Error = message
+			}
+		}
+		if field.Error == "" {
+			for _, value := range field.Values {
+				if value == "" {
+					continue
+				}
+				if err := schema.CheckValue(e.Name, value); err != nil {
+					field.Error = err.Error()
+					break
+				}
+			}
+		}
+		switch {
+		case e.Pointer():
+			field.Input = "select"
+			field.Multiple = e.Slice()
+			if field.Options, err = tf.options(r, e, field.Values); err != nil {
+				return "", err
+			}
+		case e.Widget != "":
+			field.Input = e.Widget
+			if e.Widget == "password" {
+				// passwords are never sent back to the client
+				field.Values = nil
+			}
+		case e.Base() == "enum":
+			field.Input = "select"
+			field.Multiple = e.Slice()
+			for _, value := range e.Values {
+				option := formOption{Value: value, Label: tr(value)}
+				for _, current := range field.Values {
+					option.Selected = option.Selected || current == value
+				}
+				field.Options = append(field.Options, option)
+			}
+		case e.Kind() == reflect.Bool:
+			field.Input = "checkbox"
+		case e.Kind() == reflect.Int:
+			field.Input = "number"
+		case e.Kind() == reflect.Float64:
+			field.Input = "number"
+			field.Step = "any"
+		default:
+			field.Input = formInputs[e.Base()]
+		}
+		if field.Input != "select" {
+			// a slice offers an empty input for an additional value
+			field.Repeated = e.Slice()
+			if len(field.Values) == 0 || field.Repeated && field.Input != "hidden" {
+				field.Values = append(field.Values, "")
+			}
+		}
+		form.Fields = append(form.Fields, field)
+	}
+	var buf bytes.Buffer
+	if err = schemaFormTemplate.Execute(&buf, form); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// table renders the objects of the schema name as table.
+func (tf *TemplateFuncs) table(locale, name string, objects []map[string]string) (template.HTML, error) {
+	schema, err := tf.lookupSchema(name)
+	if err != nil {
+		return "", err
+	}
+	tr := tf.translator(locale)
+	table := &schemaTable{Schema: schema.Name}
+	for _, e := range schema.Elements {
+		if e.Widget != "hidden" && e.Widget != "password" {
+			table.Headers = append(table.Headers, tr(e.Label))
+		}
+	}
+	for _, obj := range objects {
+		row := make([][]string, 0, len(table.Headers))
+		for _, e := range schema.Elements {
+			if e.Widget != "hidden" && e.Widget != "password" {
+				row = append(row, fieldValues(obj, e.Name, e.Slice()))
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	var buf bytes.Buffer
+	if err = schemaTableTemplate.Execute(&buf, table); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
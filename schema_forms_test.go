@@ -0,0 +1,117 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"html/template"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fuxsig/brot/model"
+)
+
+func TestSchemaForm(t *testing.T) {
+	conn := &model.Memory{}
+	butter := &model.Butter{
+		Conn: conn,
+		ConfiguredSchemas: []*model.Schema{
+			{Name: "Author", Elements: []*model.Element{{Name: "name", Type: "string"}}},
+			{Name: "Post", Elements: []*model.Element{
+				{Name: "title", Type: "string", Label: "Title", Help: "Shown in lists"},
+				{Name: "views", Type: "int"},
+				{Name: "tags", Type: "[]string"},
+				{Name: "body", Type: "string", Widget: "textarea"},
+				{Name: "author", Type: "*Author"},
+				{Name: "secret", Type: "string", Widget: "password"},
+				{Name: "state", Type: "enum", Values: []string{"draft", "final"}, Required: true},
+				{Name: "public", Type: "bool"},
+				{Name: "rating", Type: "float"},
+				{Name: "day", Type: "date"},
+			}},
+		},
+	}
+	if err := butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	// the authors offered by the select of the author pointer
+	for _, label := range []string{"Ada", "Grace"} {
+		if err := butter.Save(nil, map[string]string{"_schema": "Author", "_label": label, "name": label}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tf := &TemplateFuncs{Model: butter}
+
+	form := template.Must(template.New("form").Funcs(tf.FuncMap()).Parse(`{{form "Post" .}}`))
+	var buf bytes.Buffer
+	r := httptest.NewRequest("GET", "/posts/new", nil)
+	values := map[string]string{"_id": "p1", "title": "Hello", "views": "many", "tags": "go,web", "author": "brot:2", "state": "final", "public": "true", "secret": "s3cret"}
+	if err := tf.execute(form, &buf, r, values); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	for _, expected := range []string{
+		`<input type="hidden" name="_id" value="p1">`,
+		`<label for="brot-Post-title">Title</label>`,
+		`<input type="text" id="brot-Post-title" name="title" value="Hello">`,
+		`<small class="brot-help">Shown in lists</small>`,
+		`<div class="brot-field brot-invalid">`,
+		`<input type="number" id="brot-Post-views" name="views" value="many">`,
+		`<input type="text" id="brot-Post-tags" name="tags" value="go">`,
+		`<input type="text" name="tags" value="web">`,
+		`<input type="text" name="tags" value="">`,
+		`<textarea id="brot-Post-body" name="body"></textarea>`,
+		`<option value="brot:2" selected>Grace</option>`,
+		`<input type="password" id="brot-Post-secret" name="secret" value="">`,
+		`<select id="brot-Post-state" name="state" required>`,
+		`<option value="final" selected>final</option>`,
+		`<input type="checkbox" id="brot-Post-public" name="public" value="true" checked>`,
+		`<input type="number" id="brot-Post-rating" name="rating" value="" step="any">`,
+		`<input type="date" id="brot-Post-day" name="day" value="">`,
+		`<button type="submit">Save</button>`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("expected %s in\n%s", expected, html)
+		}
+	}
+	if strings.Count(html, "brot-error") != 1 || strings.Contains(html, "s3cret") {
+		t.Errorf("expected one error and no password in\n%s", html)
+	}
+
+	// later error maps keep the messages of earlier ones, selected objects
+	// are offered even if they are not found
+	values = map[string]string{"title": "Hello", "author": "brot:99", "state": "final"}
+	result, err := tf.form(r, "en", "Post", values, map[string]string{"title": "taken"}, map[string]string{"day": "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<div class="brot-error">taken</div>`,
+		`<div class="brot-error">missing</div>`,
+		`<option value="brot:99" selected>brot:99</option>`,
+		`<option value="brot:1">Ada</option>`,
+	} {
+		if !strings.Contains(string(result), expected) {
+			t.Errorf("expected %s in\n%s", expected, result)
+		}
+	}
+
+	table := template.Must(template.New("table").Funcs(tf.FuncMap()).Parse(`{{table "Post" .}}`))
+	buf.Reset()
+	objects := []map[string]string{{"title": "<b>", "views": "3", "tags": "go,web"}}
+	if err := tf.execute(table, &buf, r, objects); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<tr><td>&lt;b&gt;</td><td>3</td><td>go, web</td><td></td><td></td><td></td><td></td><td></td><td></td></tr>`
+	if !strings.Contains(buf.String(), expected) || strings.Contains(buf.String(), "secret") {
+		t.Errorf("expected %s in\n%s", expected, buf.String())
+	}
+	unknown := template.Must(template.New("unknown").Funcs(tf.FuncMap()).Parse(`{{form "Unknown" .}}`))
+	if err := tf.execute(unknown, &buf, r, nil); err == nil {
+		t.Error("expected error for unknown schema")
+	}
+}
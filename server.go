@@ -5,7 +5,6 @@
 package brot
 
 import (
-	"log"
 	"net/http"
 	"time"
 
@@ -38,15 +37,16 @@ func (s *Server) InitFunc() (err error) {
 	if s.ReadTimeout >= 0 {
 		server.ReadTimeout = time.Duration(s.ReadTimeout) * time.Second
 	}
+	l := logger("server")
 	if s.Router != "" {
 		obj := di.GlobalScope.Get(s.Router)
 		if obj == nil {
-			log.Printf("Warning: cannot find router %s", s.Router)
+			l.Warn("cannot find router", "router", s.Router)
 			return
 		}
 		handler, ok := obj.(http.Handler)
 		if !ok {
-			log.Printf("Warning: skipping router %s. Router is not http.Handler", s.Router)
+			l.Warn("skipping router, router is not http.Handler", "router", s.Router)
 			return
 		}
 		server.Handler = handler
@@ -55,20 +55,20 @@ func (s *Server) InitFunc() (err error) {
 
 	if s.CertPath != "" || s.KeyPath != "" {
 		if s.CertPath == "" || s.KeyPath == "" {
-			log.Fatalf("Certificate path and key path must be set")
+			fatal(l, "certificate path and key path must be set")
 		}
 		go func() {
-			log.Printf("Started successfully https server on %s", server.Addr)
+			l.Info("starting https server", "addr", server.Addr)
 			if err := server.ListenAndServeTLS(s.CertPath, s.KeyPath); err != nil {
-				log.Fatalf("Stopped https server: %s\n", err.Error())
+				fatal(l, "stopped https server", "addr", server.Addr, "error", err)
 				return
 			}
 		}()
 	} else {
 		go func() {
-			log.Printf("Starting http server on %s", server.Addr)
+			l.Info("starting http server", "addr", server.Addr)
 			if err := server.ListenAndServe(); err != nil {
-				log.Fatalf("Stopped http server: %s\n", err.Error())
+				fatal(l, "stopped http server", "addr", server.Addr, "error", err)
 				return
 			}
 
@@ -0,0 +1,245 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fuxsig/brot/di"
+)
+
+// TemplateCache caches parsed templates for TemplateLoader,
+// TemplateFileHandler and DynamicFileHandler. By default templates are
+// parsed once and never change. With Dev set a request for a template
+// compares the modification times of its source files and directories with
+// those seen when it was parsed, at most every Interval milliseconds (default
+// 1000), and parses changed templates again. Files are polled this way, not
+// watched, so changes show with the next request.
+// Parse and execution errors are then rendered as an HTML overlay showing
+// file, line and the surrounding source.
+type TemplateCache struct {
+	Dev      bool `brot:"dev"`
+	Interval int  `brot:"interval"`
+	mutex    sync.RWMutex
+	entries  map[string]*cacheEntry
+}
+
+// templateParser parses a template and returns the files it is made of.
+// Directories are returned as well, so that added files are noticed.
+type templateParser func() (value interface{}, files []templateFile, err error)
+
+type cacheEntry struct {
+	value   interface{}
+	err     error
+	files   []templateFile
+	mtimes  []time.Time
+	checked time.Time
+}
+
+// changed reports whether a file of the entry was modified or removed.
+func (ce *cacheEntry) changed() bool {
+	for i, file := range ce.files {
+		fi, err := os.Stat(file.path)
+		if err != nil || !fi.ModTime().Equal(ce.mtimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc *TemplateCache) interval() time.Duration {
+	if tc.Interval <= 0 {
+		return time.Second
+	}
+	return time.Duration(tc.Interval) * time.Millisecond
+}
+
+// load returns the cached value of key and parses it if necessary. Missing
+// files are not cached, so that a template can be added later.
+func (tc *TemplateCache) load(key string, parse templateParser) (interface{}, error) {
+	// checked is written under the write lock and must be read under a lock
+	tc.mutex.RLock()
+	entry, ok := tc.entries[key]
+	if ok && (!tc.Dev || time.Since(entry.checked) < tc.interval()) {
+		value, err := entry.value, entry.err
+		tc.mutex.RUnlock()
+		return value, err
+	}
+	tc.mutex.RUnlock()
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	// another request may have parsed the template in the meantime
+	if entry, ok = tc.entries[key]; ok {
+		if !tc.Dev || time.Since(entry.checked) < tc.interval() {
+			return entry.value, entry.err
+		}
+		if !entry.changed() {
+			entry.checked = time.Now()
+			return entry.value, entry.err
+		}
+		logger("templates").Info("reloading template", "key", key)
+	}
+	value, files, err := parse()
+	mtimes := make([]time.Time, len(files))
+	for i, file := range files {
+		if fi, serr := os.Stat(file.path); serr == nil {
+			mtimes[i] = fi.ModTime()
+		}
+	}
+	if os.IsNotExist(err) {
+		delete(tc.entries, key)
+		return value, err
+	}
+	err = tc.wrap(err, files)
+	if tc.entries == nil {
+		tc.entries = make(map[string]*cacheEntry)
+	}
+	tc.entries[key] = &cacheEntry{value, err, files, mtimes, time.Now()}
+	return value, err
+}
+
+// executionError adds the source of the template key to err in development
+// mode.
+func (tc *TemplateCache) executionError(key string, err error) error {
+	if !tc.Dev || err == nil {
+		return err
+	}
+	tc.mutex.RLock()
+	entry, ok := tc.entries[key]
+	tc.mutex.RUnlock()
+	if !ok {
+		return err
+	}
+	return tc.wrap(err, entry.files)
+}
+
+// templateErrorPosition matches the position in parse and execution errors,
+// e.g. template: blog/post:12:5: executing ...
+var templateErrorPosition = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+
+// wrap converts a template error into a *TemplateError in development mode.
+func (tc *TemplateCache) wrap(err error, files []templateFile) error {
+	if !tc.Dev || err == nil {
+		return err
+	}
+	var te *TemplateError
+	if errors.As(err, &te) {
+		return err
+	}
+	match := templateErrorPosition.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	te = &TemplateError{Err: err}
+	te.Line, _ = strconv.Atoi(match[2])
+	for _, file := range files {
+		if file.name == match[1] {
+			te.File = file.path
+			te.Context = sourceContext(file.path, te.Line, 3)
+			break
+		}
+	}
+	return te
+}
+
+// SourceLine is a line of a template shown in the error overlay.
+type SourceLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+// TemplateError is a template error with its position in the source.
+type TemplateError struct {
+	File    string
+	Line    int
+	Err     error
+	Context []SourceLine
+}
+
+func (e *TemplateError) Error() string {
+	if e.File == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err.Error())
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// sourceContext returns the lines of file around line.
+func sourceContext(file string, line, around int) (result []SourceLine) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for number := 1; scanner.Scan() && number <= line+around; number++ {
+		if number >= line-around {
+			result = append(result, SourceLine{number, scanner.Text(), number == line})
+		}
+	}
+	return
+}
+
+var overlayTemplate = template.Must(template.New("overlay").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Template error</title>
+<style>
+body { margin: 0; background: #1e1e1e; color: #eee; font: 14px/1.5 monospace; }
+main { padding: 2em; }
+h1 { color: #ff6b6b; font-size: 1.4em; }
+pre { background: #2d2d2d; padding: 1em; overflow-x: auto; }
+.current { background: #5c2b2b; display: block; }
+</style>
+</head>
+<body>
+<main>
+<h1>Template error</h1>
+<p>{{.Err}}</p>
+{{if .File}}<p>{{.File}}:{{.Line}}</p>{{end}}
+{{if .Context}}<pre>{{range .Context}}<span{{if .Current}} class="current"{{end}}>{{printf "%4d" .Number}}  {{.Text}}</span>
+{{end}}</pre>{{end}}
+</main>
+</body>
+</html>
+`))
+
+// templateFailed renders err, which occurred while parsing or executing a
+// template. A *TemplateError is shown as overlay to clients accepting HTML,
+// all other errors are passed to Error.
+func templateFailed(w http.ResponseWriter, r *http.Request, err error) {
+	var te *TemplateError
+	if errors.As(err, &te) && negotiateType(r, "text/html", "application/json") == "text/html" {
+		h := w.Header()
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		h.Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusInternalServerError)
+		if err = overlayTemplate.Execute(w, te); err != nil {
+			logger("templates").WarnContext(r.Context(), "could not render error overlay", "error", err)
+		}
+		return
+	}
+	Error(w, r, http.StatusInternalServerError, err)
+}
+
+// defaultTemplateCache is used by all templates without configured cache.
+var defaultTemplateCache = &TemplateCache{}
+
+var _ = di.GlobalScope.Declare((*TemplateCache)(nil))
@@ -5,27 +5,219 @@
 package brot
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
 	"net/http"
+	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/model"
 )
 
+// TemplateFileHandler serves the templates stored below Dir as pages. The
+// request path without Path is mapped to a file: /about is served by
+// about.html or about/index.html, / by index.html. Index and Ext change
+// the name of index files and the template extension. All templates in
+// the Layouts directory (default _layouts) are parsed with every page, so
+// that a page can invoke a layout and override its blocks. Path elements
+// starting with _ or . are never served. Pages are cached by Cache, without
+// development mode all pages are parsed at startup. Pages get the session
+// values named by SessionKeys, which default to email and given_name.
+// Funcs default to the funcs of TemplateFuncs with Model.
 type TemplateFileHandler struct {
-	Dir       string     `brot:"dir"`
-	Path      string     `brot:"path"`
-	Resolvers []Resolver `brot:"resolvers"`
+	Dir         string         `brot:"dir,mandatory"`
+	Path        string         `brot:"path"`
+	Index       string         `brot:"index"`
+	Ext         string         `brot:"ext"`
+	Layouts     string         `brot:"layouts"`
+	Data        *DataLayer     `brot:"data"`
+	Model       *model.Butter  `brot:"model"`
+	Cache       *TemplateCache `brot:"cache"`
+	Funcs       *TemplateFuncs `brot:"funcs"`
+	SessionKeys []string       `brot:"sessionKeys"`
+}
+
+// Page is the data a page template is executed with.
+type Page struct {
+	Path    string
+	Values  MultiValueMap
+	Session map[string]interface{}
+}
+
+// Value returns the first DataLayer value of name or an empty string.
+func (p *Page) Value(name string) string {
+	value, _ := p.Values.Get(name)
+	return value
+}
+
+func (sh *TemplateFileHandler) InitFunc() (err error) {
+	if sh.Dir == "" {
+		return errors.New("dir must be set")
+	}
+	if sh.Index == "" {
+		sh.Index = "index"
+	}
+	if sh.Ext == "" {
+		sh.Ext = ".html"
+	}
+	if sh.Layouts == "" {
+		sh.Layouts = "_layouts"
+	}
+	if sh.SessionKeys == nil {
+		sh.SessionKeys = []string{"email", "given_name"}
+	}
+	if sh.Cache == nil {
+		sh.Cache = defaultTemplateCache
+	}
+	if sh.Funcs == nil {
+		sh.Funcs = &TemplateFuncs{Model: sh.Model}
+	}
+	if !sh.Cache.Dev {
+		err = sh.preload()
+	}
+	return
+}
+
+// preload parses all pages below Dir and returns the first error.
+func (sh *TemplateFileHandler) preload() error {
+	l := logger("pages")
+	return filepath.Walk(sh.Dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			l.Warn("skipping dir", "path", file, "error", err)
+			return nil
+		}
+		name := info.Name()
+		if file != sh.Dir && (strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && filepath.Ext(name) == sh.Ext {
+			if _, err = sh.load(file); err != nil {
+				return fmt.Errorf("could not parse page %s: %w", file, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (sh *TemplateFileHandler) Retry() bool {
+	return false
+}
+
+// lookup maps the request path to a template file below Dir. Paths outside
+// of Path are not found.
+func (sh *TemplateFileHandler) lookup(urlPath string) (string, bool) {
+	prefix := strings.TrimSuffix(sh.Path, "/")
+	if !strings.HasPrefix(urlPath, prefix) || len(urlPath) > len(prefix) && urlPath[len(prefix)] != '/' {
+		return "", false
+	}
+	name := path.Clean("/" + urlPath[len(prefix):])
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, "_") || strings.HasPrefix(elem, ".") {
+			return "", false
+		}
+	}
+	file := filepath.Join(sh.Dir, filepath.FromSlash(name))
+	var candidates []string
+	switch ext := path.Ext(name); {
+	case name == "/":
+		candidates = []string{filepath.Join(file, sh.Index+sh.Ext)}
+	case ext == sh.Ext:
+		candidates = []string{file}
+	case ext != "":
+		return "", false
+	default:
+		candidates = []string{file + sh.Ext, filepath.Join(file, sh.Index+sh.Ext)}
+	}
+	for _, candidate := range candidates {
+		if fi, err := os.Stat(candidate); err == nil && fi.Mode().IsRegular() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// load returns the page stored in file. The layouts are parsed first, so
+// that the blocks defined by the page take precedence over the defaults of
+// the layouts.
+func (sh *TemplateFileHandler) load(file string) (*template.Template, error) {
+	value, err := sh.Cache.load("page:"+file, func() (interface{}, []templateFile, error) {
+		dir := filepath.Join(sh.Dir, sh.Layouts)
+		files := []templateFile{{"", dir}}
+		layouts, err := filepath.Glob(filepath.Join(dir, "*"+sh.Ext))
+		if err != nil {
+			return nil, files, err
+		}
+		for _, layout := range layouts {
+			files = append(files, templateFile{filepath.Base(layout), layout})
+		}
+		files = append(files, templateFile{filepath.Base(file), file})
+		t := template.New(filepath.Base(file)).Funcs(sh.Funcs.FuncMap())
+		if len(layouts) > 0 {
+			if t, err = t.ParseFiles(layouts...); err != nil {
+				return nil, files, err
+			}
+		}
+		t, err = t.ParseFiles(file)
+		return t, files, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*template.Template), nil
 }
 
 func (sh *TemplateFileHandler) HandlerFunc() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		vars := make(map[string]string, 0)
-		for _, resolver := range sh.Resolvers {
-			resolver.Resolve(r, vars)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			Error(w, r, http.StatusMethodNotAllowed, nil)
+			return
+		}
+		file, ok := sh.lookup(r.URL.Path)
+		if !ok {
+			Error(w, r, http.StatusNotFound, nil)
+			return
+		}
+		values, err := sh.Data.Parse(r)
+		if err != nil {
+			Error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		t, err := sh.load(file)
+		if err != nil {
+			templateFailed(w, r, err)
+			return
 		}
 
-		path := filepath.Clean(r.URL.Path)
-		_ = path
+		page := &Page{Path: r.URL.Path, Values: values, Session: map[string]interface{}{}}
+		// a broken or missing session cookie results in an empty session
+		if session, err := sessionStore.Get(r, "brot-store"); err == nil {
+			for _, key := range sh.SessionKeys {
+				if value, ok := session.Values[key]; ok {
+					page.Session[key] = value
+				}
+			}
+		} else {
+			logger("pages").DebugContext(r.Context(), "session error", "error", err)
+		}
+
+		var buf bytes.Buffer
+		if err = sh.Funcs.executeCached("page:"+file, t, &buf, r, page); err != nil {
+			templateFailed(w, r, sh.Cache.executionError("page:"+file, err))
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		h.Set("Cache-Control", "private, no-cache")
+		buf.WriteTo(w)
 	})
 }
 
@@ -33,4 +225,7 @@ func (sh *TemplateFileHandler) PrefixFunc() string {
 	return sh.Path
 }
 
+var _ di.ProvidesInit = (*TemplateFileHandler)(nil)
+var _ ProvidesHandler = (*TemplateFileHandler)(nil)
+var _ ProvidesPrefix = (*TemplateFileHandler)(nil)
 var _ = di.GlobalScope.Declare((*TemplateFileHandler)(nil))
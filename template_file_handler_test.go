@@ -0,0 +1,100 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fuxsig/brot/model"
+)
+
+func TestTemplateFileHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"_layouts/base.html": `{{define "base"}}<h1>{{block "title" .}}default{{end}}</h1>{{end}}`,
+		"index.html":         `{{template "base" .}}{{define "title"}}home{{end}}`,
+		"about.html":         `about {{.Value "lang"}}`,
+		"account.html":       `{{range $key, $value := .Session}}{{$key}}={{$value}};{{end}}`,
+		"docs/index.html":    `docs`,
+		"docs/style.css":     `body {}`,
+		"posts.html":         `{{range search "Post" "*"}}{{.title}}{{end}}`,
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err = ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	butter := &model.Butter{
+		Conn:              &model.Memory{},
+		ConfiguredSchemas: []*model.Schema{{Name: "Post", Elements: []*model.Element{{Name: "title", Type: "string"}}}},
+	}
+	if err = butter.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	if err = butter.Save(nil, map[string]string{"_schema": "Post", "title": "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	sh := &TemplateFileHandler{Dir: dir, Path: "/site", Model: butter,
+		Data: &DataLayer{Providers: []ValueProvider{&URLParameterProvider{Mapping: map[string]string{"lang": "lang"}}}}}
+	if err = sh.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	handler := sh.HandlerFunc()
+
+	tables := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{"GET", "/site/", 200, "<h1>home</h1>"},
+		{"GET", "/site/about?lang=de", 200, "about de"},
+		{"GET", "/site/about.html", 200, "about"},
+		{"HEAD", "/site/docs", 200, "docs"},
+		{"GET", "/site/docs/style.css", 404, ""},
+		{"GET", "/site/_layouts/base", 404, ""},
+		{"GET", "/site/../../about", 200, "about"},
+		{"GET", "/site/missing", 404, ""},
+		{"POST", "/site/about", 405, ""},
+		{"GET", "/siteabout", 404, ""},
+		{"GET", "/other/about", 404, ""},
+		{"GET", "/site", 200, "<h1>home</h1>"},
+		{"GET", "/site/account", 200, "email=ann@example.com;"},
+		{"GET", "/site/posts", 200, "Hello"},
+	}
+	// only allowed session values are passed to pages
+	w := httptest.NewRecorder()
+	session, _ := sessionStore.New(httptest.NewRequest("GET", "/", nil), "brot-store")
+	session.Values["email"] = "ann@example.com"
+	session.Values["access_token"] = "secret"
+	session.Save(httptest.NewRequest("GET", "/", nil), w)
+	cookies := w.Result().Cookies()
+	for _, table := range tables {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(table.method, table.path, nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		handler.ServeHTTP(w, r)
+		if w.Code != table.status {
+			t.Errorf("%s %s: expected status %d, found %d", table.method, table.path, table.status, w.Code)
+		} else if table.status == 200 && strings.TrimSpace(w.Body.String()) != table.body {
+			t.Errorf("%s %s: expected %q, found %q", table.method, table.path, table.body, w.Body.String())
+		}
+	}
+	if _, ok := sh.lookup("/site/../../etc/passwd"); ok {
+		t.Error("expected path traversal to be rejected")
+	}
+}
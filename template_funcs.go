@@ -0,0 +1,576 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/model"
+)
+
+// ProvidesFuncs provides template funcs. Objects of the scope implementing
+// it are added to templates with the extensions of TemplateFuncs.
+type ProvidesFuncs interface {
+	FuncMap() template.FuncMap
+}
+
+var (
+	registeredMutex sync.RWMutex
+	registeredFuncs = template.FuncMap{}
+)
+
+// RegisterFuncs makes funcs available to all templates. It is meant to be
+// called by other packages in their init functions. Registered funcs
+// replace the built-in funcs of the same name.
+func RegisterFuncs(funcs template.FuncMap) {
+	registeredMutex.Lock()
+	defer registeredMutex.Unlock()
+	for name, fn := range funcs {
+		registeredFuncs[name] = fn
+	}
+}
+
+// TemplateFuncs is the func registry of TemplateLoader, TemplateFileHandler
+// and DynamicFileHandler. Besides the built-in funcs and the registered
+// funcs it provides:
+//
+//	asset "css/site.css"    URL of a file below Assets with a fingerprint
+//	schema obj              schema of a model object or of a name
+//	form "schema" values    form of a schema with inline validation errors
+//	table "schema" objects  table of model objects
+//	load "id"               model object loaded for the current user
+//	search "schema" "query" model objects found for the current user
+//	expand objs "author"    objects with the referenced objects embedded
+//	t "key" args...         message of the locale of the request
+//	date "2006-01-02" value date formatted for the locale
+//	number 2 value          number formatted for the locale
+//	currency "EUR" value    amount formatted for the locale
+//	locale                  the locale of the request
+//	user                    the authenticated user, empty if anonymous
+//	memberOf "group"...     whether the user is member of one of the groups
+//
+// The locale is resolved by Locales, without Locales messages are keys and
+// English conventions are used. Expand accepts an object or a list of
+// objects and comma separated paths like RestHandler, ExpandDepth limits
+// their length and defaults to 2. AssetPath is the URL prefix of the assets
+// and defaults to /assets/. The funcs of Extensions are added last and take
+// precedence.
+type TemplateFuncs struct {
+	Model       *model.Butter   `brot:"model"`
+	Locales     *Locales        `brot:"locales"`
+	Assets      string          `brot:"assets"`
+	AssetPath   string          `brot:"assetPath"`
+	Extensions  []ProvidesFuncs `brot:"extensions"`
+	ExpandDepth int             `brot:"expandDepth"`
+	hashes      sync.Map
+	bindings    sync.Map
+}
+
+// defaultTemplateFuncs is used by all templates without configured funcs.
+var defaultTemplateFuncs = &TemplateFuncs{}
+
+// FuncMap returns the funcs templates are parsed with. The model funcs of
+// the map fail, execute binds them to the request.
+func (tf *TemplateFuncs) FuncMap() template.FuncMap {
+	result := make(template.FuncMap, len(builtinFuncs)+8)
+	for name, fn := range builtinFuncs {
+		result[name] = fn
+	}
+	registeredMutex.RLock()
+	for name, fn := range registeredFuncs {
+		result[name] = fn
+	}
+	registeredMutex.RUnlock()
+	result["asset"] = tf.asset
+	result["schema"] = func(obj interface{}) (*model.Schema, error) {
+		if obj, ok := obj.(map[string]string); ok {
+			return tf.lookupSchema(obj["_schema"])
+		}
+		name, err := di.GetString(obj)
+		if err != nil {
+			return nil, err
+		}
+		return tf.lookupSchema(name)
+	}
+	scope := &requestScope{}
+	scope.set(tf, nil)
+	for name, fn := range tf.requestFuncs(scope) {
+		result[name] = fn
+	}
+	for _, extension := range tf.Extensions {
+		for name, fn := range extension.FuncMap() {
+			result[name] = fn
+		}
+	}
+	return result
+}
+
+var (
+	errNoModel   = errors.New("no model configured")
+	errNoRequest = errors.New("template executed without request")
+)
+
+// requestScope is the request a template is executed for.
+type requestScope struct {
+	r      *http.Request
+	locale string
+	format localeFormat
+}
+
+// set sets the scope to r, the locale is resolved once.
+func (rs *requestScope) set(tf *TemplateFuncs, r *http.Request) {
+	rs.r = r
+	switch {
+	case tf.Locales == nil:
+		rs.locale = "en"
+	case r == nil:
+		rs.locale = tf.Locales.Default
+	default:
+		rs.locale = tf.Locales.Resolve(r)
+	}
+	rs.format = localeFormatOf(rs.locale)
+}
+
+// requestFuncs returns the funcs depending on the request of rs.
+func (tf *TemplateFuncs) requestFuncs(rs *requestScope) template.FuncMap {
+	return template.FuncMap{
+		"locale": func() string { return rs.locale },
+		"user": func() string {
+			if rs.r == nil {
+				return ""
+			}
+			return Identity(rs.r.Context()).User
+		},
+		"memberOf": func(groups ...string) bool {
+			if rs.r == nil {
+				return false
+			}
+			return Identity(rs.r.Context()).MemberOf(groups...)
+		},
+		"t": func(key string, args ...interface{}) string {
+			if tf.Locales == nil {
+				return key
+			}
+			return tf.Locales.Translate(rs.locale, key, args...)
+		},
+		"date": func(layout string, value interface{}) (string, error) {
+			t, err := toTime(value)
+			if err != nil {
+				return "", err
+			}
+			return rs.format.formatDate(t, layout), nil
+		},
+		"number": func(decimals int, value interface{}) (string, error) {
+			f, err := di.GetFloat64(value)
+			if err != nil {
+				return "", err
+			}
+			return formatNumber(f, decimals, rs.format.group, rs.format.point), nil
+		},
+		"currency": func(code string, value interface{}) (string, error) {
+			f, err := di.GetFloat64(value)
+			if err != nil {
+				return "", err
+			}
+			return rs.format.formatCurrency(code, f), nil
+		},
+		"load": func(id string) (map[string]string, error) {
+			if rs.r == nil {
+				return nil, errNoRequest
+			}
+			if tf.Model == nil {
+				return nil, errNoModel
+			}
+			_, obj, err := tf.Model.Load(modelContext(rs.r), id)
+			return obj, err
+		},
+		"search": func(schema, query string, args ...int) ([]map[string]string, error) {
+			if rs.r == nil {
+				return nil, errNoRequest
+			}
+			if tf.Model == nil {
+				return nil, errNoModel
+			}
+			offset, num := 0, 10
+			if len(args) > 0 {
+				offset = args[0]
+			}
+			if len(args) > 1 {
+				num = args[1]
+			}
+			result, _, err := tf.Model.Search(modelContext(rs.r), schema, query, "", offset, num)
+			return result, err
+		},
+		"expand": func(objects interface{}, paths string) (interface{}, error) {
+			return tf.expand(rs.r, objects, paths)
+		},
+		"form": func(schema string, values interface{}, errs ...map[string]string) (template.HTML, error) {
+			return tf.form(rs.r, rs.locale, schema, values, errs...)
+		},
+		"table": func(schema string, objects []map[string]string) (template.HTML, error) {
+			return tf.table(rs.locale, schema, objects)
+		},
+	}
+}
+
+// expand embeds the objects referenced by paths into objects, which is a
+// model object or a list of them.
+func (tf *TemplateFuncs) expand(r *http.Request, objects interface{}, paths string) (interface{}, error) {
+	if r == nil {
+		return nil, errNoRequest
+	}
+	if tf.Model == nil {
+		return nil, errNoModel
+	}
+	depth := tf.ExpandDepth
+	if depth <= 0 {
+		depth = defaultExpandDepth
+	}
+	switch objects := objects.(type) {
+	case map[string]string:
+		result, err := tf.Model.Expand(modelContext(r), []map[string]string{objects}, strings.Split(paths, ","), depth)
+		if err != nil {
+			return nil, err
+		}
+		return result[0], nil
+	case []map[string]string:
+		return tf.Model.Expand(modelContext(r), objects, strings.Split(paths, ","), depth)
+	}
+	return nil, fmt.Errorf("cannot expand %T", objects)
+}
+
+// executor is an html or text template.
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// binding is a clone of a template whose request funcs are bound to scope.
+// It is executed by one request at a time.
+type binding struct {
+	t     executor
+	scope *requestScope
+}
+
+// bindingPool pools the bindings of one source template.
+type bindingPool struct {
+	source interface{}
+	pool   sync.Pool
+}
+
+// execute executes the template t, which never changes, with the funcs of
+// r, see executeCached.
+func (tf *TemplateFuncs) execute(t *template.Template, w io.Writer, r *http.Request, data interface{}) error {
+	return tf.executeCached("template:"+t.Name(), t, w, r, data)
+}
+
+// executeCached executes t, which is cached under the name key, with the
+// funcs of r. Templates of the cache are never executed themselves. Their
+// clones are bound to a request scope once and pooled, so that a clone is
+// escaped with its first execution only. The clones of a replaced template
+// are dropped together with their pool.
+func (tf *TemplateFuncs) executeCached(key string, t *template.Template, w io.Writer, r *http.Request, data interface{}) error {
+	return tf.bind(key, t, func(funcs template.FuncMap) (executor, error) {
+		clone, err := t.Clone()
+		if err != nil {
+			return nil, err
+		}
+		return clone.Funcs(funcs), nil
+	}, w, r, data)
+}
+
+// bind executes a pooled binding of source for r. clone returns a new
+// clone of source with funcs. The pools are stored by key, so that a
+// reloaded template replaces the pool of its predecessor.
+func (tf *TemplateFuncs) bind(key string, source interface{}, clone func(funcs template.FuncMap) (executor, error), w io.Writer, r *http.Request, data interface{}) error {
+	value, _ := tf.bindings.Load(key)
+	bp, _ := value.(*bindingPool)
+	if bp == nil || bp.source != source {
+		bp = &bindingPool{source: source}
+		tf.bindings.Store(key, bp)
+	}
+	b, _ := bp.pool.Get().(*binding)
+	if b == nil {
+		b = &binding{scope: &requestScope{}}
+		var err error
+		if b.t, err = clone(tf.requestFuncs(b.scope)); err != nil {
+			return err
+		}
+	}
+	b.scope.set(tf, r)
+	defer func() {
+		*b.scope = requestScope{}
+		bp.pool.Put(b)
+	}()
+	return b.t.Execute(w, data)
+}
+
+// asset returns the URL of the asset file name with the first bytes of its
+// hash as version, so that it can be cached forever.
+func (tf *TemplateFuncs) asset(name string) (string, error) {
+	prefix := tf.AssetPath
+	if prefix == "" {
+		prefix = "/assets/"
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	url := strings.TrimSuffix(prefix, "/") + "/" + name
+	if tf.Assets == "" {
+		return url, nil
+	}
+	file := filepath.Join(tf.Assets, filepath.FromSlash(name))
+	fi, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	type fingerprint struct {
+		mtime time.Time
+		hash  string
+	}
+	if cached, ok := tf.hashes.Load(file); ok && cached.(fingerprint).mtime.Equal(fi.ModTime()) {
+		return url + "?v=" + cached.(fingerprint).hash, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))[:12]
+	tf.hashes.Store(file, fingerprint{fi.ModTime(), hash})
+	return url + "?v=" + hash, nil
+}
+
+// Pagination describes the pages of a list, see paginate.
+type Pagination struct {
+	Page    int
+	Pages   int
+	PerPage int
+	Total   int
+	Offset  int
+	Prev    int
+	Next    int
+}
+
+// Numbers returns the page numbers from 1 to Pages.
+func (p Pagination) Numbers() []int {
+	return seq(p.Pages)
+}
+
+func paginate(total, perPage, page int) Pagination {
+	if perPage < 1 {
+		perPage = 10
+	}
+	p := Pagination{Total: total, PerPage: perPage, Pages: (total + perPage - 1) / perPage}
+	if p.Pages < 1 {
+		p.Pages = 1
+	}
+	switch {
+	case page < 1:
+		page = 1
+	case page > p.Pages:
+		page = p.Pages
+	}
+	p.Page = page
+	p.Offset = (page - 1) * perPage
+	if page > 1 {
+		p.Prev = page - 1
+	}
+	if page < p.Pages {
+		p.Next = page + 1
+	}
+	return p
+}
+
+func seq(n int) []int {
+	if n < 0 {
+		n = 0
+	}
+	result := make([]int, n)
+	for i := range result {
+		result[i] = i + 1
+	}
+	return result
+}
+
+// toTime accepts a time.Time, a RFC 3339 string or unix seconds.
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		return *v, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+	}
+	secs, err := di.GetInt64(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot convert %v to time", value)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// formatNumber rounds value to decimals and groups the integer digits.
+func formatNumber(value float64, decimals int, group, point string) string {
+	str := strconv.FormatFloat(math.Abs(value), 'f', decimals, 64)
+	integer, fraction := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		integer, fraction = str[:i], str[i+1:]
+	}
+	var b strings.Builder
+	if value < 0 && strings.Trim(str, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteString(group)
+		}
+		b.WriteRune(digit)
+	}
+	if fraction != "" {
+		b.WriteString(point)
+		b.WriteString(fraction)
+	}
+	return b.String()
+}
+
+var currencySymbols = map[string]string{"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥", "CHF": "CHF "}
+
+// sliceValue returns list[from:to] for any slice or array.
+func sliceValue(list interface{}, from, to int) (interface{}, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("cannot slice %T", list)
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to > v.Len() || to < 0 {
+		to = v.Len()
+	}
+	if from > to {
+		from = to
+	}
+	return v.Slice(from, to).Interface(), nil
+}
+
+var builtinFuncs = template.FuncMap{
+	"dict": func(values ...interface{}) (map[string]interface{}, error) {
+		if len(values)%2 != 0 {
+			return nil, errors.New("invalid dict call")
+		}
+		dict := make(map[string]interface{}, len(values)/2)
+		for i := 0; i < len(values); i += 2 {
+			key, ok := values[i].(string)
+			if !ok {
+				return nil, errors.New("dict keys must be strings")
+			}
+			dict[key] = values[i+1]
+		}
+		return dict, nil
+	},
+	"sha256": func(value string) string {
+		h := sha256.New()
+		h.Write([]byte(value))
+		return fmt.Sprintf("%x", h.Sum(nil))
+	},
+
+	"now": time.Now,
+
+	// arithmetic
+	"add": func(a, b int) int { return a + b },
+	"sub": func(a, b int) int { return a - b },
+	"mul": func(a, b int) int { return a * b },
+	"div": func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	},
+
+	// strings
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"title": func(s string) string {
+		runes := []rune(s)
+		for i, r := range runes {
+			if i == 0 || unicode.IsSpace(runes[i-1]) {
+				runes[i] = unicode.ToTitle(r)
+			}
+		}
+		return string(runes)
+	},
+	"trim": strings.TrimSpace,
+	"truncate": func(n int, s string) string {
+		if runes := []rune(s); len(runes) > n {
+			return strings.TrimSpace(string(runes[:n])) + "…"
+		}
+		return s
+	},
+	"replace":   func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix": func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":     func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":      func(sep string, list []string) string { return strings.Join(list, sep) },
+	"default": func(def, value interface{}) interface{} {
+		if v := reflect.ValueOf(value); !v.IsValid() || v.IsZero() {
+			return def
+		}
+		return value
+	},
+
+	// encoding
+	"json": func(value interface{}) (template.JS, error) {
+		b, err := json.Marshal(value)
+		return template.JS(b), err
+	},
+
+	// lists
+	"first": func(n int, list interface{}) (interface{}, error) { return sliceValue(list, 0, n) },
+	"after": func(n int, list interface{}) (interface{}, error) { return sliceValue(list, n, -1) },
+	"seq":   seq,
+	"paginate": func(total, perPage, page interface{}) (Pagination, error) {
+		var values [3]int64
+		for i, v := range []interface{}{total, perPage, page} {
+			var err error
+			if values[i], err = di.GetInt64(v); err != nil {
+				return Pagination{}, fmt.Errorf("paginate: %v is not a number", v)
+			}
+		}
+		return paginate(int(values[0]), int(values[1]), int(values[2])), nil
+	},
+
+	// trusted content, never pass user input
+	"safeHTML": func(s string) template.HTML { return template.HTML(s) },
+	"safeURL":  func(s string) template.URL { return template.URL(s) },
+	"safeAttr": func(s string) template.HTMLAttr { return template.HTMLAttr(s) },
+	"safeJS":   func(s string) template.JS { return template.JS(s) },
+	"safeCSS":  func(s string) template.CSS { return template.CSS(s) },
+}
+
+var _ ProvidesFuncs = (*TemplateFuncs)(nil)
+var _ = di.GlobalScope.Declare((*TemplateFuncs)(nil))
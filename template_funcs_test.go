@@ -0,0 +1,122 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fuxsig/brot/model"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "site.css"), []byte("body {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	RegisterFuncs(template.FuncMap{"shout": func(s string) string { return s + "!" }})
+	tf := &TemplateFuncs{Assets: dir, AssetPath: "/static/"}
+
+	tables := []struct {
+		code   string
+		data   interface{}
+		result string
+	}{
+		{`{{number 2 1234567.891}}`, nil, "1,234,567.89"},
+		{`{{number 0 -999.6}}`, nil, "-1,000"},
+		{`{{currency "EUR" "-12.5"}}`, nil, "-€12.50"},
+		{`{{date "2006-01-02" .}}`, time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC), "2018-03-04"},
+		{`{{date "2006" "2018-03-04T10:00:00Z"}}`, nil, "2018"},
+		{`{{truncate 5 "hello world"}}`, nil, "hello…"},
+		{`{{title "hello brave world"}}`, nil, "Hello Brave World"},
+		{`{{default "none" .}}`, "", "none"},
+		{`{{join "," (split " " "a b c")}}`, nil, "a,b,c"},
+		{`<script>var x = {{json .}};</script>`, map[string]int{"a": 1}, `<script>var x = {"a":1};</script>`},
+		{`{{range first 2 .}}{{.}}{{end}}|{{range after 2 .}}{{.}}{{end}}`, []int{1, 2, 3}, "12|3"},
+		{`{{with paginate 45 10 "7"}}{{.Page}}/{{.Pages}} {{.Prev}} {{.Next}} {{.Offset}} {{len .Numbers}}{{end}}`, nil, "5/5 4 0 40 5"},
+		{`<a href="{{safeURL .}}">`, "javascript:void(0)", `<a href="javascript:void%280%29">`},
+		{`{{shout "hey"}}`, nil, "hey!"},
+		{`{{add 1 (mul 2 3)}}`, nil, "7"},
+	}
+	for _, table := range tables {
+		tmpl, err := template.New("test").Funcs(tf.FuncMap()).Parse(table.code)
+		if err != nil {
+			t.Errorf("%s: %s", table.code, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err = tf.execute(tmpl, &buf, nil, table.data); err != nil {
+			t.Errorf("%s: %s", table.code, err)
+		} else if buf.String() != table.result {
+			t.Errorf("%s: expected %q, found %q", table.code, table.result, buf.String())
+		}
+	}
+
+	asset, err := tf.asset("../site.css")
+	if err != nil || !regexp.MustCompile(`^/static/site\.css\?v=[0-9a-f]{12}$`).MatchString(asset) {
+		t.Errorf("unexpected asset url %s %v", asset, err)
+	}
+	if _, err = tf.asset("missing.css"); err == nil {
+		t.Error("expected error for missing asset")
+	}
+
+	tmpl := template.Must(template.New("load").Funcs(tf.FuncMap()).Parse(`{{load "1"}}`))
+	if err = tf.execute(tmpl, &bytes.Buffer{}, nil, nil); err == nil {
+		t.Error("expected error for load without request")
+	}
+}
+
+func TestTemplateFuncsBinding(t *testing.T) {
+	tf := &TemplateFuncs{}
+	tmpl := template.Must(template.New("user").Funcs(tf.FuncMap()).Parse(`<b>{{user}}</b>`))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(WithIdentity(r.Context(), model.NewContext(user)))
+			for j := 0; j < 10; j++ {
+				var buf bytes.Buffer
+				if err := tf.executeCached("user", tmpl, &buf, r, nil); err != nil || buf.String() != "<b>"+user+"</b>" {
+					t.Errorf("%s: unexpected result %q %v", user, buf.String(), err)
+				}
+			}
+		}(fmt.Sprintf("user%d", i))
+	}
+	wg.Wait()
+
+	// a replaced template is not executed with the clones of its predecessor
+	replaced := template.Must(template.New("user").Funcs(tf.FuncMap()).Parse(`<i>{{user}}</i>`))
+	var buf bytes.Buffer
+	if err := tf.executeCached("user", replaced, &buf, httptest.NewRequest("GET", "/", nil), nil); err != nil || buf.String() != "<i></i>" {
+		t.Errorf("unexpected result of replaced template %q %v", buf.String(), err)
+	}
+	// the pool of the predecessor is dropped
+	count := 0
+	tf.bindings.Range(func(key, value interface{}) bool {
+		count++
+		if value.(*bindingPool).source != replaced {
+			t.Errorf("unexpected pool of %v", key)
+		}
+		return true
+	})
+	if count != 1 {
+		t.Errorf("expected one pool, found %d", count)
+	}
+}
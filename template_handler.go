@@ -5,6 +5,8 @@
 package brot
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -12,15 +14,20 @@ import (
 	"github.com/fuxsig/brot/model"
 )
 
+// TemplateHandler loads the object of the id value and renders it with the
+// template value. With Views the template value names a view and the format
+// is negotiated, see ViewRegistry.Render, otherwise it names a template of
+// Templates.
 type TemplateHandler struct {
 	Model     *model.Butter     `brot:"model"`
 	Templates ProvidesTemplates `brot:"templates"`
+	Views     *Views            `brot:"views"`
 	Data      *DataLayer        `brot:"data"`
 }
 
 func (th *TemplateHandler) InitFunc() (err error) {
-	if th.Templates == nil {
-		panic("th.Templates == nil")
+	if th.Templates == nil && th.Views == nil {
+		panic("th.Templates == nil && th.Views == nil")
 	}
 	if th.Data == nil {
 		panic("th.Data == nil")
@@ -34,31 +41,45 @@ func (th *TemplateHandler) Retry() bool {
 
 func (th *TemplateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	if Debug {
-		Debug.Printf("TemplateHandler begins")
-		defer Debug.Printf("TemplateHandler ends")
+	l := logger("template")
+	l.DebugContext(r.Context(), "TemplateHandler begins")
+	defer l.DebugContext(r.Context(), "TemplateHandler ends")
+	values, err := th.Data.Parse(r)
+	if err != nil {
+		Error(w, r, http.StatusBadRequest, err)
+		return
 	}
-	values := th.Data.Values(r)
 	id, ok := values.Get("id")
 	if !ok {
-		http.Error(w, "Missing id value", http.StatusBadRequest)
+		Error(w, r, http.StatusBadRequest, errors.New("missing id value"))
 		return
 	}
 	var template string
 	template, ok = values.Get("template")
 	if !ok {
-		http.Error(w, "Missing template value", http.StatusBadRequest)
+		Error(w, r, http.StatusBadRequest, errors.New("missing template value"))
 		return
 	}
-	_, obj, err := th.Model.Load(nil, id)
+	status, obj, err := th.Model.Load(modelContext(r), id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Load error: %s", err.Error()), http.StatusBadRequest)
+		Error(w, r, status, fmt.Errorf("load error: %s", err.Error()))
 		return
 	}
-	err = th.Templates.TemplateFunc().ExecuteTemplate(w, template, obj)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Template error: %s", err.Error()), http.StatusInternalServerError)
+	if th.Views != nil {
+		th.Views.Render(w, r, template, status, obj)
+		return
+	}
+	// render into a buffer, a failing template must not leave half a page
+	var buf bytes.Buffer
+	if err = th.Templates.ExecuteTemplate(&buf, r, template, obj); err != nil {
+		if errors.Is(err, ErrTemplateNotFound) {
+			Error(w, r, http.StatusNotFound, err)
+		} else {
+			templateFailed(w, r, err)
+		}
+		return
 	}
+	buf.WriteTo(w)
 
 }
 
@@ -6,93 +6,199 @@ package brot
 
 import (
 	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/fuxsig/brot/model"
 
 	"github.com/fuxsig/brot/di"
 )
 
+// TemplateLoader parses the templates stored below Paths. A template is
+// named by its path relative to the base path without extension, e.g.
+// blog/post for blog/post.html. Templates in the Layouts (default layouts)
+// and Partials (default partials) directories of a base path are shared,
+// every other file is a page with a template set of its own. A page
+// invokes a layout, e.g. {{template "layouts/base" .}}, and overrides its
+// blocks with define, so that two pages defining content don't collide.
+// Cache decides whether templates are reloaded, see TemplateCache. Without
+// development mode a parse error stops the application. Funcs defaults to
+// the funcs of TemplateFuncs with Model.
 type TemplateLoader struct {
-	Paths    []string      `brot:"paths"`
-	Model    *model.Butter `brot:"model"`
-	template *template.Template
+	Paths    []string       `brot:"paths"`
+	Model    *model.Butter  `brot:"model"`
+	Layouts  string         `brot:"layouts"`
+	Partials string         `brot:"partials"`
+	Cache    *TemplateCache `brot:"cache"`
+	Funcs    *TemplateFuncs `brot:"funcs"`
 }
 
+// ErrTemplateNotFound is wrapped by the errors of unknown templates.
+var ErrTemplateNotFound = errors.New("template not found")
+
 type ProvidesTemplates interface {
-	TemplateFunc() *template.Template
+	// ExecuteTemplate executes the page or shared template name for r. The
+	// error wraps ErrTemplateNotFound if there is no such template.
+	ExecuteTemplate(w io.Writer, r *http.Request, name string, data interface{}) error
 }
 
-func (tl *TemplateLoader) TemplateFunc() *template.Template {
-	return tl.template
+// templateSet is the parsed content of the paths of a TemplateLoader.
+type templateSet struct {
+	shared *template.Template
+	pages  map[string]*template.Template
 }
 
-func (tl *TemplateLoader) InitFunc() {
-	for _, basePath := range tl.Paths {
+// templateFile is a template file found below a base path.
+type templateFile struct {
+	name, path string
+}
+
+// templateName returns the qualified name of the file at rel.
+func templateName(rel string) string {
+	rel = filepath.ToSlash(rel)
+	return strings.TrimSuffix(rel, filepath.Ext(rel))
+}
 
-		filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				if Warning {
-					Warning.Printf("Skipping dir %s, reason is: %s", path, err.Error())
-				}
-				return nil
-			}
-			// is it a regular file?
-			if info.Mode().IsRegular() {
-				var t *template.Template
-				base := filepath.Base(path)
-				ext := filepath.Ext(base)
-				name := base[0 : len(base)-len(ext)]
-				if tl.template == nil {
-					// first template
-					t = template.New(name).Funcs(template.FuncMap{
-						"dict": func(values ...interface{}) (map[string]interface{}, error) {
-							if len(values)%2 != 0 {
-								return nil, errors.New("invalid dict call")
-							}
-							dict := make(map[string]interface{}, len(values)/2)
-							for i := 0; i < len(values); i += 2 {
-								key, ok := values[i].(string)
-								if !ok {
-									return nil, errors.New("dict keys must be strings")
-								}
-								dict[key] = values[i+1]
-							}
-							return dict, nil
-						},
-						"schema": func(obj map[string]string) *model.Schema {
-							return tl.Model.Schema(obj["_schema"])
-						}})
-
-				} else {
-					t = tl.template.New(name)
-				}
-
-				content, err := ioutil.ReadFile(path)
-				if err != nil {
-					if Warning {
-						Warning.Printf("Skipping file %s, reason is %s", path, err.Error())
-					}
-				}
-
-				if result, err := t.Parse(string(content)); err != nil {
-					if Warning {
-						Warning.Printf("Skipping file %s, reason is %s", path, err.Error())
-					}
-				} else {
-					if Info {
-						Info.Printf("Parsed successfully template %s", path)
-						tl.template = result
-					}
-				}
-
-			}
+// collect returns the shared templates, the pages and the directories
+// below basePath.
+func (tl *TemplateLoader) collect(basePath string) (shared, pages, dirs []templateFile) {
+	l := logger("templates")
+	filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			l.Warn("skipping dir", "path", path, "error", err)
 			return nil
-		})
+		}
+		if info.IsDir() {
+			dirs = append(dirs, templateFile{"", path})
+			return nil
+		}
+		// is it a regular file?
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(basePath, path)
+		if err != nil {
+			return nil
+		}
+		file := templateFile{templateName(rel), path}
+		if dir := strings.SplitN(file.name, "/", 2)[0]; dir == tl.Layouts || dir == tl.Partials {
+			shared = append(shared, file)
+		} else {
+			pages = append(pages, file)
+		}
+		return nil
+	})
+	return
+}
+
+func parseTemplateFile(t *template.Template, file templateFile) (*template.Template, error) {
+	content, err := ioutil.ReadFile(file.path)
+	if err != nil {
+		return nil, err
+	}
+	return t.New(file.name).Parse(string(content))
+}
+
+// parse parses all templates, it stops at the first error.
+func (tl *TemplateLoader) parse() (value interface{}, files []templateFile, err error) {
+	var shared, pages []templateFile
+	for _, basePath := range tl.Paths {
+		s, p, d := tl.collect(basePath)
+		shared = append(shared, s...)
+		pages = append(pages, p...)
+		files = append(files, d...)
+	}
+	files = append(append(files, shared...), pages...)
+
+	l := logger("templates")
+	set := &templateSet{template.New("").Funcs(tl.Funcs.FuncMap()), make(map[string]*template.Template, len(pages))}
+	// all shared templates must be parsed before the pages clone them
+	for _, file := range shared {
+		if _, err = parseTemplateFile(set.shared, file); err != nil {
+			return
+		}
+		l.Debug("parsed template", "path", file.path, "name", file.name)
+	}
+	for _, file := range pages {
+		if _, ok := set.pages[file.name]; ok {
+			l.Warn("template overrides a template of a previous path", "path", file.path, "name", file.name)
+		}
+		var t *template.Template
+		if t, err = set.shared.Clone(); err != nil {
+			return
+		}
+		if t, err = parseTemplateFile(t, file); err != nil {
+			return
+		}
+		l.Debug("parsed template", "path", file.path, "name", file.name)
+		set.pages[file.name] = t
+	}
+	return set, files, nil
+}
+
+func (tl *TemplateLoader) key() string {
+	return fmt.Sprintf("loader:%p", tl)
+}
+
+func (tl *TemplateLoader) lookup(name string) (*template.Template, error) {
+	value, err := tl.Cache.load(tl.key(), tl.parse)
+	if err != nil {
+		return nil, err
+	}
+	set := value.(*templateSet)
+	if t, ok := set.pages[name]; ok {
+		return t, nil
+	}
+	if t := set.shared.Lookup(name); t != nil {
+		return t, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+}
+
+func (tl *TemplateLoader) ExecuteTemplate(w io.Writer, r *http.Request, name string, data interface{}) error {
+	t, err := tl.lookup(name)
+	if err != nil {
+		return err
 	}
+	return tl.Cache.executionError(tl.key(), tl.Funcs.executeCached(tl.key()+":"+name, t, w, r, data))
+}
+
+func (tl *TemplateLoader) InitFunc() (err error) {
+	if tl.Layouts == "" {
+		tl.Layouts = "layouts"
+	}
+	if tl.Partials == "" {
+		tl.Partials = "partials"
+	}
+	if tl.Cache == nil {
+		tl.Cache = defaultTemplateCache
+	}
+	if tl.Funcs == nil {
+		tl.Funcs = &TemplateFuncs{Model: tl.Model}
+	}
+	l := logger("templates")
+	if _, err = tl.Cache.load(tl.key(), tl.parse); err != nil {
+		if !tl.Cache.Dev {
+			return fmt.Errorf("could not parse templates: %w", err)
+		}
+		// in development mode the error is shown until it is fixed
+		l.Error("could not parse templates", "paths", tl.Paths, "error", err)
+		return nil
+	}
+	l.Info("parsed templates", "paths", tl.Paths)
+	return
+}
+
+func (tl *TemplateLoader) Retry() bool {
+	return false
 }
 
+var _ di.ProvidesInit = (*TemplateLoader)(nil)
+var _ ProvidesTemplates = (*TemplateLoader)(nil)
 var _ = di.GlobalScope.Declare(((*TemplateLoader)(nil)))
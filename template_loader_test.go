@@ -0,0 +1,192 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTemplateLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"layouts/base.html":  `[{{template "partials/nav" .}}|{{block "content" .}}empty{{end}}]`,
+		"partials/nav.html":  `nav`,
+		"index.html":         `{{template "layouts/base" .}}{{define "content"}}home {{.}}{{end}}`,
+		"blog/index.html":    `{{template "layouts/base" .}}{{define "content"}}blog{{end}}`,
+		"blog/draft.html":    `{{template "layouts/base" .}}`,
+		"error@html.html":    `error`,
+		"partials/list.html": `{{range .}}{{.}}{{end}}`,
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err = ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tl := &TemplateLoader{Paths: []string{dir}, Cache: &TemplateCache{}}
+	if err = tl.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		name   string
+		data   interface{}
+		result string
+	}{
+		{"index", "page", "[nav|home page]"},
+		{"blog/index", nil, "[nav|blog]"},
+		{"blog/draft", nil, "[nav|empty]"},
+		{"error@html", nil, "error"},
+		{"partials/list", []int{1, 2}, "12"},
+	}
+	for _, table := range tables {
+		var buf bytes.Buffer
+		if err = tl.ExecuteTemplate(&buf, nil, table.name, table.data); err != nil {
+			t.Errorf("%s: %s", table.name, err)
+		} else if buf.String() != table.result {
+			t.Errorf("%s: expected %q, found %q", table.name, table.result, buf.String())
+		}
+	}
+	for _, name := range []string{"base", "missing"} {
+		if err = tl.ExecuteTemplate(&bytes.Buffer{}, nil, name, nil); !errors.Is(err, ErrTemplateNotFound) {
+			t.Errorf("%s: expected ErrTemplateNotFound, found %v", name, err)
+		}
+	}
+}
+
+func TestTemplateCacheDev(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	page := filepath.Join(dir, "page.html")
+	write := func(content string, mtime time.Time) {
+		if err := ioutil.WriteFile(page, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(page, mtime, mtime)
+	}
+	now := time.Now()
+	write("v1", now.Add(-time.Hour))
+	tl := &TemplateLoader{Paths: []string{dir}, Cache: &TemplateCache{Dev: true, Interval: 1}}
+	if err = tl.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	execute := func() (string, error) {
+		var buf bytes.Buffer
+		err := tl.ExecuteTemplate(&buf, nil, "page", nil)
+		return buf.String(), err
+	}
+	if result, err := execute(); err != nil || result != "v1" {
+		t.Fatalf("expected v1, found %q %v", result, err)
+	}
+
+	write("line 1\n{{if}}\nline 3", now.Add(-time.Minute))
+	time.Sleep(2 * time.Millisecond)
+	_, err = execute()
+	var te *TemplateError
+	if !errors.As(err, &te) {
+		t.Fatalf("expected TemplateError, found %v", err)
+	}
+	if te.File != page || te.Line != 2 || len(te.Context) != 3 || !te.Context[1].Current {
+		t.Errorf("unexpected position %s:%d %v", te.File, te.Line, te.Context)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/html")
+	templateFailed(w, r, err)
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "{{if}}") {
+		t.Errorf("expected overlay, found %d %s", w.Code, w.Body.String())
+	}
+
+	write("{{.Missing.Field}}", now)
+	time.Sleep(2 * time.Millisecond)
+	var buf bytes.Buffer
+	err = tl.ExecuteTemplate(&buf, nil, "page", map[string]interface{}{"Missing": 1})
+	if !errors.As(err, &te) || te.File != page || te.Line != 1 {
+		t.Errorf("expected execution error in %s, found %v", page, err)
+	}
+
+	// production mode keeps the first result
+	tc := &TemplateCache{}
+	parses := 0
+	parse := func() (interface{}, []templateFile, error) {
+		parses++
+		return parses, []templateFile{{"page.html", page}}, nil
+	}
+	tc.load("key", parse)
+	write("v2", now.Add(time.Minute))
+	if value, _ := tc.load("key", parse); value != 1 {
+		t.Errorf("expected cached value, found %v", value)
+	}
+}
+
+func TestTemplateCacheConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	page := filepath.Join(dir, "page.html")
+	if err = ioutil.WriteFile(page, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// with an interval of 1ms requests keep checking the files
+	tc := &TemplateCache{Dev: true, Interval: 1}
+	parse := func() (interface{}, []templateFile, error) {
+		return "v1", []templateFile{{"page.html", page}}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deadline := time.Now().Add(20 * time.Millisecond)
+			for time.Now().Before(deadline) {
+				if value, err := tc.load("key", parse); value != "v1" || err != nil {
+					t.Errorf("expected v1, found %v %v", value, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTemplateLoaderParseError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(`{{if}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// without development mode the error fails the initialization
+	if err = (&TemplateLoader{Paths: []string{dir}, Cache: &TemplateCache{}}).InitFunc(); err == nil {
+		t.Error("expected parse error")
+	}
+	if err = (&TemplateLoader{Paths: []string{dir}, Cache: &TemplateCache{Dev: true}}).InitFunc(); err != nil {
+		t.Errorf("expected no error in development mode, found %v", err)
+	}
+	if err = (&TemplateFileHandler{Dir: dir, Cache: &TemplateCache{}}).InitFunc(); err == nil {
+		t.Error("expected parse error of page")
+	}
+}
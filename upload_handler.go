@@ -21,23 +21,41 @@ import (
 	"strings"
 
 	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/model"
 )
 
+// UploadHandler stores uploaded images and returns them by the key value.
+// The xMin, xMax, yMin and yMax values crop the image, they must lie within
+// the image and default to its bounds. InitFunc adds their rules to Data.
 type UploadHandler struct {
 	Files     *FileDB    `brot:"files"`
 	Parameter string     `brot:"parameter"`
 	Data      *DataLayer `brot:"data"`
 }
 
+func (th *UploadHandler) InitFunc() error {
+	zero := 0.0
+	crop := &ValueRule{Type: IntValue, Min: &zero, MaxCount: 1}
+	th.Data = th.Data.extend(map[string]*ValueRule{"xMin": crop, "xMax": crop, "yMin": crop, "yMax": crop})
+	return th.Data.InitFunc()
+}
+
+func (th *UploadHandler) Retry() bool {
+	return false
+}
+
 func (th *UploadHandler) HandlerFunc() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if Debug {
-			Debug.Printf("UploadHandler begins")
-			defer Debug.Printf("UploadHandler ends")
-		}
+		l := logger("upload")
+		l.DebugContext(r.Context(), "UploadHandler begins")
+		defer l.DebugContext(r.Context(), "UploadHandler ends")
 		switch r.Method {
 		case "GET":
-			values := th.Data.Values(r)
+			values, err := th.Data.Parse(r)
+			if err != nil {
+				Error(w, r, http.StatusBadRequest, err)
+				return
+			}
 			if key, ok := values.Get("key"); ok {
 				fi, err := th.Files.Info(key)
 				if err != nil {
@@ -46,16 +64,11 @@ func (th *UploadHandler) HandlerFunc() http.Handler {
 				}
 
 				var (
-					xMinPar, xMaxPar, yMinPar, yMaxPar, maskPar, srcPar       string
-					xMinFlag, xMaxFlag, yMinFlag, yMaxFlag, maskFlag, srcFlag bool
+					maskPar, srcPar   string
+					maskFlag, srcFlag bool
 				)
-				xMinPar, xMinFlag = values.Get("xMin")
-				xMaxPar, xMaxFlag = values.Get("xMax")
-				yMinPar, yMinFlag = values.Get("yMin")
-				yMaxPar, yMaxFlag = values.Get("yMax")
 				maskPar, maskFlag = values.Get("mask")
 				srcPar, srcFlag = values.Get("src")
-
 				if maskFlag {
 					var mfi *FileInfo
 					mfi, err = th.Files.Info(maskPar)
@@ -117,7 +130,7 @@ func (th *UploadHandler) HandlerFunc() http.Handler {
 					draw.DrawMask(dest, bounds, img, image.ZP, maske, image.ZP, draw.Over)
 					writeImage(w, dest, fi.ContentType)
 
-				} else if xMinFlag || xMaxFlag || yMinFlag || yMaxFlag {
+				} else if cropped(values) {
 					var img image.Image
 					img, err = th.Files.Image(fi)
 					if err != nil {
@@ -125,34 +138,14 @@ func (th *UploadHandler) HandlerFunc() http.Handler {
 						return
 					}
 					bounds := img.Bounds()
-					width := bounds.Dx()
-					height := bounds.Dy()
-					xMin := 0
-					if xMinFlag {
-						if xMin, err = strconv.Atoi(xMinPar); err != nil || xMin < 0 {
-							xMin = 0
-						}
-					}
-					xMax := width
-					if xMaxFlag {
-						if xMax, err = strconv.Atoi(xMaxPar); err != nil || xMax > width {
-							xMax = width
-						}
-					}
-					yMin := 0
-					if yMinFlag {
-						if yMin, err = strconv.Atoi(yMinPar); err != nil || yMin < 0 {
-							yMin = 0
-						}
-					}
-					yMax := height
-					if yMaxFlag {
-						if yMax, err = strconv.Atoi(yMaxPar); err != nil || yMax > height {
-							yMax = height
-						}
+					var ve model.ValidationError
+					xMin, xMax := cropRange(values, "xMin", "xMax", bounds.Dx(), &ve)
+					yMin, yMax := cropRange(values, "yMin", "yMax", bounds.Dy(), &ve)
+					if ve.Fields != nil {
+						Error(w, r, http.StatusBadRequest, &ve)
+						return
 					}
-					cropped := cropImage(xMin, xMax, yMin, yMax, img)
-					writeImage(w, cropped, fi.ContentType)
+					writeImage(w, cropImage(xMin, xMax, yMin, yMax, img), fi.ContentType)
 				} else {
 					f, err := os.Open(fi.Path)
 					if err != nil {
@@ -163,7 +156,7 @@ func (th *UploadHandler) HandlerFunc() http.Handler {
 
 					h := w.Header()
 					h.Set("Content-Type", fi.ContentType)
-					h.Set("Content-Length", string(fi.Size))
+					h.Set("Content-Length", strconv.FormatInt(fi.Size, 10))
 					_, err = io.Copy(w, f)
 					if err != nil {
 						w.WriteHeader(http.StatusInternalServerError)
@@ -186,12 +179,10 @@ func (th *UploadHandler) HandlerFunc() http.Handler {
 						JsonResponse(w, http.StatusCreated, `{"status":"ok","message":"bravo","key":"%s"}`, fi.Key)
 
 					} else {
-						InternalError(w, err)
+						InternalError(w, r, err)
 					}
 				} else {
-					if Debug {
-						Debug.Printf("UploadHandler error: %s", err.Error())
-					}
+					l.DebugContext(r.Context(), "UploadHandler error", "error", err)
 					JsonResponse(w, http.StatusBadRequest, `{"status":"error","message":"Reason: %s"}`, err.Error())
 					return
 				}
@@ -216,7 +207,7 @@ func (th *UploadHandler) HandlerFunc() http.Handler {
 			if fi, err := th.Files.Store(file, handle.Filename, mimeType, extension); err == nil {
 				JsonResponse(w, http.StatusCreated, `{"status":"ok","message":"bravo","key":"%s"}`, fi.Key)
 			} else {
-				InternalError(w, err)
+				InternalError(w, r, err)
 			}
 
 		}
@@ -244,10 +235,37 @@ func writeImage(w http.ResponseWriter, img image.Image, contentType string) {
 	}
 	header := w.Header()
 	header.Set("Content-Type", contentType)
-	header.Set("Content-Length", string(buffer.Len()))
+	header.Set("Content-Length", strconv.Itoa(buffer.Len()))
 	io.Copy(w, &buffer)
 }
 
+// cropped reports whether the request crops the image.
+func cropped(values MultiValueMap) bool {
+	for _, name := range []string{"xMin", "xMax", "yMin", "yMax"} {
+		if _, ok := values[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cropRange returns the crop range of the values min and max, which default
+// to 0 and size. Ranges beyond size or without pixels are violations.
+func cropRange(values MultiValueMap, min, max string, size int, ve *model.ValidationError) (low, high int) {
+	low, _ = values.Int(min)
+	high, ok := values.Int(max)
+	if !ok {
+		high = size
+	}
+	switch {
+	case high > size:
+		ve.Add(max, model.MaxViolation, "%s is above the image size %d", max, size)
+	case low >= high:
+		ve.Add(min, model.ValueViolation, "%s must be below %s", min, max)
+	}
+	return
+}
+
 func cropImage(xMin, xMax, yMin, yMax int, img image.Image) image.Image {
 	dest := image.NewRGBA(image.Rect(xMin, yMin, xMax, yMax))
 	sp := image.Point{xMin, yMin}
@@ -255,10 +273,8 @@ func cropImage(xMin, xMax, yMin, yMax int, img image.Image) image.Image {
 	return dest
 }
 
-func InternalError(w http.ResponseWriter, err error) {
-	if Debug {
-		Debug.Printf("UploadHandler error: %s", err.Error())
-	}
+func InternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logger("upload").ErrorContext(r.Context(), "UploadHandler error", "error", err)
 	JsonResponse(w, http.StatusInternalServerError, `{"status":"error","message":"Reason: %s"}`, err.Error())
 }
 
@@ -268,5 +284,6 @@ func JsonResponse(w http.ResponseWriter, code int, message string, args ...inter
 	fmt.Fprintf(w, message, args...)
 }
 
+var _ di.ProvidesInit = (*UploadHandler)(nil)
 var _ ProvidesHandler = (*UploadHandler)(nil)
 var _ = di.GlobalScope.Declare((*UploadHandler)(nil))
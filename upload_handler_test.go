@@ -0,0 +1,58 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fuxsig/brot/model"
+)
+
+func TestUploadHandlerCrop(t *testing.T) {
+	uh := &UploadHandler{Data: &DataLayer{Providers: []ValueProvider{&URLParameterProvider{
+		Mapping: map[string]string{"key": "key", "xMin": "xMin", "xMax": "xMax", "yMin": "yMin", "yMax": "yMax"}}}}}
+	if err := uh.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	tables := []struct {
+		query    string
+		cropped  bool
+		expected [4]int
+		errors   string
+	}{
+		{"", false, [4]int{0, 40, 0, 30}, ""},
+		{"xMin=10&yMax=20", true, [4]int{10, 40, 0, 20}, ""},
+		{"xMin=0&xMax=40&yMin=29&yMax=30", true, [4]int{0, 40, 29, 30}, ""},
+		{"xMax=41&yMax=31", true, [4]int{0, 41, 0, 31}, "xMax:max,yMax:max"},
+		{"xMin=20&xMax=20", true, [4]int{20, 20, 0, 30}, "xMin:value"},
+	}
+	for _, table := range tables {
+		values, err := uh.Data.Parse(httptest.NewRequest("GET", "/?key=a&"+table.query, nil))
+		if err != nil {
+			t.Fatalf("%s: %s", table.query, err)
+		}
+		var ve model.ValidationError
+		var found [4]int
+		found[0], found[1] = cropRange(values, "xMin", "xMax", 40, &ve)
+		found[2], found[3] = cropRange(values, "yMin", "yMax", 30, &ve)
+		var codes []string
+		for _, field := range ve.Fields {
+			codes = append(codes, field.Field+":"+field.Code)
+		}
+		if cropped(values) != table.cropped || found != table.expected || strings.Join(codes, ",") != table.errors {
+			t.Errorf("%s: expected %v %v %s, found %v %v %v", table.query, table.cropped, table.expected, table.errors,
+				cropped(values), found, codes)
+		}
+	}
+
+	// the rules reject values which are no valid coordinates
+	for _, query := range []string{"xMin=-1", "xMax=ten", "yMin=1&yMin=2"} {
+		if _, err := uh.Data.Parse(httptest.NewRequest("GET", "/?key=a&"+query, nil)); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
@@ -1,24 +1,46 @@
 package brot
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/fuxsig/brot/di"
 	"github.com/fuxsig/brot/wrapper"
 )
 
+// WrapperHandler runs Wrapper and then Middleware in front of Handler.
+// Wrapper holds objects supporting wrapper.Handler, Middleware objects
+// supporting ProvidesWrapper like the middlewares of a router. Both are
+// injected by name, so that they are initialized before the handler.
 type WrapperHandler struct {
-	Wrapper []wrapper.Handler `brot:"wrapper,mandatory"`
-	Handler ProvidesHandler   `brot:"handler,mandatory"`
-	w       *wrapper.Wrapper
+	Wrapper    []wrapper.Handler `brot:"wrapper"`
+	Middleware []ProvidesWrapper `brot:"middleware"`
+	Handler    ProvidesHandler   `brot:"handler,mandatory"`
+	w          *wrapper.Wrapper
 }
 
-func (h *WrapperHandler) InitFunc() (err error) {
-	args := make([]wrapper.Handler, len(h.Wrapper)+1)
-	copy(args, h.Wrapper)
-	args[len(h.Wrapper)] = wrapper.Wrap(h.Handler.HandlerFunc())
+func (h *WrapperHandler) InitFunc() error {
+	if h.Handler == nil {
+		return errors.New("handler must be set")
+	}
+	args := make([]wrapper.Handler, 0, len(h.Wrapper)+len(h.Middleware)+1)
+	// objects which could not be injected are nil
+	for _, current := range h.Wrapper {
+		if current == nil {
+			return errors.New("wrapper does not support wrapper.Handler")
+		}
+		args = append(args, current)
+	}
+	for _, current := range h.Middleware {
+		if current == nil {
+			return errors.New("middleware does not support ProvidesWrapper")
+		}
+		args = append(args, wrapper.FromMiddleware(current.WrapperFunc()))
+	}
+	args = append(args, wrapper.Wrap(h.Handler.HandlerFunc()))
 	h.w = wrapper.New(args...)
-	return
+	return nil
 }
 
 func (h *WrapperHandler) Retry() bool {
@@ -29,6 +51,20 @@ func (h *WrapperHandler) HandlerFunc() http.Handler {
 	return h.w
 }
 
+// lookupMiddleware returns the object with the given name as middleware.
+func lookupMiddleware(name string) (func(http.Handler) http.Handler, error) {
+	switch obj := di.GlobalScope.Get(name).(type) {
+	case nil:
+		return nil, fmt.Errorf("object %s does not exist", name)
+	case ProvidesWrapper:
+		return obj.WrapperFunc(), nil
+	case wrapper.Handler:
+		return wrapper.Middleware(obj), nil
+	default:
+		return nil, fmt.Errorf("object %s supports neither wrapper.Handler nor ProvidesWrapper", name)
+	}
+}
+
 var _ di.ProvidesInit = (*WrapperHandler)(nil)
 var _ ProvidesHandler = (*WrapperHandler)(nil)
 var _ = di.GlobalScope.Declare((*WrapperHandler)(nil))
@@ -0,0 +1,58 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fuxsig/brot/di"
+	"github.com/fuxsig/brot/wrapper"
+)
+
+// headerMiddleware adds its header to the responses.
+type headerMiddleware string
+
+func (m headerMiddleware) WrapperFunc() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Chain", string(m))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type okHandler struct{}
+
+func (okHandler) HandlerFunc() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestWrapperHandler(t *testing.T) {
+	di.GlobalScope.Set("testWrapper", wrapper.FromMiddleware(headerMiddleware("wrapper").WrapperFunc()))
+	di.GlobalScope.Set("testMiddleware", headerMiddleware("middleware"))
+	di.GlobalScope.Set("testHandler", okHandler{})
+	obj, err := di.GlobalScope.Allocate("brot.WrapperHandler", map[string]interface{}{
+		"wrapper":    []interface{}{"testWrapper"},
+		"middleware": "testMiddleware",
+		"handler":    "testHandler",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	obj.(*WrapperHandler).HandlerFunc().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if chain := w.Header()["X-Chain"]; w.Code != http.StatusOK || len(chain) != 2 || chain[0] != "wrapper" || chain[1] != "middleware" {
+		t.Errorf("expected the wrapper before the middleware, found %d %v", w.Code, chain)
+	}
+	// a middleware cannot be injected as wrapper.Handler
+	h := &WrapperHandler{Wrapper: []wrapper.Handler{nil}, Handler: okHandler{}}
+	if err = h.InitFunc(); err == nil {
+		t.Error("expected an error for a missing wrapper")
+	}
+}
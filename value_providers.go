@@ -0,0 +1,312 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/fuxsig/brot/di"
+)
+
+// All providers in this file share the configuration of MuxVarsProvider:
+// Mapping maps the name of a source value to the name of the DataLayer value.
+
+// HeaderProvider provides request header values.
+type HeaderProvider struct {
+	Mapping map[string]string `brot:"mapping"`
+}
+
+func (hp *HeaderProvider) Initialize(request *http.Request, m map[string][]string) {
+	for key, value := range hp.Mapping {
+		if parameters := request.Header.Values(key); len(parameters) > 0 {
+			m[value] = append(m[value], parameters...)
+		}
+	}
+}
+
+func (hp *HeaderProvider) CapacityReco() int {
+	return len(hp.Mapping)
+}
+
+var _ ValueProvider = (*HeaderProvider)(nil)
+var _ = di.GlobalScope.Declare((*HeaderProvider)(nil))
+
+// CookieProvider provides cookie values.
+type CookieProvider struct {
+	Mapping map[string]string `brot:"mapping"`
+}
+
+func (cp *CookieProvider) Initialize(request *http.Request, m map[string][]string) {
+	for key, value := range cp.Mapping {
+		if cookie, err := request.Cookie(key); err == nil && cookie.Value != "" {
+			m[value] = append(m[value], cookie.Value)
+		}
+	}
+}
+
+func (cp *CookieProvider) CapacityReco() int {
+	return len(cp.Mapping)
+}
+
+var _ ValueProvider = (*CookieProvider)(nil)
+var _ = di.GlobalScope.Declare((*CookieProvider)(nil))
+
+// FormProvider provides the fields of an url encoded or multipart form body.
+// MaxMemory limits the bytes of a multipart form kept in memory.
+type FormProvider struct {
+	Mapping   map[string]string `brot:"mapping"`
+	MaxMemory int64             `brot:"maxMemory"`
+}
+
+func (fp *FormProvider) Initialize(request *http.Request, m map[string][]string) {
+	ct, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	var form map[string][]string
+	if ct == "multipart/form-data" {
+		maxMemory := fp.MaxMemory
+		if maxMemory <= 0 {
+			maxMemory = 32 << 20
+		}
+		if err := request.ParseMultipartForm(maxMemory); err != nil || request.MultipartForm == nil {
+			logger("data").DebugContext(request.Context(), "could not parse multipart form", "error", err)
+			return
+		}
+		form = request.MultipartForm.Value
+	} else {
+		if err := request.ParseForm(); err != nil {
+			logger("data").DebugContext(request.Context(), "could not parse form", "error", err)
+			return
+		}
+		form = request.PostForm
+	}
+	for key, value := range fp.Mapping {
+		if parameters := form[key]; len(parameters) > 0 {
+			m[value] = append(m[value], parameters...)
+		}
+	}
+}
+
+func (fp *FormProvider) CapacityReco() int {
+	return len(fp.Mapping)
+}
+
+var _ ValueProvider = (*FormProvider)(nil)
+var _ = di.GlobalScope.Declare((*FormProvider)(nil))
+
+// JSONBodyProvider provides the fields of a JSON object body. Keys of the
+// mapping are paths like author.name, array elements become separate
+// values. The body is restored, so that the handler can read it again.
+// Bodies larger than MaxBytes, which defaults to 1 MB, provide nothing and
+// are passed on unchanged.
+type JSONBodyProvider struct {
+	Mapping  map[string]string `brot:"mapping"`
+	MaxBytes int64             `brot:"maxBytes"`
+}
+
+func (jp *JSONBodyProvider) Initialize(request *http.Request, m map[string][]string) {
+	if request.Body == nil {
+		return
+	}
+	ct, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if ct != "application/json" && !strings.HasSuffix(ct, "+json") {
+		return
+	}
+	maxBytes := jp.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 1 << 20
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(request.Body, maxBytes+1))
+	// the handler reads the bytes consumed here followed by the rest
+	request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+	if err != nil {
+		logger("data").DebugContext(request.Context(), "could not read json body", "error", err)
+		return
+	}
+	if int64(len(body)) > maxBytes {
+		logger("data").DebugContext(request.Context(), "json body too large", "maxBytes", maxBytes)
+		return
+	}
+	var doc map[string]interface{}
+	if err = json.Unmarshal(body, &doc); err != nil {
+		logger("data").DebugContext(request.Context(), "could not parse json body", "error", err)
+		return
+	}
+	for key, value := range jp.Mapping {
+		if parameter, ok := lookupPath(doc, key); ok {
+			addValues(m, value, stringValues(parameter))
+		}
+	}
+}
+
+func (jp *JSONBodyProvider) CapacityReco() int {
+	return len(jp.Mapping)
+}
+
+var _ ValueProvider = (*JSONBodyProvider)(nil)
+var _ = di.GlobalScope.Declare((*JSONBodyProvider)(nil))
+
+// SessionProvider provides values stored in the session, e.g. email and
+// given_name after an Okta login. Session defaults to brot-store.
+type SessionProvider struct {
+	Mapping map[string]string `brot:"mapping"`
+	Session string            `brot:"session"`
+}
+
+func (sp *SessionProvider) Initialize(request *http.Request, m map[string][]string) {
+	name := sp.Session
+	if name == "" {
+		name = "brot-store"
+	}
+	session, err := sessionStore.Get(request, name)
+	if err != nil {
+		logger("data").DebugContext(request.Context(), "session error", "error", err)
+		return
+	}
+	for key, value := range sp.Mapping {
+		if parameter, ok := session.Values[key]; ok {
+			addValues(m, value, stringValues(parameter))
+		}
+	}
+}
+
+func (sp *SessionProvider) CapacityReco() int {
+	return len(sp.Mapping)
+}
+
+var _ ValueProvider = (*SessionProvider)(nil)
+var _ = di.GlobalScope.Declare((*SessionProvider)(nil))
+
+// JWTClaimsProvider provides the claims of the bearer token of the request.
+// Keys of the mapping are claim paths like realm_access.roles, array
+// elements become separate values. Requests without a valid token provide
+// nothing.
+type JWTClaimsProvider struct {
+	Mapping  map[string]string `brot:"mapping"`
+	Verifier *JWTVerifier      `brot:"verifier,mandatory"`
+}
+
+func (jp *JWTClaimsProvider) Initialize(request *http.Request, m map[string][]string) {
+	claims, err := jp.Verifier.VerifyRequest(request)
+	if err != nil {
+		if err != errNoBearerToken {
+			logger("data").DebugContext(request.Context(), "invalid bearer token", "error", err)
+		}
+		return
+	}
+	for key, value := range jp.Mapping {
+		if parameter, ok := lookupPath(claims, key); ok {
+			addValues(m, value, stringValues(parameter))
+		}
+	}
+}
+
+func (jp *JWTClaimsProvider) CapacityReco() int {
+	return len(jp.Mapping)
+}
+
+var _ ValueProvider = (*JWTClaimsProvider)(nil)
+var _ = di.GlobalScope.Declare((*JWTClaimsProvider)(nil))
+
+// PathRegexpProvider provides the named groups of Regexp matched against
+// the request path, or against its last element if Base is set, e.g.
+// ^(?P<id>[0-9]+)\.html$. Mapping maps group names to DataLayer names, a
+// group without mapping keeps its name.
+type PathRegexpProvider struct {
+	Regexp  string            `brot:"regexp,mandatory"`
+	Base    bool              `brot:"base"`
+	Mapping map[string]string `brot:"mapping"`
+	re      *regexp.Regexp
+}
+
+func (pp *PathRegexpProvider) InitFunc() (err error) {
+	pp.re, err = regexp.Compile(pp.Regexp)
+	return
+}
+
+func (pp *PathRegexpProvider) Retry() bool {
+	return false
+}
+
+func (pp *PathRegexpProvider) Initialize(request *http.Request, m map[string][]string) {
+	str := request.URL.Path
+	if pp.Base {
+		str = path.Base(str)
+	}
+	matches := pp.re.FindStringSubmatch(str)
+	for i, name := range pp.re.SubexpNames() {
+		if i == 0 || name == "" || i >= len(matches) || matches[i] == "" {
+			continue
+		}
+		if value, ok := pp.Mapping[name]; ok {
+			name = value
+		}
+		m[name] = append(m[name], matches[i])
+	}
+}
+
+func (pp *PathRegexpProvider) CapacityReco() int {
+	if pp.re == nil {
+		return 0
+	}
+	return pp.re.NumSubexp()
+}
+
+var _ di.ProvidesInit = (*PathRegexpProvider)(nil)
+var _ ValueProvider = (*PathRegexpProvider)(nil)
+var _ = di.GlobalScope.Declare((*PathRegexpProvider)(nil))
+
+// lookupPath follows a dot separated path through nested objects.
+func lookupPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// addValues appends values to the values of name, if there are any.
+func addValues(m map[string][]string, name string, values []string) {
+	if len(values) > 0 {
+		m[name] = append(m[name], values...)
+	}
+}
+
+// stringValues converts scalars to a single string and arrays to one string
+// per element. Objects and null values are skipped.
+func stringValues(value interface{}) []string {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, current := range value {
+			result = append(result, stringValues(current)...)
+		}
+		return result
+	case []string:
+		return value
+	default:
+		if str, err := di.GetString(value); err == nil {
+			return []string{str}
+		}
+		return nil
+	}
+}
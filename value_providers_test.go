@@ -0,0 +1,282 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cr "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValueProviders(t *testing.T) {
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	mw.WriteField("title", "Hello")
+	mw.WriteField("tags", "a")
+	mw.WriteField("tags", "b")
+	mw.Close()
+
+	w := httptest.NewRecorder()
+	session, _ := sessionStore.New(httptest.NewRequest("GET", "/", nil), "brot-store")
+	session.Values["email"] = "ann@example.com"
+	session.Values["groups"] = []string{"staff", "admins"}
+	session.Save(httptest.NewRequest("GET", "/", nil), w)
+	sessionCookies := w.Result().Cookies()
+
+	verifier := &JWTVerifier{Secret: "secret"}
+	if err := verifier.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":          "alice",
+		"realm_access": map[string]interface{}{"roles": []string{"admin", "other"}},
+	}).SignedString([]byte("secret"))
+
+	tables := []struct {
+		provider ValueProvider
+		request  func() *http.Request
+		expected map[string][]string
+	}{
+		{
+			&HeaderProvider{Mapping: map[string]string{"X-Tenant": "tenant", "Accept-Language": "lang"}},
+			func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Add("X-Tenant", "a")
+				r.Header.Add("X-Tenant", "b")
+				return r
+			},
+			map[string][]string{"tenant": {"a", "b"}},
+		},
+		{
+			&CookieProvider{Mapping: map[string]string{"theme": "theme", "empty": "empty", "missing": "missing"}},
+			func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+				r.AddCookie(&http.Cookie{Name: "empty", Value: ""})
+				return r
+			},
+			map[string][]string{"theme": {"dark"}},
+		},
+		{
+			&FormProvider{Mapping: map[string]string{"title": "title", "tags": "tag"}},
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/?title=query", strings.NewReader("title=Hello&tags=a&tags=b"))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return r
+			},
+			map[string][]string{"title": {"Hello"}, "tag": {"a", "b"}},
+		},
+		{
+			&FormProvider{Mapping: map[string]string{"title": "title", "tags": "tag"}},
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/", bytes.NewReader(multipartBody.Bytes()))
+				r.Header.Set("Content-Type", mw.FormDataContentType())
+				return r
+			},
+			map[string][]string{"title": {"Hello"}, "tag": {"a", "b"}},
+		},
+		{
+			&JSONBodyProvider{Mapping: map[string]string{"title": "title", "author.name": "author", "tags": "tag",
+				"views": "views", "draft": "draft", "author": "object", "missing": "missing", "none": "none"}},
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/", strings.NewReader(
+					`{"title":"Hello","author":{"name":"Ann"},"tags":["a","b",{"x":1}],"views":3,"draft":true,"none":null}`))
+				r.Header.Set("Content-Type", "application/json; charset=utf-8")
+				return r
+			},
+			map[string][]string{"title": {"Hello"}, "author": {"Ann"}, "tag": {"a", "b"}, "views": {"3"}, "draft": {"true"}},
+		},
+		{
+			&JSONBodyProvider{Mapping: map[string]string{"title": "title"}},
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/", strings.NewReader(`{"title":"Hello"}`))
+				r.Header.Set("Content-Type", "text/plain")
+				return r
+			},
+			map[string][]string{},
+		},
+		{
+			&JSONBodyProvider{Mapping: map[string]string{"title": "title"}, MaxBytes: 10},
+			func() *http.Request {
+				r := httptest.NewRequest("POST", "/", strings.NewReader(`{"title":"Hello"}`))
+				r.Header.Set("Content-Type", "application/json")
+				return r
+			},
+			map[string][]string{},
+		},
+		{
+			&SessionProvider{Mapping: map[string]string{"email": "email", "groups": "group", "token": "token"}},
+			func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				for _, cookie := range sessionCookies {
+					r.AddCookie(cookie)
+				}
+				return r
+			},
+			map[string][]string{"email": {"ann@example.com"}, "group": {"staff", "admins"}},
+		},
+		{
+			&SessionProvider{Mapping: map[string]string{"email": "email"}},
+			func() *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			map[string][]string{},
+		},
+		{
+			&JWTClaimsProvider{Mapping: map[string]string{"sub": "user", "realm_access.roles": "role"}, Verifier: verifier},
+			func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("Authorization", "bearer "+token)
+				return r
+			},
+			map[string][]string{"user": {"alice"}, "role": {"admin", "other"}},
+		},
+		{
+			&JWTClaimsProvider{Mapping: map[string]string{"sub": "user"}, Verifier: verifier},
+			func() *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				r.Header.Set("Authorization", "Bearer "+token+"x")
+				return r
+			},
+			map[string][]string{},
+		},
+	}
+	for i, table := range tables {
+		if found := provided(table.provider, table.request()); !reflect.DeepEqual(found, table.expected) {
+			t.Errorf("%d %T: expected %v, found %v", i, table.provider, table.expected, found)
+		}
+	}
+}
+
+func TestJSONBodyProviderRestoresBody(t *testing.T) {
+	for _, maxBytes := range []int64{0, 10} {
+		body := `{"title":"Hello","tags":["a","b"]}`
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		provided(&JSONBodyProvider{Mapping: map[string]string{"title": "title"}, MaxBytes: maxBytes}, r)
+		if found, err := ioutil.ReadAll(r.Body); err != nil || string(found) != body {
+			t.Errorf("%d: expected body %s, found %s (%v)", maxBytes, body, found, err)
+		}
+	}
+}
+
+func TestPathRegexpProvider(t *testing.T) {
+	tables := []struct {
+		provider *PathRegexpProvider
+		path     string
+		expected map[string][]string
+	}{
+		{&PathRegexpProvider{Regexp: `^/posts/(?P<id>[0-9]+)$`}, "/posts/12", map[string][]string{"id": {"12"}}},
+		{&PathRegexpProvider{Regexp: `^(?P<id>[0-9]+)\.(?P<format>\w+)$`, Base: true, Mapping: map[string]string{"id": "post"}},
+			"/blog/12.xml", map[string][]string{"post": {"12"}, "format": {"xml"}}},
+		{&PathRegexpProvider{Regexp: `^/posts/(?P<id>[0-9]+)$`}, "/posts/new", map[string][]string{}},
+	}
+	for _, table := range tables {
+		if err := table.provider.InitFunc(); err != nil {
+			t.Fatal(err)
+		}
+		if found := provided(table.provider, httptest.NewRequest("GET", table.path, nil)); !reflect.DeepEqual(found, table.expected) {
+			t.Errorf("%s: expected %v, found %v", table.path, table.expected, found)
+		}
+	}
+	if err := (&PathRegexpProvider{Regexp: "("}).InitFunc(); err == nil {
+		t.Error("expected invalid regexp error")
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	secret := []byte("secret")
+	valid := jwt.MapClaims{"sub": "alice", "iss": "https://issuer", "aud": []string{"api", "web"},
+		"exp": time.Now().Add(time.Hour).Unix()}
+	tables := []struct {
+		verifier *JWTVerifier
+		token    string
+		valid    bool
+	}{
+		{&JWTVerifier{Secret: "secret"}, sign(jwt.SigningMethodHS256, secret, valid), true},
+		{&JWTVerifier{Secret: "other"}, sign(jwt.SigningMethodHS256, secret, valid), false},
+		{&JWTVerifier{Secret: "secret", Methods: []string{"HS512"}}, sign(jwt.SigningMethodHS256, secret, valid), false},
+		{&JWTVerifier{Secret: "secret", Issuer: "https://issuer", Audience: "api"}, sign(jwt.SigningMethodHS256, secret, valid), true},
+		{&JWTVerifier{Secret: "secret", Issuer: "https://other"}, sign(jwt.SigningMethodHS256, secret, valid), false},
+		{&JWTVerifier{Secret: "secret", Audience: "admin"}, sign(jwt.SigningMethodHS256, secret, valid), false},
+		{&JWTVerifier{Secret: "secret", Audience: "api"}, sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"aud": "api"}), true},
+		{&JWTVerifier{Secret: "secret"}, sign(jwt.SigningMethodHS256, secret, jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), false},
+		{&JWTVerifier{Secret: "secret"}, sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid), false},
+		{&JWTVerifier{Secret: "secret"}, "garbage", false},
+	}
+	for i, table := range tables {
+		if err := table.verifier.InitFunc(); err != nil {
+			t.Fatal(err)
+		}
+		claims, err := table.verifier.Verify(table.token)
+		if (err == nil) != table.valid || table.valid && claims["sub"] == nil && claims["aud"] == nil {
+			t.Errorf("%d: expected valid %v, found %v %v", i, table.valid, claims, err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsaKey, _ := rsa.GenerateKey(cr.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), cr.Reader)
+	keys := []struct {
+		name    string
+		method  jwt.SigningMethod
+		private interface{}
+		public  interface{}
+	}{
+		{"rsa.pem", jwt.SigningMethodRS256, rsaKey, &rsaKey.PublicKey},
+		{"ec.pem", jwt.SigningMethodES256, ecKey, &ecKey.PublicKey},
+	}
+	for _, key := range keys {
+		der, _ := x509.MarshalPKIXPublicKey(key.public)
+		path := filepath.Join(dir, key.name)
+		ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+		verifier := &JWTVerifier{PublicKey: path}
+		if err = verifier.InitFunc(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = verifier.Verify(sign(key.method, key.private, valid)); err != nil {
+			t.Errorf("%s: %s", key.name, err)
+		}
+		// a token signed with the public key as HMAC secret must not pass
+		if _, err = verifier.Verify(sign(jwt.SigningMethodHS256, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), valid)); err == nil {
+			t.Errorf("%s: expected HS256 to be rejected", key.name)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := tables[0].verifier.VerifyRequest(r); err != errNoBearerToken {
+		t.Errorf("expected errNoBearerToken, found %v", err)
+	}
+	for _, invalid := range []*JWTVerifier{{}, {Secret: "a", PublicKey: "b"}, {PublicKey: "missing.pem"}} {
+		if err := invalid.InitFunc(); err == nil {
+			t.Errorf("%+v: expected error", invalid)
+		}
+	}
+}
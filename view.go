@@ -4,16 +4,155 @@
 
 package brot
 
-import "html/template"
+import (
+	"encoding/xml"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+// viewTypes are the view types with their media types, in the order they
+// are offered to clients without preference.
+var viewTypes = []struct {
+	typ, media string
+}{
+	{"json", "application/json"},
+	{"html", "text/html"},
+	{"xml", "application/xml"},
+	{"csv", "text/csv"},
+	{"txt", "text/plain"},
+}
 
 // View renders data to a specific format
 type View struct {
-	template *template.Template
+	name, typ string
+	html      *htmltemplate.Template
+	text      *texttemplate.Template
+	funcs     *TemplateFuncs
+}
+
+// Create creates a new view of type dt, e.g. html or json
+func Create(name, dt, code string) (*View, error) {
+	return createView(name, dt, code, defaultTemplateFuncs)
 }
 
-// Create creates a new template
-func Create(name, dt, code string) *View {
+// createView parses html views with html/template, so that they are
+// escaped, and all other types with text/template. The output of xml and
+// csv views is escaped by the funcs of the same name, which are appended to
+// every action not ending with them. Actions printing a string constant,
+// e.g. {{"\n"}}, are not escaped.
+func createView(name, dt, code string, funcs *TemplateFuncs) (view *View, err error) {
 	qname := name + "@" + dt
-	t, _ := template.New(qname).Parse(code)
-	return &View{t}
+	view = &View{name: name, typ: dt, funcs: funcs}
+	if dt == "html" {
+		view.html, err = htmltemplate.New(qname).Funcs(funcs.FuncMap()).Parse(code)
+	} else {
+		view.text, err = texttemplate.New(qname).Funcs(texttemplate.FuncMap(funcs.FuncMap())).Funcs(viewEscapers).Parse(code)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := viewEscapers[dt]; ok {
+		for _, t := range view.text.Templates() {
+			if t.Tree != nil {
+				escapeActions(t.Tree.Root, dt)
+			}
+		}
+	}
+	return
+}
+
+// viewEscapers escape the output of the text views of their name.
+var viewEscapers = texttemplate.FuncMap{
+	"xml": func(args ...interface{}) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(escaperArg(args)))
+		return b.String()
+	},
+	"csv": func(args ...interface{}) string {
+		field := escaperArg(args)
+		if field == "" || !strings.ContainsAny(field, ",\"\r\n") && field[0] != ' ' && field[0] != '\t' {
+			return field
+		}
+		return `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+	},
+}
+
+// escaperArg formats the arguments of an escaper like print.
+func escaperArg(args []interface{}) string {
+	if len(args) == 1 {
+		if s, ok := args[0].(string); ok {
+			return s
+		}
+	}
+	return fmt.Sprint(args...)
+}
+
+// escapeActions appends the escaper to the output actions below node.
+func escapeActions(node parse.Node, escaper string) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, n := range node.Nodes {
+			escapeActions(n, escaper)
+		}
+	case *parse.IfNode:
+		escapeActions(node.List, escaper)
+		escapeActions(node.ElseList, escaper)
+	case *parse.RangeNode:
+		escapeActions(node.List, escaper)
+		escapeActions(node.ElseList, escaper)
+	case *parse.WithNode:
+		escapeActions(node.List, escaper)
+		escapeActions(node.ElseList, escaper)
+	case *parse.ActionNode:
+		// assignments print nothing
+		if len(node.Pipe.Decl) > 0 {
+			return
+		}
+		cmds := node.Pipe.Cmds
+		last := cmds[len(cmds)-1]
+		if _, ok := last.Args[0].(*parse.StringNode); ok && len(cmds) == 1 && len(last.Args) == 1 {
+			return
+		}
+		if ident, ok := last.Args[0].(*parse.IdentifierNode); ok && ident.Ident == escaper {
+			return
+		}
+		node.Pipe.Cmds = append(cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      last.Pos,
+			Args:     []parse.Node{parse.NewIdentifier(escaper).SetTree(nil).SetPos(last.Pos)},
+		})
+	}
+}
+
+// ContentType returns the media type of the view.
+func (v *View) ContentType() string {
+	for _, vt := range viewTypes {
+		if vt.typ == v.typ {
+			return vt.media + "; charset=utf-8"
+		}
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Execute renders data for the request r.
+func (v *View) Execute(w io.Writer, r *http.Request, data interface{}) error {
+	key := "view:" + v.name + "@" + v.typ
+	if v.html != nil {
+		return v.funcs.executeCached(key, v.html, w, r, data)
+	}
+	return v.funcs.bind(key, v.text, func(funcs htmltemplate.FuncMap) (executor, error) {
+		clone, err := v.text.Clone()
+		if err != nil {
+			return nil, err
+		}
+		return clone.Funcs(texttemplate.FuncMap(funcs)), nil
+	}, w, r, data)
 }
@@ -5,63 +5,209 @@
 package brot
 
 import (
-	"html/template"
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
-	"os"
+	"net/http"
 	"path/filepath"
 	"strings"
+
+	"github.com/fuxsig/brot/di"
 )
 
-// ViewRegistry is the registry for views
-type ViewRegistry map[string]View
+// ViewRegistry is the registry for views. A view is stored in a file named
+// name@type.ext, e.g. detailed@html.html, or name.type, e.g. detailed.csv.
+type ViewRegistry map[string]*View
 
 // Init initilaizes the view registry
 func (vr ViewRegistry) Init(paths []string) {
-	stack := make([]os.FileInfo, 0)
-
-	for _, path := range paths {
+	if err := vr.load(paths, defaultTemplateFuncs); err != nil {
+		fatal(logger("views"), "could not load views", "error", err)
+	}
+}
 
-		// open the file
-		f, err := os.Open(path)
-		if err != nil {
-			log.Fatal(err)
-		}
-		// read directory
-		files, err := f.Readdir(-1)
-		f.Close()
+func (vr ViewRegistry) load(paths []string, funcs *TemplateFuncs) error {
+	l := logger("views")
+	for _, dir := range paths {
+		files, err := ioutil.ReadDir(dir)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		// iterate over all files in directory
 		for _, file := range files {
 			if file.IsDir() {
-				stack = append(stack, file)
-			} else {
-				fullPath := filepath.Join(path, file.Name())
-				content, err := ioutil.ReadFile(fullPath)
-				if err != nil {
-					log.Fatal(err)
-				}
-				name := file.Name()
-				ext := filepath.Ext(name)
-				viewName := name[:len(name)-len(ext)]
-				viewType := "unknown"
-				if index := strings.Index(viewName, "@"); index >= 0 {
-					viewType = viewName[index+1:]
-					viewName = viewName[:index]
-
-				}
-				qname := viewName + "@" + viewType
-
-				switch strings.ToLower(ext) {
-				case ".html":
-					t, _ := template.New(qname).Parse(string(content))
-					vr[qname] = View{t}
-				}
-
+				continue
+			}
+			name := file.Name()
+			ext := strings.ToLower(filepath.Ext(name))
+			viewName := name[:len(name)-len(ext)]
+			viewType := strings.TrimPrefix(ext, ".")
+			if index := strings.Index(viewName, "@"); index >= 0 {
+				viewType = viewName[index+1:]
+				viewName = viewName[:index]
+			}
+			switch ext {
+			case ".html", ".json", ".xml", ".csv", ".txt", ".tmpl":
+			default:
+				continue
 			}
+			fullPath := filepath.Join(dir, name)
+			content, err := ioutil.ReadFile(fullPath)
+			if err != nil {
+				return err
+			}
+			view, err := createView(viewName, viewType, string(content), funcs)
+			if err != nil {
+				return fmt.Errorf("view %s: %s", fullPath, err.Error())
+			}
+			vr[viewName+"@"+viewType] = view
+			l.Info("loaded view", "path", fullPath, "view", viewName+"@"+viewType)
+		}
+	}
+	return nil
+}
+
+// viewFormats returns a copy of dl, which provides the format value of the
+// format query parameter or of the extension of the request path, e.g.
+// /items/42.csv. The parameter takes precedence and the format must be a
+// view type.
+func viewFormats(dl *DataLayer) (*DataLayer, error) {
+	types := make([]string, len(viewTypes))
+	for i, vt := range viewTypes {
+		types[i] = vt.typ
+	}
+	ext := &PathRegexpProvider{Regexp: `\.(?P<format>` + strings.Join(types, "|") + `)$`}
+	if err := ext.InitFunc(); err != nil {
+		return nil, err
+	}
+	result := dl.extend(map[string]*ValueRule{"format": {Type: EnumValue, Values: types, MaxCount: 1}},
+		&URLParameterProvider{Mapping: map[string]string{"format": "format"}}, ext)
+	if _, ok := result.Precedence["format"]; !ok {
+		result.Precedence["format"] = FirstValues
+	}
+	return result, result.InitFunc()
+}
+
+// defaultViewFormats is used by ViewRegistry.Render and nil Views.
+var defaultViewFormats = func() *DataLayer {
+	dl, err := viewFormats(nil)
+	if err != nil {
+		panic(err)
+	}
+	return dl
+}()
+
+// lookup selects the view name of format, or negotiates it if format is
+// empty. A nil view with ok set selects the built-in JSON rendering. If no
+// view is acceptable, the JSON view is selected.
+func (vr ViewRegistry) lookup(r *http.Request, format, name string) (view *View, ok bool) {
+	if format != "" {
+		view, ok = vr[name+"@"+format]
+		return view, ok || format == "json"
+	}
+	offers := make([]string, 0, len(viewTypes))
+	for _, vt := range viewTypes {
+		if _, ok = vr[name+"@"+vt.typ]; ok || vt.typ == "json" {
+			offers = append(offers, vt.media)
+		}
+	}
+	media := negotiateType(r, offers...)
+	for _, vt := range viewTypes {
+		if vt.media == media {
+			return vr[name+"@"+vt.typ], true
+		}
+	}
+	return vr[name+"@json"], true
+}
+
+// viewData is implemented by results passing other data to templates than
+// they encode as JSON.
+type viewData interface {
+	ViewData() interface{}
+}
+
+// Render renders data with the view name in the format requested by the
+// format value or the Accept header of r, see viewFormats. A format without
+// view is not acceptable, while a request accepting none of the views gets
+// JSON. Without a JSON view data is encoded as JSON.
+func (vr ViewRegistry) Render(w http.ResponseWriter, r *http.Request, name string, status int, data interface{}) {
+	vr.render(w, r, defaultViewFormats, name, status, data)
+}
+
+// render is Render with the format value of formats.
+func (vr ViewRegistry) render(w http.ResponseWriter, r *http.Request, formats *DataLayer, name string, status int, data interface{}) {
+	values, err := formats.Parse(r)
+	if err != nil {
+		Error(w, r, http.StatusBadRequest, err)
+		return
+	}
+	format, _ := values.Get("format")
+	view, ok := vr.lookup(r, format, name)
+	if !ok {
+		Error(w, r, http.StatusNotAcceptable, fmt.Errorf("view %s is not available in the requested format", name))
+		return
+	}
+	var (
+		buf         bytes.Buffer
+		contentType = "application/json"
+	)
+	if view != nil {
+		if vd, ok := data.(viewData); ok {
+			data = vd.ViewData()
+		}
+		if err := view.Execute(&buf, r, data); err != nil {
+			templateFailed(w, r, err)
+			return
 		}
+		contentType = view.ContentType()
+	} else if err := json.NewEncoder(&buf).Encode(data); err != nil {
+		Error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Add("Vary", "Accept")
+	if view == nil || view.html == nil {
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// Views renders the results of handlers with the views stored in Paths,
+// see ViewRegistry. The providers of Data may provide the format value, they
+// take precedence over the query parameter and the path extension. A nil
+// Views renders JSON.
+type Views struct {
+	Paths    []string       `brot:"paths"`
+	Funcs    *TemplateFuncs `brot:"funcs"`
+	Data     *DataLayer     `brot:"data"`
+	registry ViewRegistry
+}
+
+func (v *Views) InitFunc() (err error) {
+	if v.Funcs == nil {
+		v.Funcs = defaultTemplateFuncs
+	}
+	if v.Data, err = viewFormats(v.Data); err != nil {
+		return
+	}
+	v.registry = make(ViewRegistry)
+	return v.registry.load(v.Paths, v.Funcs)
+}
 
+func (v *Views) Retry() bool {
+	return false
+}
+
+func (v *Views) Render(w http.ResponseWriter, r *http.Request, name string, status int, data interface{}) {
+	if v == nil {
+		ViewRegistry(nil).Render(w, r, name, status, data)
+		return
 	}
+	v.registry.render(w, r, v.Data, name, status, data)
 }
+
+var _ di.ProvidesInit = (*Views)(nil)
+var _ = di.GlobalScope.Declare((*Views)(nil))
@@ -0,0 +1,92 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brot
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestViewRegistryRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"item@html.html": `<b>{{.name}}</b>`,
+		"item.csv":       `name,note{{"\n"}}{{.name}},{{.note}}`,
+		"item@txt.tmpl":  `<{{.name}}>`,
+		"item@xml.xml":   `<item note="{{.note}}">{{$name := .name}}{{if $name}}{{$name}}{{end}}</item>`,
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	views := &Views{Paths: []string{dir}}
+	if err = views.InitFunc(); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{"name": "<brot>", "note": `a,"b"`}
+
+	tables := []struct {
+		url, accept string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/item", "", 200, "application/json", `{"name":"\u003cbrot\u003e","note":"a,\"b\""}`},
+		{"/item", "text/html,application/xml;q=0.9,*/*;q=0.8", 200, "text/html; charset=utf-8", "<b>&lt;brot&gt;</b>"},
+		{"/item", "text/csv", 200, "text/csv; charset=utf-8", "name,note\n<brot>,\"a,\"\"b\"\"\""},
+		{"/item.txt", "text/html", 200, "text/plain; charset=utf-8", "<<brot>>"},
+		{"/item?format=json", "text/html", 200, "application/json", `{"name":"\u003cbrot\u003e","note":"a,\"b\""}`},
+		{"/item.xml", "", 200, "application/xml; charset=utf-8", `<item note="a,&#34;b&#34;">&lt;brot&gt;</item>`},
+		{"/item", "application/pdf", 200, "application/json", `{"name":"\u003cbrot\u003e","note":"a,\"b\""}`},
+		{"/item?format=pdf", "", 400, "", ""},
+		{"/item.csv?format=csv&format=xml", "", 400, "", ""},
+	}
+	for _, table := range tables {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", table.url, nil)
+		if table.accept != "" {
+			r.Header.Set("Accept", table.accept)
+		}
+		views.Render(w, r, "item", 200, data)
+		if w.Code != table.status {
+			t.Errorf("%s %s: expected status %d, found %d", table.url, table.accept, table.status, w.Code)
+			continue
+		}
+		if table.status != 200 {
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != table.contentType {
+			t.Errorf("%s %s: expected %s, found %s", table.url, table.accept, table.contentType, ct)
+		}
+		if body := strings.TrimSpace(w.Body.String()); body != table.body {
+			t.Errorf("%s %s: expected %q, found %q", table.url, table.accept, table.body, body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	views.Render(w, httptest.NewRequest("GET", "/other?format=xml", nil), "other", 200, data)
+	if w.Code != 406 {
+		t.Errorf("expected 406 for a format without view, found %d", w.Code)
+	}
+
+	var none *Views
+	w = httptest.NewRecorder()
+	none.Render(w, httptest.NewRequest("GET", "/", nil), "item", 201, data)
+	if w.Code != 201 || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected JSON without views, found %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if _, err = Create("broken", "html", "{{if}}"); err == nil {
+		t.Error("expected parse error")
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wrapper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseWriter is a http.ResponseWriter that records the response. It
+// supports http.Flusher and http.Hijacker if the underlying writer does.
+type ResponseWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Hijacker
+	// Status returns the status code, 0 if nothing has been written yet.
+	Status() int
+	// Size returns the number of body bytes written.
+	Size() int64
+	// Written reports whether the header has been written.
+	Written() bool
+	// Duration returns the time since the writer has been created.
+	Duration() time.Duration
+	// Tee copies all body bytes written from now on to dst.
+	Tee(dst io.Writer)
+	// Unwrap returns the underlying writer, see http.ResponseController.
+	Unwrap() http.ResponseWriter
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+	start  time.Time
+	tee    io.Writer
+}
+
+// NewResponseWriter returns a recording ResponseWriter for w. If w already
+// is a ResponseWriter it is returned unchanged, so that the whole chain shares
+// one recording.
+func NewResponseWriter(w http.ResponseWriter) ResponseWriter {
+	if rw, ok := w.(ResponseWriter); ok {
+		return rw
+	}
+	return &responseWriter{ResponseWriter: w, start: time.Now()}
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += int64(n)
+	if rw.tee != nil && n > 0 {
+		rw.tee.Write(b[:n])
+	}
+	return n, err
+}
+
+func (rw *responseWriter) Flush() {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("wrapper: %T does not support hijacking", rw.ResponseWriter)
+	}
+	conn, buf, err := h.Hijack()
+	if err == nil && rw.status == 0 {
+		// the connection belongs to the caller now
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+func (rw *responseWriter) Status() int {
+	return rw.status
+}
+
+func (rw *responseWriter) Size() int64 {
+	return rw.size
+}
+
+func (rw *responseWriter) Written() bool {
+	return rw.status != 0
+}
+
+func (rw *responseWriter) Duration() time.Duration {
+	return time.Since(rw.start)
+}
+
+func (rw *responseWriter) Tee(dst io.Writer) {
+	if rw.tee == nil || dst == nil {
+		rw.tee = dst
+		return
+	}
+	rw.tee = io.MultiWriter(rw.tee, dst)
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+var _ ResponseWriter = (*responseWriter)(nil)
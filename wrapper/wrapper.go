@@ -2,6 +2,8 @@ package wrapper
 
 import "net/http"
 
+// Handler is an element of a chain. It either answers the request itself or
+// passes it on to next.
 type Handler interface {
 	ServeChain(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc)
 }
@@ -12,21 +14,24 @@ func (h HandlerFunc) ServeChain(rw http.ResponseWriter, r *http.Request, next ht
 	h(rw, r, next)
 }
 
+// Wrapper is a chain of handlers. Every handler receives a ResponseWriter, so
+// that it can inspect the response written by the rest of the chain. A nil
+// Wrapper marks the end of the chain and does nothing.
 type Wrapper struct {
 	handler Handler
 	next    *Wrapper
 }
 
 func (m *Wrapper) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	m.handler.ServeChain(rw, r, m.next.ServeHTTP)
+	if m == nil {
+		return
+	}
+	m.handler.ServeChain(NewResponseWriter(rw), r, m.next.ServeHTTP)
 }
 
 func build(handlers []Handler) *Wrapper {
 	if len(handlers) == 0 {
-		return &Wrapper{
-			HandlerFunc(func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {}),
-			&Wrapper{},
-		}
+		return nil
 	}
 	return &Wrapper{handlers[0], build(handlers[1:])}
 }
@@ -35,9 +40,52 @@ func New(handlers ...Handler) *Wrapper {
 	return build(handlers)
 }
 
+// Wrap turns a http.Handler into a Handler. The chain is short-circuited if
+// the handler has written a response.
 func Wrap(handler http.Handler) Handler {
 	return HandlerFunc(func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-		handler.ServeHTTP(rw, r)
-		next(rw, r)
+		w := NewResponseWriter(rw)
+		handler.ServeHTTP(w, r)
+		if !w.Written() {
+			next(w, r)
+		}
+	})
+}
+
+// Before returns a Handler calling fn ahead of the rest of the chain. fn
+// returns the request passed on, or nil to stop the chain after it has
+// written a response itself.
+func Before(fn func(rw ResponseWriter, r *http.Request) *http.Request) Handler {
+	return HandlerFunc(func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		w := NewResponseWriter(rw)
+		if r = fn(w, r); r != nil {
+			next(w, r)
+		}
+	})
+}
+
+// After returns a Handler calling fn when the rest of the chain has
+// finished. rw reports status, size and duration of the response.
+func After(fn func(rw ResponseWriter, r *http.Request)) Handler {
+	return HandlerFunc(func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		w := NewResponseWriter(rw)
+		defer fn(w, r)
+		next(w, r)
+	})
+}
+
+// Middleware turns a Handler into a middleware as used by gorilla/mux.
+func Middleware(h Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			h.ServeChain(NewResponseWriter(rw), r, next.ServeHTTP)
+		})
+	}
+}
+
+// FromMiddleware turns a middleware as used by gorilla/mux into a Handler.
+func FromMiddleware(mw func(http.Handler) http.Handler) Handler {
+	return HandlerFunc(func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		mw(next).ServeHTTP(rw, r)
 	})
 }
@@ -0,0 +1,78 @@
+// Copyright 2018 Espen Reich. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wrapper
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShortCircuit(t *testing.T) {
+	calls := 0
+	first := Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	second := Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	rec := httptest.NewRecorder()
+	New(first, second).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if calls != 1 {
+		t.Errorf("expected 1 call, found %d", calls)
+	}
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("expected status %d, found %d", http.StatusTeapot, rec.Code)
+	}
+}
+
+func TestAfter(t *testing.T) {
+	var (
+		status int
+		size   int64
+		body   bytes.Buffer
+	)
+	chain := New(
+		Before(func(w ResponseWriter, r *http.Request) *http.Request {
+			w.Tee(&body)
+			return r
+		}),
+		After(func(w ResponseWriter, r *http.Request) {
+			status = w.Status()
+			size = w.Size()
+		}),
+		Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello")
+		})))
+	chain.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if status != http.StatusOK {
+		t.Errorf("expected status %d, found %d", http.StatusOK, status)
+	}
+	if size != 5 || body.String() != "hello" {
+		t.Errorf("expected 5 bytes hello, found %d bytes %s", size, body.String())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	mw := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test", "yes")
+			h.ServeHTTP(w, r)
+		})
+	}
+	h := Middleware(FromMiddleware(mw))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(ResponseWriter); !ok {
+			t.Error("expected wrapper.ResponseWriter")
+		}
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Header().Get("X-Test") != "yes" {
+		t.Error("expected header X-Test")
+	}
+}